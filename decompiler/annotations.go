@@ -0,0 +1,518 @@
+package decompiler
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"strings"
+)
+
+// infoReader walks the info bytes of an attribute. Reading past the end
+// sets err and returns zeros, so decoders check err once at the end.
+type infoReader struct {
+	info  []byte
+	pos   int
+	err   error
+	depth int
+}
+
+// maxNesting bounds how deep annotations and element values may nest, so
+// that crafted attributes cannot exhaust the stack.
+const maxNesting = 64
+
+func (this *infoReader) u1() byte {
+	if this.pos+1 > len(this.info) {
+		this.fail()
+		return 0
+	}
+	v := this.info[this.pos]
+	this.pos++
+	return v
+}
+
+func (this *infoReader) u2() uint16 {
+	if this.pos+2 > len(this.info) {
+		this.fail()
+		return 0
+	}
+	v := binary.BigEndian.Uint16(this.info[this.pos:])
+	this.pos += 2
+	return v
+}
+
+func (this *infoReader) u4() uint32 {
+	if this.pos+4 > len(this.info) {
+		this.fail()
+		return 0
+	}
+	v := binary.BigEndian.Uint32(this.info[this.pos:])
+	this.pos += 4
+	return v
+}
+
+func (this *infoReader) bytes(n int) []byte {
+	if n < 0 || this.pos+n > len(this.info) {
+		this.fail()
+		return nil
+	}
+	v := this.info[this.pos : this.pos+n]
+	this.pos += n
+	return v
+}
+
+func (this *infoReader) fail() {
+	if this.err == nil {
+		this.err = fmt.Errorf("attribute truncated at %d", this.pos)
+	}
+	this.pos = len(this.info)
+}
+
+type ElementValue struct {
+	tag               byte
+	const_value_index uint16
+	type_name_index   uint16
+	const_name_index  uint16
+	class_info_index  uint16
+	annotation_value  *Annotation
+	num_values        uint16
+	values            []ElementValue
+}
+
+type ElementValuePair struct {
+	element_name_index uint16
+	value              ElementValue
+}
+
+type Annotation struct {
+	type_index              uint16
+	num_element_value_pairs uint16
+	element_value_pairs     []ElementValuePair
+}
+
+type RuntimeAnnotationsAttribute struct {
+	attribute_name_index uint16
+	attribute_length     uint32
+	num_annotations      uint16
+	annotations          []Annotation
+}
+
+type ParameterAnnotationsAttribute struct {
+	attribute_name_index  uint16
+	attribute_length      uint32
+	num_parameters        byte
+	parameter_annotations [][]Annotation
+}
+
+type TypePathEntry struct {
+	type_path_kind      byte
+	type_argument_index byte
+}
+
+type TypeAnnotation struct {
+	target_type byte
+	target_info []byte
+	type_path   []TypePathEntry
+	annotation  Annotation
+}
+
+type TypeAnnotationsAttribute struct {
+	attribute_name_index uint16
+	attribute_length     uint32
+	num_annotations      uint16
+	annotations          []TypeAnnotation
+}
+
+type AnnotationDefaultAttribute struct {
+	attribute_name_index uint16
+	attribute_length     uint32
+	default_value        ElementValue
+}
+
+func (this *infoReader) elementValue() ElementValue {
+	ev := ElementValue{
+		tag: this.u1(),
+	}
+	if this.depth >= maxNesting {
+		if this.err == nil {
+			this.err = fmt.Errorf("element values nested deeper than %d", maxNesting)
+		}
+		return ev
+	}
+	this.depth++
+	defer func() { this.depth-- }()
+	switch ev.tag {
+	case 'B', 'C', 'D', 'F', 'I', 'J', 'S', 'Z', 's':
+		ev.const_value_index = this.u2()
+	case 'e':
+		ev.type_name_index = this.u2()
+		ev.const_name_index = this.u2()
+	case 'c':
+		ev.class_info_index = this.u2()
+	case '@':
+		a := this.annotation()
+		ev.annotation_value = &a
+	case '[':
+		ev.num_values = this.u2()
+		for i := uint16(0); i < ev.num_values && this.err == nil; i++ {
+			ev.values = append(ev.values, this.elementValue())
+		}
+	default:
+		if this.err == nil {
+			this.err = fmt.Errorf("unknown element_value tag %q", ev.tag)
+		}
+	}
+	return ev
+}
+
+func (this *infoReader) annotation() Annotation {
+	a := Annotation{
+		type_index:              this.u2(),
+		num_element_value_pairs: this.u2(),
+	}
+	for i := uint16(0); i < a.num_element_value_pairs && this.err == nil; i++ {
+		p := ElementValuePair{
+			element_name_index: this.u2(),
+		}
+		p.value = this.elementValue()
+		a.element_value_pairs = append(a.element_value_pairs, p)
+	}
+	return a
+}
+
+func (this *infoReader) annotations(n int) []Annotation {
+	var as []Annotation
+	for i := 0; i < n && this.err == nil; i++ {
+		as = append(as, this.annotation())
+	}
+	return as
+}
+
+// targetInfo reads the target_info that follows target_type, JVMS 4.7.20.1.
+func (this *infoReader) targetInfo(target byte) []byte {
+	switch target {
+	case 0x00, 0x01, 0x16:
+		return this.bytes(1)
+	case 0x10, 0x11, 0x12, 0x17, 0x42, 0x43, 0x44, 0x45, 0x46:
+		return this.bytes(2)
+	case 0x13, 0x14, 0x15:
+		return nil
+	case 0x40, 0x41:
+		start := this.pos
+		n := this.u2()
+		this.bytes(int(n) * 6)
+		if this.err != nil {
+			return nil
+		}
+		return this.info[start:this.pos]
+	case 0x47, 0x48, 0x49, 0x4a, 0x4b:
+		return this.bytes(3)
+	}
+	if this.err == nil {
+		this.err = fmt.Errorf("unknown type annotation target %#x", target)
+	}
+	return nil
+}
+
+func (this *AttributeInfo) ToRuntimeAnnotationsAttribute() (RuntimeAnnotationsAttribute, error) {
+	r := infoReader{info: this.info}
+	ra := RuntimeAnnotationsAttribute{
+		attribute_name_index: this.attribute_name_index,
+		attribute_length:     this.attribute_length,
+	}
+	ra.num_annotations = r.u2()
+	ra.annotations = r.annotations(int(ra.num_annotations))
+	return ra, r.err
+}
+
+func (this *AttributeInfo) ToParameterAnnotationsAttribute() (ParameterAnnotationsAttribute, error) {
+	r := infoReader{info: this.info}
+	pa := ParameterAnnotationsAttribute{
+		attribute_name_index: this.attribute_name_index,
+		attribute_length:     this.attribute_length,
+	}
+	pa.num_parameters = r.u1()
+	for i := 0; i < int(pa.num_parameters) && r.err == nil; i++ {
+		n := r.u2()
+		pa.parameter_annotations = append(pa.parameter_annotations, r.annotations(int(n)))
+	}
+	return pa, r.err
+}
+
+func (this *AttributeInfo) ToTypeAnnotationsAttribute() (TypeAnnotationsAttribute, error) {
+	r := infoReader{info: this.info}
+	ta := TypeAnnotationsAttribute{
+		attribute_name_index: this.attribute_name_index,
+		attribute_length:     this.attribute_length,
+	}
+	ta.num_annotations = r.u2()
+	for i := uint16(0); i < ta.num_annotations && r.err == nil; i++ {
+		t := TypeAnnotation{
+			target_type: r.u1(),
+		}
+		t.target_info = r.targetInfo(t.target_type)
+		n := r.u1()
+		for j := byte(0); j < n && r.err == nil; j++ {
+			t.type_path = append(t.type_path, TypePathEntry{
+				type_path_kind:      r.u1(),
+				type_argument_index: r.u1(),
+			})
+		}
+		t.annotation = r.annotation()
+		ta.annotations = append(ta.annotations, t)
+	}
+	return ta, r.err
+}
+
+func (this *AttributeInfo) ToAnnotationDefaultAttribute() (AnnotationDefaultAttribute, error) {
+	r := infoReader{info: this.info}
+	ad := AnnotationDefaultAttribute{
+		attribute_name_index: this.attribute_name_index,
+		attribute_length:     this.attribute_length,
+	}
+	ad.default_value = r.elementValue()
+	return ad, r.err
+}
+
+// annotationToString renders "@Name", "@Name(value)" or
+// "@Name(a = 1, b = 2)" and the imports it needs.
+func annotationToString(cp []CpInfo, a Annotation) (string, string) {
+	desc, err := utf8At(cp, a.type_index)
+	if err != nil {
+		return "/* " + err.Error() + " */", ""
+	}
+	t, imports, err := typeFromSignature(desc)
+	if err != nil {
+		return "/* " + commentSafe(err.Error()) + " */", ""
+	}
+	text := "@" + strings.ReplaceAll(strings.TrimSuffix(t, " "), "$", ".")
+	if len(a.element_value_pairs) == 0 {
+		return text, imports
+	}
+	text += "("
+	for i, p := range a.element_value_pairs {
+		name, _ := utf8At(cp, p.element_name_index)
+		value, imp := elementValueToString(cp, p.value)
+		imports += imp
+		if i > 0 {
+			text += ", "
+		}
+		if len(a.element_value_pairs) == 1 && name == "value" {
+			text += value
+		} else {
+			text += name + " = " + value
+		}
+	}
+	return text + ")", imports
+}
+
+func elementValueToString(cp []CpInfo, ev ElementValue) (string, string) {
+	switch ev.tag {
+	case 'B', 'C', 'I', 'S', 'Z':
+		if int(ev.const_value_index) > len(cp) || ev.const_value_index == 0 || cp[ev.const_value_index-1].tag != CONSTANT_Integer {
+			return "/* bad constant */", ""
+		}
+		c := CONSTANT_Integer_info{cp[ev.const_value_index-1]}
+		v := int32(c.Values())
+		switch ev.tag {
+		case 'C':
+			return javaChar(uint16(v)), ""
+		case 'Z':
+			return javaBoolean(v), ""
+		}
+		return javaInt(v), ""
+	case 'D', 'F', 'J':
+		// the element type is declared far from the use, so the literal
+		// carries its type as a suffix
+		if int(ev.const_value_index) > len(cp) || ev.const_value_index == 0 {
+			return "/* bad constant */", ""
+		}
+		switch c := cp[ev.const_value_index-1]; {
+		case ev.tag == 'D' && c.tag == CONSTANT_Double:
+			d := CONSTANT_Double_info{c}
+			v := d.Values()
+			if math.IsNaN(v) || math.IsInf(v, 0) {
+				return javaDouble(v), ""
+			}
+			return javaDouble(v) + "D", ""
+		case ev.tag == 'F' && c.tag == CONSTANT_Float:
+			f := CONSTANT_Float_info{c}
+			return javaFloat(f.Values()), ""
+		case ev.tag == 'J' && c.tag == CONSTANT_Long:
+			l := CONSTANT_Long_info{c}
+			return javaLong(l.Values()), ""
+		}
+		return "/* bad constant */", ""
+	case 's':
+		s, err := utf8At(cp, ev.const_value_index)
+		if err != nil {
+			return "/* bad constant */", ""
+		}
+		return javaString(s), ""
+	case 'e':
+		desc, _ := utf8At(cp, ev.type_name_index)
+		name, _ := utf8At(cp, ev.const_name_index)
+		t, imports, err := typeFromSignature(desc)
+		if err != nil {
+			return "/* " + commentSafe(err.Error()) + " */", ""
+		}
+		return strings.ReplaceAll(strings.TrimSuffix(t, " "), "$", ".") + "." + name, imports
+	case 'c':
+		desc, _ := utf8At(cp, ev.class_info_index)
+		t, imports, err := typeFromSignature(desc)
+		if err != nil {
+			return "/* " + commentSafe(err.Error()) + " */", ""
+		}
+		return strings.ReplaceAll(strings.TrimSuffix(t, " "), "$", ".") + ".class", imports
+	case '@':
+		if ev.annotation_value == nil {
+			return "/* bad annotation */", ""
+		}
+		return annotationToString(cp, *ev.annotation_value)
+	case '[':
+		if len(ev.values) == 1 {
+			return elementValueToString(cp, ev.values[0])
+		}
+		text := "{"
+		imports := ""
+		for i, v := range ev.values {
+			s, imp := elementValueToString(cp, v)
+			imports += imp
+			if i > 0 {
+				text += ", "
+			}
+			text += s
+		}
+		return text + "}", imports
+	}
+	return fmt.Sprintf("/* element_value %q */", ev.tag), ""
+}
+
+// annotationsCode renders the declaration annotations of a class, field or
+// method, visible and invisible, each followed by sep. Type annotations
+// written on the declared type itself (field type, return type) are
+// rendered too since they sit at the same place in the source. Releases
+// before annotations get them as comments.
+func annotationsCode(cp []CpInfo, attrs []AttributeInfo, sep string, release Release) (string, string) {
+	text := ""
+	imports := ""
+	seen := make(map[string]bool)
+	add := func(a Annotation) {
+		s, imp := annotationToString(cp, a)
+		if seen[s] {
+			return
+		}
+		seen[s] = true
+		if !release.Allows(FeatureAnnotations) {
+			s, imp = "/* "+s+" */", ""
+		}
+		text += s + sep
+		imports += imp
+	}
+	for _, name := range []string{"RuntimeVisibleAnnotations", "RuntimeInvisibleAnnotations"} {
+		attr := findAttribute(cp, attrs, name)
+		if attr == nil {
+			continue
+		}
+		ra, err := attr.ToRuntimeAnnotationsAttribute()
+		if err != nil {
+			// partially decoded annotations are not rendered
+			text += "/* " + name + ": " + commentSafe(err.Error()) + " */" + sep
+			continue
+		}
+		for _, a := range ra.annotations {
+			add(a)
+		}
+	}
+	for _, t := range typeAnnotations(cp, attrs) {
+		switch t.target_type {
+		case 0x13, 0x14:
+			if len(t.type_path) == 0 {
+				add(t.annotation)
+			}
+		}
+	}
+	return text, imports
+}
+
+// parameterAnnotationsCode renders the annotations of each of n formal
+// parameters. The attributes may skip leading synthetic parameters, so
+// their entries are aligned to the last parameter.
+func parameterAnnotationsCode(cp []CpInfo, attrs []AttributeInfo, n int, release Release) ([]string, string) {
+	texts := make([]string, n)
+	imports := ""
+	seen := make([]map[string]bool, n)
+	add := func(i int, a Annotation) {
+		if i < 0 || i >= n {
+			return
+		}
+		s, imp := annotationToString(cp, a)
+		if seen[i] == nil {
+			seen[i] = make(map[string]bool)
+		}
+		if seen[i][s] {
+			return
+		}
+		seen[i][s] = true
+		if !release.Allows(FeatureAnnotations) {
+			s, imp = "/* "+s+" */", ""
+		}
+		texts[i] += s + " "
+		imports += imp
+	}
+	for _, name := range []string{"RuntimeVisibleParameterAnnotations", "RuntimeInvisibleParameterAnnotations"} {
+		attr := findAttribute(cp, attrs, name)
+		if attr == nil {
+			continue
+		}
+		pa, err := attr.ToParameterAnnotationsAttribute()
+		if err != nil {
+			continue
+		}
+		offset := n - len(pa.parameter_annotations)
+		for i, as := range pa.parameter_annotations {
+			for _, a := range as {
+				add(offset+i, a)
+			}
+		}
+	}
+	for _, t := range typeAnnotations(cp, attrs) {
+		if t.target_type == 0x16 && len(t.type_path) == 0 {
+			add(int(t.target_info[0]), t.annotation)
+		}
+	}
+	return texts, imports
+}
+
+func typeAnnotations(cp []CpInfo, attrs []AttributeInfo) []TypeAnnotation {
+	var all []TypeAnnotation
+	for _, name := range []string{"RuntimeVisibleTypeAnnotations", "RuntimeInvisibleTypeAnnotations"} {
+		attr := findAttribute(cp, attrs, name)
+		if attr == nil {
+			continue
+		}
+		ta, err := attr.ToTypeAnnotationsAttribute()
+		if err != nil {
+			continue
+		}
+		all = append(all, ta.annotations...)
+	}
+	return all
+}
+
+// annotationDefaultCode renders " default value" for members of an
+// @interface.
+func annotationDefaultCode(cp []CpInfo, attrs []AttributeInfo, release Release) (string, string) {
+	attr := findAttribute(cp, attrs, "AnnotationDefault")
+	if attr == nil {
+		return "", ""
+	}
+	ad, err := attr.ToAnnotationDefaultAttribute()
+	if err != nil {
+		return " /* AnnotationDefault: " + err.Error() + " */", ""
+	}
+	value, imports := elementValueToString(cp, ad.default_value)
+	if !release.Allows(FeatureAnnotations) {
+		return " /* default " + value + " */", ""
+	}
+	return " default " + value, imports
+}
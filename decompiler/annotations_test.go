@@ -0,0 +1,54 @@
+package decompiler
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestAnnotations(t *testing.T) {
+	text := `
+.class public interface abstract a/T
+.super java/lang/Object
+.attribute RuntimeVisibleAnnotations { 0x0001 Utf8 "La/A;" 0x0005
+	Utf8 "d" 0x44 Double 1 Utf8 "n" 0x44 Double 0x7ff8000000000000
+	Utf8 "f" 0x46 Float 2.5 Utf8 "j" 0x4a Long 7 Utf8 "x" 0x4a Int 7 }
+.end class
+`
+	source, err := parseClass(t, assemble(t, text)).Source()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "@A(d = 1.0D, n = 0.0 / 0.0, f = 2.5F, j = 7L, x = /* bad constant */)"
+	if !strings.Contains(source, want) {
+		t.Errorf("source lacks %s:\n%s", want, source)
+	}
+	// annotations in a Java 1.4 class file, as from javac -target jsr14
+	source, err = parseClass(t, assemble(t, ".version 48 0\n"+text)).Source()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(source, "/* "+want+" */") {
+		t.Errorf("source lacks /* %s */:\n%s", want, source)
+	}
+}
+
+// TestNestedAnnotationLimit checks that annotations nested beyond
+// maxNesting become a comment instead of a partially decoded annotation.
+func TestNestedAnnotationLimit(t *testing.T) {
+	value := strings.Repeat(`0x40 Utf8 "La/A;" 0x0001 Utf8 "v" `, maxNesting+1) + `0x73 Utf8 "x"`
+	source, err := parseClass(t, assemble(t, `
+.class public interface abstract a/T
+.super java/lang/Object
+.attribute RuntimeVisibleAnnotations { 0x0001 Utf8 "La/A;" 0x0001 Utf8 "v" `+value+` }
+.end class
+`)).Source()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(source, "/* RuntimeVisibleAnnotations: element values nested deeper than") || strings.Contains(source, "@A") {
+		t.Errorf("the nesting limit is not reported:\n%s", source)
+	}
+	if s, _ := elementValueToString(nil, ElementValue{tag: '@'}); s != "/* bad annotation */" {
+		t.Errorf("an element value without annotation renders as %s", s)
+	}
+}
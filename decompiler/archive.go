@@ -0,0 +1,367 @@
+package decompiler
+
+import (
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Archive is a jar, war, ear or jmod file or a jimage runtime image.
+// Archives nested in it, such as the dependencies of a Spring Boot jar, are
+// read into memory.
+type Archive struct {
+	path   string
+	files  []entry
+	prefix string
+	image  bool
+	closer io.Closer
+}
+
+// maxNestedArchive bounds the size of a jar read into memory from
+// BOOT-INF/lib or WEB-INF/lib.
+var maxNestedArchive int64 = 1 << 28
+
+// entry is a file of an archive; directories are not listed.
+type entry struct {
+	name string
+	open func() (io.ReadCloser, error)
+}
+
+func zipEntries(r *zip.Reader) []entry {
+	files := make([]entry, 0, len(r.File))
+	for _, f := range r.File {
+		if f.FileInfo().IsDir() {
+			continue
+		}
+		files = append(files, entry{f.Name, f.Open})
+	}
+	return files
+}
+
+// OpenArchive opens a zip based archive (jar, war, ear), a jmod file or a
+// jimage, telling them apart by their magic number.
+func OpenArchive(name string) (*Archive, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	a, err := NewArchive(name, f, info.Size())
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	a.closer = f
+	return a, nil
+}
+
+// NewArchive reads an archive of the given size from r, which must stay
+// readable while the archive is used.
+func NewArchive(name string, r io.ReaderAt, size int64) (*Archive, error) {
+	magic := make([]byte, 4)
+	if _, err := r.ReadAt(magic, 0); err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	a := &Archive{
+		path: name,
+	}
+	switch {
+	case magic[0] == 'J' && magic[1] == 'M':
+		// jmod: "JM", version 1.0, then a zip file
+		zr, err := zip.NewReader(io.NewSectionReader(r, 4, size-4), size-4)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		a.files = zipEntries(zr)
+		a.prefix = "classes/"
+	case isImageMagic(magic):
+		files, err := imageEntries(r, size)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		a.files = files
+		a.image = true
+	default:
+		zr, err := zip.NewReader(r, size)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		a.files = zipEntries(zr)
+	}
+	return a, nil
+}
+
+// Close releases the file of an archive opened with OpenArchive. Nested
+// archives hold no file.
+func (this *Archive) Close() error {
+	if this.closer == nil {
+		return nil
+	}
+	return this.closer.Close()
+}
+
+// Path returns the archive file name; nested archives are named
+// outer.jar!/BOOT-INF/lib/inner.jar.
+func (this *Archive) Path() string {
+	return this.path
+}
+
+func (this *Archive) file(name string) *entry {
+	for i := range this.files {
+		if this.files[i].name == name {
+			return &this.files[i]
+		}
+	}
+	return nil
+}
+
+func (this *Archive) hasDir(prefix string) bool {
+	for _, f := range this.files {
+		if strings.HasPrefix(f.name, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// classPrefix is where the application classes are: classes/ in jmod
+// files, BOOT-INF/classes/ in Spring Boot jars, WEB-INF/classes/ in wars,
+// the root otherwise.
+func (this *Archive) classPrefix() string {
+	if this.prefix != "" {
+		return this.prefix
+	}
+	for _, prefix := range []string{"BOOT-INF/classes/", "WEB-INF/classes/"} {
+		if this.hasDir(prefix) {
+			return prefix
+		}
+	}
+	return ""
+}
+
+const versionsDir = "META-INF/versions/"
+
+// MultiRelease reports whether the manifest declares Multi-Release: true.
+// Only then are the classes of META-INF/versions/N used.
+func (this *Archive) MultiRelease() bool {
+	f := this.file("META-INF/MANIFEST.MF")
+	if f == nil {
+		return false
+	}
+	rc, err := f.open()
+	if err != nil {
+		return false
+	}
+	defer rc.Close()
+	scanner := bufio.NewScanner(rc)
+	for scanner.Scan() {
+		key, value, ok := strings.Cut(scanner.Text(), ":")
+		if ok && strings.EqualFold(strings.TrimSpace(key), "Multi-Release") {
+			return strings.EqualFold(strings.TrimSpace(value), "true")
+		}
+	}
+	return false
+}
+
+// version splits an entry below the class prefix into its release and the
+// name it has in that release: META-INF/versions/11/a/B.class is a/B.class
+// for release 11. Entries outside META-INF/versions are release 0.
+func version(rel string) (int, string, bool) {
+	if !strings.HasPrefix(rel, versionsDir) {
+		return 0, rel, true
+	}
+	n, name, ok := strings.Cut(rel[len(versionsDir):], "/")
+	if !ok {
+		return 0, "", false
+	}
+	v, err := strconv.Atoi(n)
+	if err != nil || v <= 0 {
+		return 0, "", false
+	}
+	return v, name, true
+}
+
+// Versions returns the releases with classes in META-INF/versions, in
+// ascending order, or nil when the archive is not multi release.
+func (this *Archive) Versions() []int {
+	if !this.MultiRelease() {
+		return nil
+	}
+	prefix := this.classPrefix()
+	seen := make(map[int]bool)
+	var versions []int
+	for _, f := range this.files {
+		if !strings.HasPrefix(f.name, prefix) || !strings.EqualFold(path.Ext(f.name), ".class") {
+			continue
+		}
+		if v, _, ok := version(f.name[len(prefix):]); ok && v > 0 && !seen[v] {
+			seen[v] = true
+			versions = append(versions, v)
+		}
+	}
+	sort.Ints(versions)
+	return versions
+}
+
+// sources lists the application class entries as seen by a JVM of the given
+// release: an entry of META-INF/versions/N with N <= release replaces the
+// base entry of the same name. Release 0 lists the base entries only, and
+// so does any release for archives that are not multi release. When only
+// is set, just the entries of META-INF/versions/<only> are listed.
+func (this *Archive) sources(release int, only bool) []source {
+	prefix := this.classPrefix()
+	multi := this.MultiRelease()
+	chosen := make(map[string]entry)
+	chosenVersion := make(map[string]int)
+	for _, f := range this.files {
+		if !strings.HasPrefix(f.name, prefix) ||
+			!strings.EqualFold(path.Ext(f.name), ".class") {
+			continue
+		}
+		v, name, ok := version(f.name[len(prefix):])
+		if !ok || v > 0 && (!multi || v > release) || only && v != release {
+			continue
+		}
+		if _, ok := chosen[name]; ok && chosenVersion[name] > v {
+			continue
+		}
+		chosen[name] = f
+		chosenVersion[name] = v
+	}
+	sources := make([]source, 0, len(chosen))
+	for name, f := range chosen {
+		sources = append(sources, source{
+			path:  this.path + "!/" + f.name,
+			class: name,
+			open:  f.open,
+		})
+	}
+	sort.Slice(sources, func(i, j int) bool {
+		return sources[i].path < sources[j].path
+	})
+	return sources
+}
+
+// Nested opens the jars of BOOT-INF/lib and WEB-INF/lib in memory, sorted
+// by name.
+func (this *Archive) Nested() ([]*Archive, error) {
+	var nested []*Archive
+	for _, f := range this.files {
+		dir, name := path.Split(f.name)
+		if dir != "BOOT-INF/lib/" && dir != "WEB-INF/lib/" || !strings.EqualFold(path.Ext(name), ".jar") {
+			continue
+		}
+		a, err := this.openNested(f)
+		if err != nil {
+			return nil, err
+		}
+		nested = append(nested, a)
+	}
+	sort.Slice(nested, func(i, j int) bool {
+		return nested[i].path < nested[j].path
+	})
+	return nested, nil
+}
+
+func (this *Archive) openNested(f entry) (*Archive, error) {
+	rc, err := f.open()
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	data, err := io.ReadAll(io.LimitReader(rc, maxNestedArchive+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > maxNestedArchive {
+		return nil, ClassError{this.path + "!/" + f.name, fmt.Errorf("nested archive larger than %d bytes", maxNestedArchive)}
+	}
+	r, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, ClassError{this.path + "!/" + f.name, err}
+	}
+	return &Archive{
+		path:  this.path + "!/" + f.name,
+		files: zipEntries(r),
+	}, nil
+}
+
+// Coordinates identify a Maven artifact.
+type Coordinates struct {
+	GroupId    string
+	ArtifactId string
+	Version    string
+}
+
+func (this Coordinates) String() string {
+	return this.GroupId + ":" + this.ArtifactId + ":" + this.Version
+}
+
+// Coordinates reads META-INF/maven/<group>/<artifact>/pom.properties. A
+// shaded jar has several; the one whose artifact the jar file is named
+// after wins, otherwise the first by name.
+func (this *Archive) Coordinates() (Coordinates, bool, error) {
+	var poms []entry
+	for _, f := range this.files {
+		if strings.HasPrefix(f.name, "META-INF/maven/") && path.Base(f.name) == "pom.properties" {
+			poms = append(poms, f)
+		}
+	}
+	if len(poms) == 0 {
+		return Coordinates{}, false, nil
+	}
+	sort.Slice(poms, func(i, j int) bool {
+		return poms[i].name < poms[j].name
+	})
+	pom := poms[0]
+	base := path.Base(this.path)
+	for _, f := range poms {
+		artifact := path.Base(path.Dir(f.name))
+		if strings.HasPrefix(base, artifact+"-") || base == artifact+".jar" {
+			pom = f
+			break
+		}
+	}
+	rc, err := pom.open()
+	if err != nil {
+		return Coordinates{}, false, err
+	}
+	defer rc.Close()
+	properties, err := readProperties(rc)
+	if err != nil {
+		return Coordinates{}, false, err
+	}
+	return Coordinates{
+		GroupId:    properties["groupId"],
+		ArtifactId: properties["artifactId"],
+		Version:    properties["version"],
+	}, true, nil
+}
+
+// readProperties reads the key=value lines of a .properties file as Maven
+// writes them; continuation lines and escapes are not supported.
+func readProperties(r io.Reader) (map[string]string, error) {
+	properties := make(map[string]string)
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == '#' || line[0] == '!' {
+			continue
+		}
+		if i := strings.IndexAny(line, "=:"); i > 0 {
+			properties[strings.TrimSpace(line[:i])] = strings.TrimSpace(line[i+1:])
+		}
+	}
+	return properties, scanner.Err()
+}
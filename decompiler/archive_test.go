@@ -0,0 +1,36 @@
+package decompiler
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+)
+
+// zeros is an endless entry, as a zip bomb inflates to.
+type zeros struct{}
+
+func (zeros) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = 0
+	}
+	return len(p), nil
+}
+
+func TestNestedArchiveLimit(t *testing.T) {
+	defer func(limit int64) { maxNestedArchive = limit }(maxNestedArchive)
+	maxNestedArchive = 1 << 10
+	a := &Archive{path: "app.jar", files: []entry{{"BOOT-INF/lib/big.jar", func() (io.ReadCloser, error) {
+		return io.NopCloser(zeros{}), nil
+	}}}}
+	if _, err := a.Nested(); err == nil || !strings.Contains(err.Error(), "app.jar!/BOOT-INF/lib/big.jar") {
+		t.Errorf("an endless nested jar gives %v", err)
+	}
+	a.files[0].open = func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(make([]byte, 1<<10))), nil
+	}
+	// within the limit, it is read and fails as no zip file
+	if _, err := a.Nested(); err == nil || strings.Contains(err.Error(), "larger than") {
+		t.Errorf("a nested jar within the limit gives %v", err)
+	}
+}
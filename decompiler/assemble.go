@@ -0,0 +1,738 @@
+package decompiler
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Assemble reads a class in the text format that Disassemble writes and
+// builds it through the class model, for hand-crafted class files that
+// reproduce decompiler bugs:
+//
+//	.version 52 0
+//	.class public super demo/Hello
+//	.super java/lang/Object
+//	.attribute SourceFile { Utf8 "Hello.java" }
+//
+//	.field private static final N I
+//		.attribute ConstantValue { Int 42 }
+//
+//	.method public static main ([Ljava/lang/String;)V
+//		.code stack 2 locals 1
+//		L0:
+//			getstatic Field java/lang/System out Ljava/io/PrintStream;
+//			ldc String "hello"
+//			invokevirtual Method java/io/PrintStream println (Ljava/lang/String;)V
+//		L8:
+//			return
+//		L9:
+//			athrow
+//			.catch Class java/lang/RuntimeException from L0 to L8 using L9
+//		.end code
+//	.end method
+//
+//	.end class
+//
+// A statement is one line; inside braces it goes on across lines. Tokens
+// are separated by white space, ";" at the start of a token comments out
+// the rest of the line, and names with spaces or other special characters
+// are written as Go string literals.
+//
+// Constant pool entries are written by value and added as they are
+// needed, as Utf8 "text", Int 1, Float 1.5, Long 1, Double 1.5, Class
+// name, String "text", MethodType descriptor, Module name, Package name,
+// NameAndType name descriptor, Field, Method or InterfaceMethod owner name
+// descriptor, MethodHandle kind reference, and Dynamic or InvokeDynamic
+// bootstrap name descriptor, where bootstrap indexes the BootstrapMethods
+// attribute and kind is getField, invokeStatic and so on. Floats written
+// as 0x... are raw bits. [N] writes index N as is, for references that are
+// broken on purpose.
+//
+// Names and descriptors of the class, its super types and its members are
+// written without a tag. Attributes following .field belong to the field;
+// class attributes go before the first member. Instructions take their
+// javap operands, with labels as branch targets:
+//
+//	tableswitch 0 { L1 L2 } default L3
+//	lookupswitch { 1 L1 10 L2 } default L3
+//	wide iinc 300 1
+//	invokeinterface InterfaceMethod java/util/List size ()I 1
+//	multianewarray Class [[I 2
+//	newarray int
+//
+// Attributes other than Code are written as a list of hex bytes and
+// constant references, each reference taking two bytes. Offsets in the
+// attributes of Code, such as LineNumberTable and StackMapTable, are bytes
+// too, so they go stale when instructions are inserted. Without .version
+// the class gets version 49, which needs no StackMapTable.
+func Assemble(name string, r io.Reader) (*ClassFile, error) {
+	src, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	statements, err := lexAssembly(src)
+	if err != nil {
+		return nil, fmt.Errorf("%s:%v", name, err)
+	}
+	this := &assembler{
+		file:       name,
+		statements: statements,
+		class:      &ClassFile{magic: MAGIC, major_version: 49},
+	}
+	this.assemble()
+	if this.err != nil {
+		return nil, this.err
+	}
+	return this.class, nil
+}
+
+type asmToken struct {
+	text   string
+	quoted bool
+	line   int
+}
+
+// is reports whether the token is the keyword or punctuation s.
+func (this asmToken) is(s string) bool {
+	return !this.quoted && this.text == s
+}
+
+// lexAssembly splits the source into statements of tokens.
+func lexAssembly(src []byte) ([][]asmToken, error) {
+	text := string(src)
+	var statements [][]asmToken
+	var current []asmToken
+	line, depth := 1, 0
+	for i := 0; i < len(src); {
+		c := src[i]
+		switch {
+		case c == '\n':
+			if depth == 0 && len(current) > 0 {
+				statements = append(statements, current)
+				current = nil
+			}
+			line++
+			i++
+		case c == ' ' || c == '\t' || c == '\r':
+			i++
+		case c == ';':
+			for i < len(src) && src[i] != '\n' {
+				i++
+			}
+		case c == '"':
+			q, err := strconv.QuotedPrefix(text[i:])
+			if err != nil {
+				return nil, fmt.Errorf("%d: malformed string", line)
+			}
+			s, _ := strconv.Unquote(q)
+			current = append(current, asmToken{s, true, line})
+			i += len(q)
+		case c == '{' || c == '}':
+			if c == '{' {
+				depth++
+			} else if depth--; depth < 0 {
+				return nil, fmt.Errorf("%d: unexpected }", line)
+			}
+			current = append(current, asmToken{string(c), false, line})
+			i++
+		default:
+			start := i
+			for i < len(src) && !strings.ContainsRune(" \t\r\n{}", rune(src[i])) {
+				i++
+			}
+			current = append(current, asmToken{string(src[start:i]), false, line})
+		}
+	}
+	if depth > 0 {
+		return nil, fmt.Errorf("%d: missing }", line)
+	}
+	if len(current) > 0 {
+		statements = append(statements, current)
+	}
+	return statements, nil
+}
+
+// assembler builds a class statement by statement. Like infoReader it
+// keeps the first error and returns zeros after it, so callers check err
+// once per statement.
+type assembler struct {
+	file       string
+	statements [][]asmToken
+	tokens     []asmToken
+	pos        int
+	line       int
+	class      *ClassFile
+	err        error
+}
+
+// statement moves to the next statement.
+func (this *assembler) statement() bool {
+	if this.err != nil || len(this.statements) == 0 {
+		return false
+	}
+	this.tokens, this.statements = this.statements[0], this.statements[1:]
+	this.pos = 0
+	this.line = this.tokens[0].line
+	return true
+}
+
+func (this *assembler) errorf(format string, args ...interface{}) {
+	if this.err == nil {
+		this.err = fmt.Errorf("%s:%d: %s", this.file, this.line, fmt.Sprintf(format, args...))
+	}
+}
+
+func (this *assembler) more() bool {
+	return this.err == nil && this.pos < len(this.tokens)
+}
+
+func (this *assembler) token() asmToken {
+	if !this.more() {
+		this.errorf("unexpected end of statement")
+		return asmToken{}
+	}
+	t := this.tokens[this.pos]
+	this.pos++
+	this.line = t.line
+	return t
+}
+
+// peek reports whether the next token is the keyword s.
+func (this *assembler) peek(s string) bool {
+	return this.more() && this.tokens[this.pos].is(s)
+}
+
+func (this *assembler) expect(keyword string) {
+	if t := this.token(); !t.is(keyword) && this.err == nil {
+		this.errorf("expected %s, found %q", keyword, t.text)
+	}
+}
+
+// end checks that the statement has no tokens left.
+func (this *assembler) end() {
+	if this.more() {
+		this.errorf("unexpected %q", this.tokens[this.pos].text)
+	}
+}
+
+func (this *assembler) signed(bits int) int64 {
+	t := this.token()
+	v, err := strconv.ParseInt(t.text, 0, bits)
+	if err != nil {
+		this.errorf("%q is not a %d bit integer", t.text, bits)
+	}
+	return v
+}
+
+func (this *assembler) unsigned(bits int) uint64 {
+	t := this.token()
+	v, err := strconv.ParseUint(t.text, 0, bits)
+	if err != nil {
+		this.errorf("%q is not an unsigned %d bit integer", t.text, bits)
+	}
+	return v
+}
+
+// float returns the bits of a float written in decimal or as raw bits.
+func (this *assembler) float(bits int) uint64 {
+	t := this.token()
+	if strings.HasPrefix(t.text, "0x") {
+		v, err := strconv.ParseUint(t.text[2:], 16, bits)
+		if err != nil {
+			this.errorf("%q is not a %d bit float", t.text, bits)
+		}
+		return v
+	}
+	v, err := strconv.ParseFloat(t.text, bits)
+	if err != nil {
+		this.errorf("%q is not a %d bit float", t.text, bits)
+	}
+	if bits == 32 {
+		return uint64(math.Float32bits(float32(v)))
+	}
+	return math.Float64bits(v)
+}
+
+// add passes on the index of an added constant.
+func (this *assembler) add(i uint16, err error) uint16 {
+	if err != nil {
+		this.errorf("%v", err)
+	}
+	return i
+}
+
+func indexOf(t asmToken) (uint16, bool) {
+	if t.quoted || !rawIndex.MatchString(t.text) {
+		return 0, false
+	}
+	v, err := strconv.ParseUint(t.text[1:len(t.text)-1], 10, 16)
+	return uint16(v), err == nil
+}
+
+// name adds the Utf8 entry of a name or descriptor.
+func (this *assembler) name() uint16 {
+	t := this.token()
+	if i, ok := indexOf(t); ok || this.err != nil {
+		return i
+	}
+	return this.add(this.class.AddUtf8(t.text))
+}
+
+func (this *assembler) className() uint16 {
+	t := this.token()
+	if i, ok := indexOf(t); ok || this.err != nil {
+		return i
+	}
+	return this.add(this.class.AddClass(t.text))
+}
+
+// constant adds the entry written by value with its tag.
+func (this *assembler) constant() uint16 {
+	t := this.token()
+	if i, ok := indexOf(t); ok || this.err != nil {
+		return i
+	}
+	cf := this.class
+	tag := ConstantTag(0)
+	for k, v := range constantTagNames {
+		if t.is(v) {
+			tag = k
+		}
+	}
+	switch tag {
+	case CONSTANT_Utf8:
+		return this.add(cf.AddUtf8(this.token().text))
+	case CONSTANT_Integer:
+		return this.add(cf.AddInteger(int32(this.signed(32))))
+	case CONSTANT_Float:
+		return this.add(cf.AddFloat(math.Float32frombits(uint32(this.float(32)))))
+	case CONSTANT_Long:
+		return this.add(cf.AddLong(this.signed(64)))
+	case CONSTANT_Double:
+		return this.add(cf.AddDouble(math.Float64frombits(this.float(64))))
+	case CONSTANT_Class, CONSTANT_String, CONSTANT_MethodType, CONSTANT_Module, CONSTANT_Package:
+		return this.add(cf.addNamed(tag, this.token().text))
+	case CONSTANT_NameAndType:
+		name, desc := this.token().text, this.token().text
+		return this.add(cf.AddNameAndType(name, desc))
+	case CONSTANT_Fieldref, CONSTANT_Methodref, CONSTANT_InterfaceMethodref:
+		owner, name, desc := this.token().text, this.token().text, this.token().text
+		return this.add(cf.AddMemberRef(tag, owner, name, desc))
+	case CONSTANT_MethodHandle:
+		kind := this.referenceKind()
+		return this.add(cf.AddMethodHandle(kind, this.constant()))
+	case CONSTANT_Dynamic, CONSTANT_InvokeDynamic:
+		bootstrap := uint16(this.unsigned(16))
+		name, desc := this.token().text, this.token().text
+		return this.add(cf.AddDynamic(tag, bootstrap, name, desc))
+	}
+	this.errorf("unknown constant %q", t.text)
+	return 0
+}
+
+func (this *assembler) referenceKind() ReferenceKind {
+	t := this.token()
+	for k, name := range referenceKindNames {
+		if name != "" && t.is(name) {
+			return ReferenceKind(k)
+		}
+	}
+	this.pos--
+	return ReferenceKind(this.unsigned(8))
+}
+
+// flags reads access flags up to the last n tokens of the statement.
+func (this *assembler) flags(names []struct {
+	flag AccessFlags
+	name string
+}, n int) AccessFlags {
+	var flags AccessFlags
+	for this.more() && len(this.tokens)-this.pos > n {
+		t := this.token()
+		known := false
+		for _, f := range names {
+			if t.is(f.name) {
+				flags |= f.flag
+				known = true
+			}
+		}
+		if !known {
+			this.pos--
+			flags |= AccessFlags(this.unsigned(16))
+		}
+	}
+	return flags
+}
+
+func (this *assembler) assemble() {
+	class := this.class
+	attributes := &class.attributes
+	named := false
+	for this.statement() {
+		switch d := this.token(); {
+		case d.is(".version"):
+			class.major_version = uint16(this.unsigned(16))
+			class.minor_version = uint16(this.unsigned(16))
+		case d.is(".class"):
+			if named {
+				this.errorf("second .class")
+			}
+			named = true
+			class.access_flags = this.flags(classFlagNames, 1)
+			class.this_class = this.className()
+		case d.is(".super"):
+			class.super_class = this.className()
+		case d.is(".implements"):
+			class.interfaces = append(class.interfaces, this.className())
+		case d.is(".attribute"):
+			if attributes == nil {
+				this.errorf("class attributes go before the fields and methods")
+				break
+			}
+			*attributes = append(*attributes, this.attribute())
+		case d.is(".field"):
+			f := FieldInfo{access_flags: this.flags(fieldFlagNames, 2)}
+			f.name_index = this.name()
+			f.descriptor_index = this.name()
+			class.fields = append(class.fields, f)
+			attributes = &class.fields[len(class.fields)-1].attributes
+		case d.is(".method"):
+			class.methods = append(class.methods, this.method())
+			attributes = nil
+		case d.is(".end"):
+			this.expect("class")
+			this.end()
+			if this.statement() {
+				this.errorf("statement after .end class")
+			}
+			if !named {
+				this.errorf("missing .class")
+			}
+			this.finish()
+			return
+		default:
+			this.errorf("unexpected %q", d.text)
+		}
+		this.end()
+	}
+	this.errorf("missing .end class")
+}
+
+// finish sets the counts of the tables.
+func (this *assembler) finish() {
+	class := this.class
+	class.constant_pool_count = uint16(len(class.constant_pool) + 1)
+	class.interfaces_count = uint16(len(class.interfaces))
+	class.fields_count = uint16(len(class.fields))
+	for i := range class.fields {
+		class.fields[i].attributes_count = uint16(len(class.fields[i].attributes))
+	}
+	class.methods_count = uint16(len(class.methods))
+	for i := range class.methods {
+		class.methods[i].attributes_count = uint16(len(class.methods[i].attributes))
+	}
+	class.attributes_count = uint16(len(class.attributes))
+}
+
+func (this *assembler) method() MethodInfo {
+	m := MethodInfo{access_flags: this.flags(methodFlagNames, 2)}
+	m.name_index = this.name()
+	m.descriptor_index = this.name()
+	this.end()
+	for this.statement() {
+		switch d := this.token(); {
+		case d.is(".attribute"):
+			m.attributes = append(m.attributes, this.attribute())
+		case d.is(".code"):
+			m.attributes = append(m.attributes, this.code())
+		case d.is(".end"):
+			this.expect("method")
+			return m
+		default:
+			this.errorf("unexpected %q", d.text)
+		}
+		this.end()
+	}
+	this.errorf("missing .end method")
+	return m
+}
+
+// attribute reads "name { items }", where items are hex bytes and
+// constant references.
+func (this *assembler) attribute() AttributeInfo {
+	a := AttributeInfo{attribute_name_index: this.name()}
+	this.expect("{")
+	for this.more() {
+		t := this.token()
+		if t.is("}") {
+			break
+		}
+		if !t.quoted && strings.HasPrefix(t.text, "0x") {
+			b, err := hex.DecodeString(t.text[2:])
+			if err != nil {
+				this.errorf("%q is not hex bytes", t.text)
+			}
+			a.info = append(a.info, b...)
+			continue
+		}
+		this.pos--
+		a.info = append(a.info, u2bytes(this.constant())...)
+	}
+	a.attribute_length = uint32(len(a.info))
+	return a
+}
+
+var opcodeByName = func() map[string]byte {
+	m := make(map[string]byte)
+	for op, name := range opcodeNames {
+		if name != "" {
+			m[name] = byte(op)
+		}
+	}
+	return m
+}()
+
+// fixup is a branch offset to fill in once the labels are known.
+type fixup struct {
+	pc, at, size int
+	label        string
+	line         int
+}
+
+type codeAssembler struct {
+	code    []byte
+	labels  map[string]int
+	fixups  []fixup
+	catches []fixup
+}
+
+func (this *assembler) code() AttributeInfo {
+	a := AttributeInfo{attribute_name_index: this.add(this.class.AddUtf8("Code"))}
+	this.expect("stack")
+	stack := uint16(this.unsigned(16))
+	this.expect("locals")
+	locals := uint16(this.unsigned(16))
+	this.end()
+	c := &codeAssembler{labels: make(map[string]int)}
+	var table []ExceptionTable
+	var attrs []AttributeInfo
+	for this.statement() {
+		t := this.token()
+		if !t.quoted && len(t.text) > 1 && strings.HasSuffix(t.text, ":") {
+			label := strings.TrimSuffix(t.text, ":")
+			if _, ok := c.labels[label]; ok {
+				this.errorf("label %s defined twice", label)
+			}
+			c.labels[label] = len(c.code)
+			if !this.more() {
+				continue
+			}
+			t = this.token()
+		}
+		switch {
+		case t.is(".catch"):
+			e := ExceptionTable{}
+			if this.peek("any") {
+				this.pos++
+			} else {
+				e.catch_type = this.constant()
+			}
+			i := len(table)
+			for _, keyword := range []string{"from", "to", "using"} {
+				this.expect(keyword)
+				c.catches = append(c.catches, fixup{pc: i, label: this.token().text, line: this.line})
+			}
+			table = append(table, e)
+		case t.is(".attribute"):
+			attrs = append(attrs, this.attribute())
+		case t.is(".end"):
+			this.expect("code")
+			this.end()
+			if this.err != nil {
+				return a
+			}
+			c.resolve(this, table)
+			var info []byte
+			info = append(info, u2bytes(stack, locals)...)
+			info = append(info, u4bytes(uint64(len(c.code)), 4)...)
+			info = append(info, c.code...)
+			info = append(info, u2bytes(uint16(len(table)))...)
+			for _, e := range table {
+				info = append(info, u2bytes(e.start_pc, e.end_pc, e.handler_pc, e.catch_type)...)
+			}
+			info = append(info, u2bytes(uint16(len(attrs)))...)
+			for _, attr := range attrs {
+				info = append(info, u2bytes(attr.attribute_name_index)...)
+				info = append(info, u4bytes(uint64(len(attr.info)), 4)...)
+				info = append(info, attr.info...)
+			}
+			if len(c.code) > math.MaxUint16 {
+				this.errorf("%d bytes of code, at most %d fit in a method", len(c.code), math.MaxUint16)
+			}
+			a.info = info
+			a.attribute_length = uint32(len(info))
+			return a
+		default:
+			this.instruction(c, t)
+		}
+		this.end()
+	}
+	this.errorf("missing .end code")
+	return a
+}
+
+// resolve fills in the branch offsets and the exception table.
+func (this *codeAssembler) resolve(a *assembler, table []ExceptionTable) {
+	target := func(f fixup) int {
+		pc, ok := this.labels[f.label]
+		if !ok {
+			a.line = f.line
+			a.errorf("undefined label %s", f.label)
+		}
+		return pc
+	}
+	for _, f := range this.fixups {
+		offset := target(f) - f.pc
+		if f.size == 2 {
+			if offset < math.MinInt16 || offset > math.MaxInt16 {
+				a.line = f.line
+				a.errorf("branch to %s out of range, use goto_w", f.label)
+			}
+			binary.BigEndian.PutUint16(this.code[f.at:], uint16(offset))
+		} else {
+			binary.BigEndian.PutUint32(this.code[f.at:], uint32(offset))
+		}
+	}
+	for i, f := range this.catches {
+		pc := uint16(target(f))
+		e := &table[f.pc]
+		switch i % 3 {
+		case 0:
+			e.start_pc = pc
+		case 1:
+			e.end_pc = pc
+		default:
+			e.handler_pc = pc
+		}
+	}
+}
+
+// branch reserves size bytes at the end of the code for the offset from pc
+// to the label.
+func (this *codeAssembler) branch(a *assembler, pc, size int) {
+	this.fixups = append(this.fixups, fixup{pc: pc, at: len(this.code), size: size, label: a.token().text, line: a.line})
+	this.code = append(this.code, make([]byte, size)...)
+}
+
+func (this *assembler) instruction(c *codeAssembler, t asmToken) {
+	op, ok := opcodeByName[t.text]
+	if !ok || t.quoted {
+		this.errorf("unknown instruction %q", t.text)
+		return
+	}
+	pc := len(c.code)
+	c.code = append(c.code, op)
+	u1 := func(v uint64) {
+		c.code = append(c.code, byte(v))
+	}
+	u2 := func(v uint16) {
+		c.code = append(c.code, u2bytes(v)...)
+	}
+	u4 := func(v int64) {
+		c.code = append(c.code, u4bytes(uint64(v), 4)...)
+	}
+	switch b := op; {
+	case b == 0xc4:
+		sub := this.token()
+		switch {
+		case sub.is("iinc"):
+			u1(0x84)
+			u2(uint16(this.unsigned(16)))
+			u2(uint16(this.signed(16)))
+		case isLocalInstruction(opcodeByName[sub.text]) && !sub.quoted:
+			u1(uint64(opcodeByName[sub.text]))
+			u2(uint16(this.unsigned(16)))
+		default:
+			this.errorf("%q cannot be wide", sub.text)
+		}
+	case isLocalInstruction(b):
+		u1(this.unsigned(8))
+	case b == 0x10:
+		u1(uint64(this.signed(8)))
+	case b == 0x11:
+		u2(uint16(this.signed(16)))
+	case b == 0x84:
+		u1(this.unsigned(8))
+		u1(uint64(this.signed(8)))
+	case b == 0xbc:
+		elem := this.token()
+		for k, name := range newarrayNames {
+			if elem.is(name) {
+				u1(uint64(k))
+				return
+			}
+		}
+		this.pos--
+		u1(this.unsigned(8))
+	case b == 0x12:
+		i := this.constant()
+		if i > math.MaxUint8 {
+			this.errorf("constant pool index %d does not fit ldc, use ldc_w", i)
+		}
+		u1(uint64(i))
+	case b == 0xb9:
+		u2(this.constant())
+		u1(this.unsigned(8))
+		u1(0)
+	case b == 0xba:
+		u2(this.constant())
+		u2(0)
+	case b == 0xc5:
+		u2(this.constant())
+		u1(this.unsigned(8))
+	case b == 0x13 || b == 0x14 || b >= 0xb2 && b <= 0xb8 || b == 0xbb || b == 0xbd || b == 0xc0 || b == 0xc1:
+		u2(this.constant())
+	case b >= 0x99 && b <= 0xa8 || b == 0xc6 || b == 0xc7:
+		c.branch(this, pc, 2)
+	case b == 0xc8 || b == 0xc9:
+		c.branch(this, pc, 4)
+	case b == 0xaa || b == 0xab:
+		for len(c.code)%4 != 0 {
+			u1(0)
+		}
+		def := len(c.code)
+		u4(0)
+		if b == 0xaa {
+			low := this.signed(32)
+			u4(low)
+			high := len(c.code)
+			u4(0)
+			this.expect("{")
+			n := int64(0)
+			for this.more() && !this.peek("}") {
+				c.branch(this, pc, 4)
+				n++
+			}
+			binary.BigEndian.PutUint32(c.code[high:], uint32(low+n-1))
+		} else {
+			npairs := len(c.code)
+			u4(0)
+			this.expect("{")
+			n := uint32(0)
+			for this.more() && !this.peek("}") {
+				u4(this.signed(32))
+				c.branch(this, pc, 4)
+				n++
+			}
+			binary.BigEndian.PutUint32(c.code[npairs:], n)
+		}
+		this.expect("}")
+		this.expect("default")
+		c.fixups = append(c.fixups, fixup{pc: pc, at: def, size: 4, label: this.token().text, line: this.line})
+	}
+}
@@ -0,0 +1,138 @@
+package decompiler
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func disassemble(t *testing.T, b []byte) string {
+	text, err := parseClass(t, b).Disassemble()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return text
+}
+
+func assemble(t *testing.T, text string) []byte {
+	class, err := Assemble("test.j", strings.NewReader(text))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := class.Bytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+// TestAssembleRoundTrip assembles the disassembly of the golden classes:
+// the result disassembles to the same text and decompiles to the same
+// source.
+func TestAssembleRoundTrip(t *testing.T) {
+	for _, b := range goldenClasses(t) {
+		text := disassemble(t, b)
+		out := assemble(t, text)
+		if got := disassemble(t, out); got != text {
+			t.Errorf("disassembly differs after assembling at %s", firstDifference(got, text))
+			continue
+		}
+		want, err := parseClass(t, b).Source()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, err := parseClass(t, out).Source(); err != nil || got != want {
+			t.Errorf("source differs after assembling: %v at %s", err, firstDifference(got, want))
+		}
+	}
+}
+
+const handWritten = `
+; labels, switches and constants the javac corpus does not cover
+.class public super "odd name"
+.super java/lang/Object
+.attribute InnerClasses { 0x0001 Class "odd name$1" [0] [0] 0x0000 }
+
+.field static final NaN F
+	.attribute ConstantValue { Float 0x7fc00001 }
+.field static final ZERO D
+	.attribute ConstantValue { Double -0 }
+
+.method public static s (I)I
+	.code stack 4 locals 300
+	start:	iload_0
+		tableswitch -1 {
+			a
+			b
+		} default c
+	a:	lookupswitch { 7 b 100000 c } default b
+	b:	wide iinc 299 -1000
+		ldc2_w Long 9223372036854775807
+		pop2
+		newarray int
+		goto_w c
+	c:	ldc String "tab\t\"quoted\""
+		pop
+		iconst_0
+	end:	ireturn
+		.catch any from start to end using end
+	.end code
+.end method
+
+.end class
+`
+
+func TestAssembleHandWritten(t *testing.T) {
+	b := assemble(t, handWritten)
+	text := disassemble(t, b)
+	if again := assemble(t, text); !bytes.Equal(again, b) {
+		t.Errorf("assembling the disassembly gives other bytes:\n%s", text)
+	}
+	for _, s := range []string{
+		`.class public super "odd name"`,
+		`.attribute InnerClasses { 0x0001 Class "odd name$1" [0] [0] 0x0000 }`,
+		".attribute ConstantValue { Float 0x7fc00001 }",
+		".attribute ConstantValue { Double -0 }",
+		"tableswitch -1 {\n\t\t\tL24\n\t\t\tL52\n\t\t} default L69",
+		"lookupswitch {\n\t\t\t7 L52\n\t\t\t100000 L69\n\t\t} default L52",
+		"wide iinc 299 -1000",
+		"goto_w L69",
+		`ldc String "tab\t\"quoted\""`,
+		".catch any from L0 to L73 using L73",
+	} {
+		if !strings.Contains(text, s) {
+			t.Errorf("disassembly lacks %q:\n%s", s, text)
+		}
+	}
+}
+
+func TestAssembleErrors(t *testing.T) {
+	for _, test := range []struct {
+		text, err string
+	}{
+		{".class A\n", "test.j:1: missing .end class"},
+		{".class A\n.method static m ()V\n.code stack 0 locals 0\ngoto L1\n.end code\n.end method\n.end class\n", "test.j:4: undefined label L1"},
+		{".class A\n.method static m ()V\n.code stack 0 locals 0\nfoo\n", "test.j:4: unknown instruction \"foo\""},
+		{".class A\n.field x I\n.attribute X { 0x0 }\n.end class\n", "test.j:3: \"0x0\" is not hex bytes"},
+		{".class A\n.method m ()V\n.end method\n.attribute X { }\n.end class\n", "test.j:4: class attributes go before the fields and methods"},
+		{".class A {\n", "test.j:2: missing }"},
+	} {
+		_, err := Assemble("test.j", strings.NewReader(test.text))
+		if err == nil || err.Error() != test.err {
+			t.Errorf("%q: got error %v, want %s", test.text, err, test.err)
+		}
+	}
+}
+
+// TestDecodeEmptyLookupswitch decodes a lookupswitch without pairs that
+// ends the code array: its 8 bytes of default and npairs are all it has.
+func TestDecodeEmptyLookupswitch(t *testing.T) {
+	code := []byte{0xab, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}
+	ins, err := decodeInstructions(code)
+	if err != nil || len(ins) != 1 || ins[0].Len() != len(code) {
+		t.Errorf("got %v %v, want one %d byte lookupswitch", ins, err, len(code))
+	}
+	if _, err := decodeInstructions(append(code[:8:8], 0, 0, 0, 1)); err == nil {
+		t.Error("a lookupswitch with a missing pair decodes")
+	}
+}
@@ -0,0 +1,368 @@
+package decompiler
+
+import (
+	"fmt"
+	"strings"
+)
+
+func utf8At(cp []CpInfo, index uint16) (string, error) {
+	if index == 0 || int(index) > len(cp) {
+		return "", fmt.Errorf("constant pool index %d out of range", index)
+	}
+	u := CONSTANT_Utf8_info{
+		CpInfo: cp[index-1],
+	}
+	return u.Values()
+}
+
+func classNameAt(cp []CpInfo, index uint16) (string, error) {
+	if index == 0 || int(index) > len(cp) {
+		return "", fmt.Errorf("constant pool index %d out of range", index)
+	}
+	if cp[index-1].tag != CONSTANT_Class {
+		return "", fmt.Errorf("constant pool index %d is not a class", index)
+	}
+	c := CONSTANT_Class_info{
+		cp[index-1],
+	}
+	return utf8At(cp, c.NameIndex())
+}
+
+// memberRefAt resolves a Fieldref, Methodref or InterfaceMethodref entry to
+// its owner class, name and descriptor.
+func memberRefAt(cp []CpInfo, index uint16) (owner, name, descriptor string, err error) {
+	if index == 0 || int(index) > len(cp) {
+		return "", "", "", fmt.Errorf("constant pool index %d out of range", index)
+	}
+	switch cp[index-1].tag {
+	case CONSTANT_Fieldref, CONSTANT_Methodref, CONSTANT_InterfaceMethodref:
+	default:
+		return "", "", "", fmt.Errorf("constant pool index %d is not a member reference", index)
+	}
+	ref := CONSTANT_Methodref_info{
+		CpInfo: cp[index-1],
+	}
+	owner, err = classNameAt(cp, ref.ClassIndex())
+	if err != nil {
+		return
+	}
+	name, descriptor, err = nameAndTypeAt(cp, ref.NameAndTypeIndex())
+	return
+}
+
+func nameAndTypeAt(cp []CpInfo, index uint16) (name, descriptor string, err error) {
+	if index == 0 || int(index) > len(cp) || cp[index-1].tag != CONSTANT_NameAndType {
+		return "", "", fmt.Errorf("constant pool index %d is not a name and type", index)
+	}
+	nt := CONSTANT_NameAndType_info{
+		CpInfo: cp[index-1],
+	}
+	name, err = utf8At(cp, nt.NameIndex())
+	if err != nil {
+		return
+	}
+	descriptor, err = utf8At(cp, nt.DescriptorIndex())
+	return
+}
+
+// findAttribute returns the first attribute called name, or nil.
+func findAttribute(cp []CpInfo, attrs []AttributeInfo, name string) *AttributeInfo {
+	for i := range attrs {
+		n, err := utf8At(cp, attrs[i].attribute_name_index)
+		if err == nil && n == name {
+			return &attrs[i]
+		}
+	}
+	return nil
+}
+
+func (this *FieldInfo) Name(cp []CpInfo) string {
+	n, _ := utf8At(cp, this.name_index)
+	return n
+}
+
+func (this *FieldInfo) Descriptor(cp []CpInfo) string {
+	d, _ := utf8At(cp, this.descriptor_index)
+	return d
+}
+
+func (this *MethodInfo) Name(cp []CpInfo) string {
+	n, _ := utf8At(cp, this.name_index)
+	return n
+}
+
+func (this *MethodInfo) Descriptor(cp []CpInfo) string {
+	d, _ := utf8At(cp, this.descriptor_index)
+	return d
+}
+
+// Code returns the decoded Code attribute of the method, or nil for
+// abstract and native methods.
+func (this *MethodInfo) Code(cp []CpInfo) (*CodeAttribute, error) {
+	attr := findAttribute(cp, this.attributes, "Code")
+	if attr == nil {
+		return nil, nil
+	}
+	ca, err := attr.ToCodeAttribute()
+	if err != nil {
+		return nil, err
+	}
+	return &ca, nil
+}
+
+type RecordComponentInfo struct {
+	name_index       uint16
+	descriptor_index uint16
+	attributes_count uint16
+	attributes       []AttributeInfo
+}
+
+type RecordAttribute struct {
+	attribute_name_index uint16
+	attribute_length     uint32
+	components_count     uint16
+	components           []RecordComponentInfo
+}
+
+// attributes reads n nested attribute_info structures, as found in Code
+// and Record attributes.
+func (this *infoReader) attributes(n uint16) []AttributeInfo {
+	var attrs []AttributeInfo
+	for i := uint16(0); i < n && this.err == nil; i++ {
+		a := AttributeInfo{
+			attribute_name_index: this.u2(),
+			attribute_length:     this.u4(),
+		}
+		if int64(a.attribute_length) > int64(len(this.info)) {
+			this.fail()
+			break
+		}
+		a.info = this.bytes(int(a.attribute_length))
+		attrs = append(attrs, a)
+	}
+	return attrs
+}
+
+func (this *AttributeInfo) ToRecordAttribute() (RecordAttribute, error) {
+	r := infoReader{info: this.info}
+	ra := RecordAttribute{
+		attribute_name_index: this.attribute_name_index,
+		attribute_length:     this.attribute_length,
+		components_count:     r.u2(),
+	}
+	for i := uint16(0); i < ra.components_count && r.err == nil; i++ {
+		rc := RecordComponentInfo{
+			name_index:       r.u2(),
+			descriptor_index: r.u2(),
+			attributes_count: r.u2(),
+		}
+		rc.attributes = r.attributes(rc.attributes_count)
+		ra.components = append(ra.components, rc)
+	}
+	return ra, r.err
+}
+
+type BootstrapMethod struct {
+	bootstrap_method_ref    uint16
+	num_bootstrap_arguments uint16
+	bootstrap_arguments     []uint16
+}
+
+type BootstrapMethodsAttribute struct {
+	attribute_name_index  uint16
+	attribute_length      uint32
+	num_bootstrap_methods uint16
+	bootstrap_methods     []BootstrapMethod
+}
+
+func (this *AttributeInfo) ToBootstrapMethodsAttribute() (BootstrapMethodsAttribute, error) {
+	r := infoReader{info: this.info}
+	ba := BootstrapMethodsAttribute{
+		attribute_name_index:  this.attribute_name_index,
+		attribute_length:      this.attribute_length,
+		num_bootstrap_methods: r.u2(),
+	}
+	for i := uint16(0); i < ba.num_bootstrap_methods && r.err == nil; i++ {
+		bm := BootstrapMethod{
+			bootstrap_method_ref:    r.u2(),
+			num_bootstrap_arguments: r.u2(),
+		}
+		bm.bootstrap_arguments = r.u2s(bm.num_bootstrap_arguments)
+		ba.bootstrap_methods = append(ba.bootstrap_methods, bm)
+	}
+	return ba, r.err
+}
+
+// bootstrapMethodOf resolves the owner and name of the bootstrap method
+// used by the invokedynamic at constant pool index.
+func (this *ClassFile) bootstrapMethodOf(index uint16) (owner, name string, err error) {
+	cp := this.constant_pool
+	if index == 0 || int(index) > len(cp) || cp[index-1].tag != CONSTANT_InvokeDynamic {
+		return "", "", fmt.Errorf("constant pool index %d is not invokedynamic", index)
+	}
+	indy := CONSTANT_InvokeDynamic_info{
+		CpInfo: cp[index-1],
+	}
+	attr := findAttribute(cp, this.attributes, "BootstrapMethods")
+	if attr == nil {
+		return "", "", fmt.Errorf("BootstrapMethods attribute missing")
+	}
+	bms, err := attr.ToBootstrapMethodsAttribute()
+	if err != nil {
+		return "", "", err
+	}
+	bi := indy.BootstrapMethodAttrIndex()
+	if int(bi) >= len(bms.bootstrap_methods) {
+		return "", "", fmt.Errorf("bootstrap method %d out of range", bi)
+	}
+	ref := bms.bootstrap_methods[bi].bootstrap_method_ref
+	if ref == 0 || int(ref) > len(cp) || cp[ref-1].tag != CONSTANT_MethodHandle {
+		return "", "", fmt.Errorf("constant pool index %d is not a method handle", ref)
+	}
+	mh := CONSTANT_MethodHandle_info{
+		CpInfo: cp[ref-1],
+	}
+	owner, name, _, err = memberRefAt(cp, mh.ReferenceIndex())
+	return
+}
+
+// simpleName returns the last segment of an internal class name.
+func simpleName(internal string) string {
+	return internal[strings.LastIndex(internal, "/")+1:]
+}
+
+type PermittedSubclassesAttribute struct {
+	attribute_name_index uint16
+	attribute_length     uint32
+	number_of_classes    uint16
+	classes              []uint16
+}
+
+func (this *AttributeInfo) ToPermittedSubclassesAttribute() (PermittedSubclassesAttribute, error) {
+	r := infoReader{info: this.info}
+	pa := PermittedSubclassesAttribute{
+		attribute_name_index: this.attribute_name_index,
+		attribute_length:     this.attribute_length,
+		number_of_classes:    r.u2(),
+	}
+	pa.classes = r.u2s(pa.number_of_classes)
+	return pa, r.err
+}
+
+type InnerClassEntry struct {
+	inner_class_info_index   uint16
+	outer_class_info_index   uint16
+	inner_name_index         uint16
+	inner_class_access_flags AccessFlags
+}
+
+type InnerClassesAttribute struct {
+	attribute_name_index uint16
+	attribute_length     uint32
+	number_of_classes    uint16
+	classes              []InnerClassEntry
+}
+
+func (this *AttributeInfo) ToInnerClassesAttribute() (InnerClassesAttribute, error) {
+	r := infoReader{info: this.info}
+	ia := InnerClassesAttribute{
+		attribute_name_index: this.attribute_name_index,
+		attribute_length:     this.attribute_length,
+		number_of_classes:    r.u2(),
+	}
+	for i := uint16(0); i < ia.number_of_classes && r.err == nil; i++ {
+		ia.classes = append(ia.classes, InnerClassEntry{
+			inner_class_info_index:   r.u2(),
+			outer_class_info_index:   r.u2(),
+			inner_name_index:         r.u2(),
+			inner_class_access_flags: AccessFlags(r.u2()),
+		})
+	}
+	return ia, r.err
+}
+
+type LocalVariableEntry struct {
+	start_pc         uint16
+	length           uint16
+	name_index       uint16
+	descriptor_index uint16
+	index            uint16
+}
+
+type LocalVariableTableAttribute struct {
+	attribute_name_index        uint16
+	attribute_length            uint32
+	local_variable_table_length uint16
+	local_variable_table        []LocalVariableEntry
+}
+
+func (this *AttributeInfo) ToLocalVariableTableAttribute() (LocalVariableTableAttribute, error) {
+	r := infoReader{info: this.info}
+	la := LocalVariableTableAttribute{
+		attribute_name_index:        this.attribute_name_index,
+		attribute_length:            this.attribute_length,
+		local_variable_table_length: r.u2(),
+	}
+	for i := uint16(0); i < la.local_variable_table_length && r.err == nil; i++ {
+		la.local_variable_table = append(la.local_variable_table, LocalVariableEntry{
+			start_pc:         r.u2(),
+			length:           r.u2(),
+			name_index:       r.u2(),
+			descriptor_index: r.u2(),
+			index:            r.u2(),
+		})
+	}
+	return la, r.err
+}
+
+type EnclosingMethodAttribute struct {
+	attribute_name_index uint16
+	attribute_length     uint32
+	class_index          uint16
+	method_index         uint16
+}
+
+func (this *AttributeInfo) ToEnclosingMethodAttribute() (EnclosingMethodAttribute, error) {
+	r := infoReader{info: this.info}
+	ea := EnclosingMethodAttribute{
+		attribute_name_index: this.attribute_name_index,
+		attribute_length:     this.attribute_length,
+		class_index:          r.u2(),
+		method_index:         r.u2(),
+	}
+	return ea, r.err
+}
+
+type NestHostAttribute struct {
+	attribute_name_index uint16
+	attribute_length     uint32
+	host_class_index     uint16
+}
+
+func (this *AttributeInfo) ToNestHostAttribute() (NestHostAttribute, error) {
+	r := infoReader{info: this.info}
+	na := NestHostAttribute{
+		attribute_name_index: this.attribute_name_index,
+		attribute_length:     this.attribute_length,
+		host_class_index:     r.u2(),
+	}
+	return na, r.err
+}
+
+type NestMembersAttribute struct {
+	attribute_name_index uint16
+	attribute_length     uint32
+	number_of_classes    uint16
+	classes              []uint16
+}
+
+func (this *AttributeInfo) ToNestMembersAttribute() (NestMembersAttribute, error) {
+	r := infoReader{info: this.info}
+	na := NestMembersAttribute{
+		attribute_name_index: this.attribute_name_index,
+		attribute_length:     this.attribute_length,
+		number_of_classes:    r.u2(),
+	}
+	na.classes = r.u2s(na.number_of_classes)
+	return na, r.err
+}
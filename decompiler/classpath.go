@@ -0,0 +1,229 @@
+package decompiler
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// ClassPath finds classes outside the ClassSet being written, such as
+// library dependencies and the JDK, for questions a single class file
+// cannot answer: super types, whether one type is assignable to another
+// and which methods a type has. Entries are directories, jar and jmod
+// files, jimage files and JDK homes. Classes are parsed when first asked
+// for and kept.
+type ClassPath struct {
+	entries  []func(name string) (source, bool)
+	archives []*Archive
+	m        sync.Mutex
+	cache    map[string]*decompiler
+}
+
+func NewClassPath(paths []string) (*ClassPath, error) {
+	this := &ClassPath{
+		cache: make(map[string]*decompiler),
+	}
+	for _, p := range paths {
+		if err := this.add(p); err != nil {
+			this.Close()
+			return nil, err
+		}
+	}
+	return this, nil
+}
+
+func (this *ClassPath) add(p string) error {
+	info, err := os.Stat(p)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		a, err := OpenArchive(p)
+		if err != nil {
+			return err
+		}
+		this.archives = append(this.archives, a)
+		this.entries = append(this.entries, a.classIndex())
+		return nil
+	}
+	// a JDK home: the runtime image, or the jmods of a JDK without one
+	if _, err := os.Stat(filepath.Join(p, "lib", "modules")); err == nil {
+		return this.add(filepath.Join(p, "lib", "modules"))
+	}
+	if jmods, _ := filepath.Glob(filepath.Join(p, "jmods", "*.jmod")); len(jmods) > 0 {
+		for _, jmod := range jmods {
+			if err := this.add(jmod); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	this.entries = append(this.entries, func(name string) (source, bool) {
+		file := filepath.Join(p, filepath.FromSlash(name)+".class")
+		if _, err := os.Stat(file); err != nil {
+			return source{}, false
+		}
+		return source{
+			path: file,
+			open: func() (io.ReadCloser, error) {
+				return os.Open(file)
+			},
+		}, true
+	})
+	return nil
+}
+
+// classIndex maps the internal names of the classes of an archive to their
+// entries. The module of jimage entries is dropped.
+func (this *Archive) classIndex() func(name string) (source, bool) {
+	index := make(map[string]source)
+	for _, s := range this.sources(0, false) {
+		name := strings.TrimSuffix(s.class, ".class")
+		if this.image {
+			_, name, _ = strings.Cut(name, "/")
+		}
+		if _, ok := index[name]; !ok {
+			index[name] = s
+		}
+	}
+	return func(name string) (source, bool) {
+		s, ok := index[name]
+		return s, ok
+	}
+}
+
+// Close releases the archives of the class path.
+func (this *ClassPath) Close() error {
+	var err error
+	for _, a := range this.archives {
+		if e := a.Close(); e != nil && err == nil {
+			err = e
+		}
+	}
+	return err
+}
+
+// Lookup returns the first class of that internal name on the class path,
+// or nil. A nil ClassPath has no classes.
+func (this *ClassPath) Lookup(name string) *decompiler {
+	if this == nil {
+		return nil
+	}
+	this.m.Lock()
+	defer this.m.Unlock()
+	if d, ok := this.cache[name]; ok {
+		return d
+	}
+	var d *decompiler
+	for _, entry := range this.entries {
+		s, ok := entry(name)
+		if !ok {
+			continue
+		}
+		rc, err := s.open()
+		if err != nil {
+			continue
+		}
+		d = New(s.path)
+		err = d.Parse(rc)
+		rc.Close()
+		if err != nil {
+			d = nil
+			continue
+		}
+		break
+	}
+	this.cache[name] = d
+	return d
+}
+
+// Method is a method as declared in a class file.
+type Method struct {
+	Owner      string
+	Name       string
+	Descriptor string
+	Flags      AccessFlags
+}
+
+// Supertypes returns the internal names of the super class and the
+// interfaces of a class of the set or of its class path. ok is false when
+// the class cannot be found.
+func (this *ClassSet) Supertypes(name string) (supers []string, ok bool) {
+	d := this.Lookup(name)
+	if d == nil {
+		return nil, false
+	}
+	cp := d.class.constant_pool
+	if d.class.super_class != 0 {
+		if super, err := classNameAt(cp, d.class.super_class); err == nil {
+			supers = append(supers, super)
+		}
+	}
+	for _, i := range d.class.interfaces {
+		if iface, err := classNameAt(cp, i); err == nil {
+			supers = append(supers, iface)
+		}
+	}
+	return supers, true
+}
+
+// IsSubtype reports whether class sub is super or extends or implements
+// it, directly or not. known is false when the answer is no but a class of
+// the hierarchy could not be found, so it might be yes.
+func (this *ClassSet) IsSubtype(sub, super string) (is bool, known bool) {
+	known = true
+	seen := make(map[string]bool)
+	queue := []string{sub}
+	for len(queue) > 0 {
+		name := queue[0]
+		queue = queue[1:]
+		if name == super {
+			return true, true
+		}
+		if seen[name] {
+			continue
+		}
+		seen[name] = true
+		supers, ok := this.Supertypes(name)
+		if !ok {
+			known = false
+		}
+		queue = append(queue, supers...)
+	}
+	return false, known
+}
+
+// Methods returns the methods called name that a class declares or
+// inherits, nearest first. Overridden methods are listed once.
+func (this *ClassSet) Methods(owner, name string) []Method {
+	var methods []Method
+	seen := make(map[string]bool)
+	visited := make(map[string]bool)
+	queue := []string{owner}
+	for len(queue) > 0 {
+		class := queue[0]
+		queue = queue[1:]
+		if visited[class] {
+			continue
+		}
+		visited[class] = true
+		d := this.Lookup(class)
+		if d == nil {
+			continue
+		}
+		cp := d.class.constant_pool
+		for i := range d.class.methods {
+			m := &d.class.methods[i]
+			if m.Name(cp) != name || seen[m.Descriptor(cp)] {
+				continue
+			}
+			seen[m.Descriptor(cp)] = true
+			methods = append(methods, Method{class, name, m.Descriptor(cp), m.access_flags})
+		}
+		supers, _ := this.Supertypes(class)
+		queue = append(queue, supers...)
+	}
+	return methods
+}
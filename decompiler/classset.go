@@ -0,0 +1,286 @@
+package decompiler
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// ClassSet groups the classes of one input (a directory, an archive or a
+// list of files) so that a class can consult its neighbours while it is
+// written, e.g. a subclass looking at its sealed super class.
+type ClassSet struct {
+	classes map[string]*decompiler
+	order   []string
+	m       sync.Mutex
+	nested  map[string][]*decompiler
+	jobs    int
+	filter  *Filter
+	// classes left out by the filter, parsed when they are looked up
+	lazy     map[string]source
+	lazyMu   sync.Mutex
+	lazyDone map[string]*decompiler
+	// classes outside the set
+	classPath *ClassPath
+}
+
+func NewClassSet() *ClassSet {
+	return &ClassSet{
+		classes:  make(map[string]*decompiler),
+		lazy:     make(map[string]source),
+		lazyDone: make(map[string]*decompiler),
+	}
+}
+
+// SetJobs sets how many classes are parsed and written at the same time.
+// The default, 0, uses one worker per CPU.
+func (this *ClassSet) SetJobs(n int) {
+	this.jobs = n
+}
+
+// SetFilter restricts the classes parsed and written by the Add methods.
+// Classes left out are still parsed on demand when a selected class looks
+// them up, e.g. a sealed super class.
+func (this *ClassSet) SetFilter(f *Filter) {
+	this.filter = f
+}
+
+// SetClassPath makes the classes of cp available to Lookup and to the
+// type hierarchy queries.
+func (this *ClassSet) SetClassPath(cp *ClassPath) {
+	this.classPath = cp
+}
+
+// Add registers a parsed class under its internal name.
+func (this *ClassSet) Add(d *decompiler) error {
+	name, err := d.Name()
+	if err != nil {
+		return err
+	}
+	if _, ok := this.classes[name]; ok {
+		return fmt.Errorf("duplicate class %s", name)
+	}
+	this.classes[name] = d
+	this.order = append(this.order, name)
+	d.set = this
+	this.m.Lock()
+	this.nested = nil
+	this.m.Unlock()
+	return nil
+}
+
+// Lookup returns the class with the given internal name, or nil. Classes
+// of the set come first, then those left out by the filter, then the
+// class path.
+func (this *ClassSet) Lookup(name string) *decompiler {
+	if this == nil {
+		return nil
+	}
+	if d := this.classes[name]; d != nil {
+		return d
+	}
+	if d := this.lookupLazy(name); d != nil {
+		return d
+	}
+	return this.classPath.Lookup(name)
+}
+
+// member returns a class written with this set, unlike Lookup which also
+// finds classes left out by the filter and those of the class path.
+func (this *ClassSet) member(name string) *decompiler {
+	if this == nil {
+		return nil
+	}
+	return this.classes[name]
+}
+
+// lookupLazy parses a class left out by the filter on first use. It is
+// not written and not listed by Names.
+func (this *ClassSet) lookupLazy(name string) *decompiler {
+	this.lazyMu.Lock()
+	defer this.lazyMu.Unlock()
+	if d, ok := this.lazyDone[name]; ok {
+		return d
+	}
+	s, ok := this.lazy[name]
+	if !ok {
+		return nil
+	}
+	var d *decompiler
+	if rc, err := s.open(); err == nil {
+		d = New(s.path)
+		if d.Parse(rc) != nil {
+			d = nil
+		} else {
+			d.set = this
+		}
+		rc.Close()
+	}
+	this.lazyDone[name] = d
+	return d
+}
+
+// Names returns the internal names in the order they were added.
+func (this *ClassSet) Names() []string {
+	return this.order
+}
+
+// nestedIn returns the classes declared inside outer, in the order they
+// were added. The index is built on first use.
+func (this *ClassSet) nestedIn(outer string) []*decompiler {
+	if this == nil {
+		return nil
+	}
+	this.m.Lock()
+	defer this.m.Unlock()
+	if this.nested == nil {
+		this.nested = make(map[string][]*decompiler)
+		for _, name := range this.order {
+			d := this.classes[name]
+			if o := d.outerName(); o != "" {
+				this.nested[o] = append(this.nested[o], d)
+			}
+		}
+	}
+	return this.nested[outer]
+}
+
+// AddDir parses every .class file below dir, e.g. a Maven target/classes.
+func (this *ClassSet) AddDir(dir string) error {
+	sources, err := dirSources(dir)
+	if err != nil {
+		return err
+	}
+	return this.addSources(sources)
+}
+
+// AddArchive parses the application classes of a jar or war file.
+func (this *ClassSet) AddArchive(archive string) error {
+	a, err := OpenArchive(archive)
+	if err != nil {
+		return err
+	}
+	defer a.Close()
+	return this.AddClasses(a, 0)
+}
+
+// AddClasses parses the application classes of an archive. Those of
+// nested library jars are not included; see Archive.Nested. For multi
+// release jars the variant of each class a JVM of the given release would
+// load is used; release 0 means the base classes.
+func (this *ClassSet) AddClasses(a *Archive, release int) error {
+	return this.addSources(a.sources(release, false))
+}
+
+// AddClass parses one class of an archive and the classes nested in it,
+// e.g. java/util/Map and java/util/Map$Entry. In a jimage the name starts
+// with the module: java.base/java/lang/String.
+func (this *ClassSet) AddClass(a *Archive, name string, release int) error {
+	var sources []source
+	for _, s := range a.sources(release, false) {
+		if s.class == name+".class" || strings.HasPrefix(s.class, name+"$") {
+			sources = append(sources, s)
+		}
+	}
+	if len(sources) == 0 {
+		return fmt.Errorf("%s: class %s not found", a.path, name)
+	}
+	return this.addSources(sources)
+}
+
+// AddVersion parses only the classes of META-INF/versions/<version> of a
+// multi release jar.
+func (this *ClassSet) AddVersion(a *Archive, version int) error {
+	return this.addSources(a.sources(version, true))
+}
+
+// AddFile parses a single .class file.
+func (this *ClassSet) AddFile(name string) error {
+	return this.addSources([]source{{
+		path: name,
+		open: func() (io.ReadCloser, error) {
+			return os.Open(name)
+		},
+	}})
+}
+
+// AddReader parses a class read from r, e.g. standard input; name is used
+// in error messages.
+func (this *ClassSet) AddReader(name string, r io.Reader) error {
+	return this.addSources([]source{{
+		path: name,
+		open: func() (io.ReadCloser, error) {
+			return io.NopCloser(r), nil
+		},
+	}})
+}
+
+// topLevel returns the names of the classes that are written on their own.
+func (this *ClassSet) topLevel() []string {
+	var top []string
+	for _, name := range this.order {
+		if this.classes[name].Outer() == nil {
+			top = append(top, name)
+		}
+	}
+	return top
+}
+
+// render calls write with the source of every top level class. Sources
+// are rendered concurrently but written in input order; a failing class
+// does not stop the others, and members replaced with stubs are reported
+// along with failed classes.
+func (this *ClassSet) render(options Options, write func(name, text string) error) error {
+	top := this.topLevel()
+	texts := make([]string, len(top))
+	errs := make([]error, len(top))
+	done := make([]chan struct{}, len(top))
+	for i := range done {
+		done[i] = make(chan struct{})
+	}
+	go parallel(len(top), this.jobs, func(i int) {
+		defer close(done[i])
+		d := this.classes[top[i]]
+		d.SetOptions(options)
+		texts[i], errs[i] = d.Source()
+	})
+	var failed Errors
+	for i, name := range top {
+		<-done[i]
+		if errs[i] == nil {
+			errs[i] = write(name, texts[i])
+			texts[i] = ""
+		}
+		if errs[i] != nil {
+			failed = append(failed, ClassError{name, errs[i]})
+			continue
+		}
+		failed = append(failed, this.classes[name].Failures()...)
+	}
+	return failed.orNil()
+}
+
+// WriteDir writes every top level class to outdir in its package layout,
+// com/acme/Foo becomes outdir/com/acme/Foo.java. Member, local and
+// anonymous classes are written inside their outer class.
+func (this *ClassSet) WriteDir(outdir string, options Options) error {
+	return this.render(options, func(name, text string) error {
+		ofile := filepath.Join(outdir, filepath.FromSlash(name)+".java")
+		if err := os.MkdirAll(filepath.Dir(ofile), 0755); err != nil {
+			return err
+		}
+		return os.WriteFile(ofile, []byte(text), 0644)
+	})
+}
+
+// Write writes the sources of all top level classes to w, one after the
+// other, e.g. to standard output.
+func (this *ClassSet) Write(w io.Writer, options Options) error {
+	return this.render(options, func(name, text string) error {
+		_, err := io.WriteString(w, text+"\n")
+		return err
+	})
+}
@@ -0,0 +1,606 @@
+package decompiler
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+)
+
+// Constant pool editing. The Add methods return the index of an equal
+// entry when there is one and append a new entry otherwise, so existing
+// indices never move. Reindex afterwards drops the entries nothing refers
+// to any more and renumbers every reference.
+
+func u4bytes(v uint64, n int) []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, v)
+	return b[8-n:]
+}
+
+func u2bytes(vs ...uint16) []byte {
+	b := make([]byte, 2*len(vs))
+	for i, v := range vs {
+		binary.BigEndian.PutUint16(b[2*i:], v)
+	}
+	return b
+}
+
+// addConstant indexes the pool by content as it grows, so that adding
+// many constants stays linear. An index found there is checked against
+// the pool in case the entry was edited since.
+func (this *ClassFile) addConstant(tag ConstantTag, info []byte) (uint16, error) {
+	if this.constants == nil || this.indexed > len(this.constant_pool) {
+		this.constants = make(map[string]uint16)
+		this.indexed = 0
+	}
+	for ; this.indexed < len(this.constant_pool); this.indexed++ {
+		c := this.constant_pool[this.indexed]
+		key := string(byte(c.tag)) + string(c.info)
+		if _, ok := this.constants[key]; !ok && c.tag != 0 {
+			this.constants[key] = uint16(this.indexed + 1)
+		}
+	}
+	if i, ok := this.constants[string(byte(tag))+string(info)]; ok {
+		if c := this.constant_pool[i-1]; c.tag == tag && bytes.Equal(c.info, info) {
+			return i, nil
+		}
+	}
+	size := 1
+	if tag == CONSTANT_Long || tag == CONSTANT_Double {
+		size = 2
+	}
+	if len(this.constant_pool)+size >= math.MaxUint16 {
+		return 0, fmt.Errorf("constant pool is full")
+	}
+	index := uint16(len(this.constant_pool) + 1)
+	this.constant_pool = append(this.constant_pool, CpInfo{tag, info})
+	if size == 2 {
+		this.constant_pool = append(this.constant_pool, CpInfo{})
+	}
+	this.constant_pool_count = uint16(len(this.constant_pool) + 1)
+	return index, nil
+}
+
+func (this *ClassFile) AddUtf8(s string) (uint16, error) {
+	if len(s) > math.MaxUint16 {
+		return 0, fmt.Errorf("string of %d bytes does not fit in the constant pool", len(s))
+	}
+	return this.addConstant(CONSTANT_Utf8, append(u2bytes(uint16(len(s))), s...))
+}
+
+func (this *ClassFile) AddInteger(v int32) (uint16, error) {
+	return this.addConstant(CONSTANT_Integer, u4bytes(uint64(uint32(v)), 4))
+}
+
+func (this *ClassFile) AddFloat(v float32) (uint16, error) {
+	return this.addConstant(CONSTANT_Float, u4bytes(uint64(math.Float32bits(v)), 4))
+}
+
+func (this *ClassFile) AddLong(v int64) (uint16, error) {
+	return this.addConstant(CONSTANT_Long, u4bytes(uint64(v), 8))
+}
+
+func (this *ClassFile) AddDouble(v float64) (uint16, error) {
+	return this.addConstant(CONSTANT_Double, u4bytes(math.Float64bits(v), 8))
+}
+
+// addNamed adds an entry that refers to a Utf8 entry, like Class or String.
+func (this *ClassFile) addNamed(tag ConstantTag, s string) (uint16, error) {
+	i, err := this.AddUtf8(s)
+	if err != nil {
+		return 0, err
+	}
+	return this.addConstant(tag, u2bytes(i))
+}
+
+// AddClass adds a Class entry for an internal name, e.g. java/lang/String.
+func (this *ClassFile) AddClass(name string) (uint16, error) {
+	return this.addNamed(CONSTANT_Class, name)
+}
+
+func (this *ClassFile) AddString(s string) (uint16, error) {
+	return this.addNamed(CONSTANT_String, s)
+}
+
+func (this *ClassFile) AddMethodType(descriptor string) (uint16, error) {
+	return this.addNamed(CONSTANT_MethodType, descriptor)
+}
+
+func (this *ClassFile) AddNameAndType(name, descriptor string) (uint16, error) {
+	n, err := this.AddUtf8(name)
+	if err != nil {
+		return 0, err
+	}
+	d, err := this.AddUtf8(descriptor)
+	if err != nil {
+		return 0, err
+	}
+	return this.addConstant(CONSTANT_NameAndType, u2bytes(n, d))
+}
+
+// AddMemberRef adds a Fieldref, Methodref or InterfaceMethodref entry.
+func (this *ClassFile) AddMemberRef(tag ConstantTag, owner, name, descriptor string) (uint16, error) {
+	switch tag {
+	case CONSTANT_Fieldref, CONSTANT_Methodref, CONSTANT_InterfaceMethodref:
+	default:
+		return 0, fmt.Errorf("tag %d is not a member reference", tag)
+	}
+	c, err := this.AddClass(owner)
+	if err != nil {
+		return 0, err
+	}
+	nt, err := this.AddNameAndType(name, descriptor)
+	if err != nil {
+		return 0, err
+	}
+	return this.addConstant(tag, u2bytes(c, nt))
+}
+
+func (this *ClassFile) AddMethodHandle(kind ReferenceKind, ref uint16) (uint16, error) {
+	return this.addConstant(CONSTANT_MethodHandle, append([]byte{byte(kind)}, u2bytes(ref)...))
+}
+
+// AddDynamic adds a Dynamic or InvokeDynamic entry for the bootstrap
+// method at index bootstrap of the BootstrapMethods attribute.
+func (this *ClassFile) AddDynamic(tag ConstantTag, bootstrap uint16, name, descriptor string) (uint16, error) {
+	if tag != CONSTANT_Dynamic && tag != CONSTANT_InvokeDynamic {
+		return 0, fmt.Errorf("tag %d is not dynamic", tag)
+	}
+	nt, err := this.AddNameAndType(name, descriptor)
+	if err != nil {
+		return 0, err
+	}
+	return this.addConstant(tag, u2bytes(bootstrap, nt))
+}
+
+// constantRefs returns the slots of a constant pool entry that refer to
+// other entries.
+func constantRefs(c CpInfo) [][]byte {
+	switch c.tag {
+	case CONSTANT_Class, CONSTANT_String, CONSTANT_MethodType, CONSTANT_Module, CONSTANT_Package:
+		return [][]byte{c.info[0:2]}
+	case CONSTANT_Fieldref, CONSTANT_Methodref, CONSTANT_InterfaceMethodref, CONSTANT_NameAndType:
+		return [][]byte{c.info[0:2], c.info[2:4]}
+	case CONSTANT_MethodHandle:
+		return [][]byte{c.info[1:3]}
+	case CONSTANT_Dynamic, CONSTANT_InvokeDynamic:
+		// the first item indexes BootstrapMethods, not the constant pool
+		return [][]byte{c.info[2:4]}
+	}
+	return nil
+}
+
+// constantLevel orders the tags so that entries only refer to entries of
+// a lower level.
+func constantLevel(tag ConstantTag) int {
+	switch tag {
+	case CONSTANT_Class, CONSTANT_String, CONSTANT_MethodType, CONSTANT_Module,
+		CONSTANT_Package, CONSTANT_NameAndType:
+		return 1
+	case CONSTANT_Fieldref, CONSTANT_Methodref, CONSTANT_InterfaceMethodref,
+		CONSTANT_Dynamic, CONSTANT_InvokeDynamic:
+		return 2
+	case CONSTANT_MethodHandle:
+		return 3
+	}
+	return 0
+}
+
+func slotIndex(slot []byte) uint16 {
+	if len(slot) == 1 {
+		return uint16(slot[0])
+	}
+	return binary.BigEndian.Uint16(slot)
+}
+
+// Reindex removes unused and duplicate constant pool entries and rewrites
+// every reference in the class, its members, attributes and bytecode.
+// Surviving entries keep their order, so indices only shrink and ldc
+// operands still fit in a byte. Classes with attributes whose references
+// are not known cannot be reindexed.
+func (this *ClassFile) Reindex() error {
+	cp := this.constant_pool
+	refs, commit, err := this.references()
+	if err != nil {
+		return err
+	}
+	used := make([]bool, len(cp)+1)
+	var mark func(i uint16, level int) error
+	mark = func(i uint16, level int) error {
+		if i == 0 {
+			return nil
+		}
+		if int(i) > len(cp) || cp[i-1].tag == 0 {
+			return fmt.Errorf("constant pool index %d out of range", i)
+		}
+		if constantLevel(cp[i-1].tag) >= level {
+			return fmt.Errorf("unexpected reference to constant pool entry %d with tag %d", i, cp[i-1].tag)
+		}
+		if used[i] {
+			return nil
+		}
+		used[i] = true
+		for _, slot := range constantRefs(cp[i-1]) {
+			if err := mark(slotIndex(slot), constantLevel(cp[i-1].tag)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	for _, slot := range refs {
+		if err := mark(slotIndex(slot), math.MaxInt32); err != nil {
+			return err
+		}
+	}
+
+	// equal entries collapse into the first one, level by level so that
+	// the children of an entry are already collapsed
+	canon := make([]uint16, len(cp)+1)
+	for level := 0; level <= 3; level++ {
+		seen := make(map[string]uint16)
+		for i := 1; i <= len(cp); i++ {
+			c := cp[i-1]
+			if !used[i] || constantLevel(c.tag) != level {
+				continue
+			}
+			key := append([]byte{byte(c.tag)}, c.info...)
+			for _, slot := range constantRefs(CpInfo{c.tag, key[1:]}) {
+				if child := canon[slotIndex(slot)]; child != 0 {
+					binary.BigEndian.PutUint16(slot, child)
+				}
+			}
+			if first, ok := seen[string(key)]; ok {
+				canon[i] = first
+			} else {
+				seen[string(key)] = uint16(i)
+				canon[i] = uint16(i)
+			}
+		}
+	}
+
+	index := make([]uint16, len(cp)+1)
+	var pool []CpInfo
+	for i := 1; i <= len(cp); i++ {
+		if !used[i] || canon[i] != uint16(i) {
+			continue
+		}
+		index[i] = uint16(len(pool) + 1)
+		pool = append(pool, CpInfo{cp[i-1].tag, append([]byte(nil), cp[i-1].info...)})
+		if cp[i-1].tag == CONSTANT_Long || cp[i-1].tag == CONSTANT_Double {
+			pool = append(pool, CpInfo{})
+		}
+	}
+	remap := func(slot []byte) {
+		i := slotIndex(slot)
+		if i == 0 {
+			return
+		}
+		n := index[canon[i]]
+		if len(slot) == 1 {
+			slot[0] = byte(n)
+		} else {
+			binary.BigEndian.PutUint16(slot, n)
+		}
+	}
+	for _, slot := range refs {
+		remap(slot)
+	}
+	for _, c := range pool {
+		for _, slot := range constantRefs(c) {
+			remap(slot)
+		}
+	}
+	commit()
+	this.constant_pool = pool
+	this.constants = nil
+	this.constant_pool_count = uint16(len(pool) + 1)
+	return nil
+}
+
+// refWalker collects the constant pool references of attribute info.
+type refWalker struct {
+	infoReader
+	refs [][]byte
+}
+
+func (this *refWalker) ref() {
+	if this.pos+2 > len(this.info) {
+		this.fail()
+		return
+	}
+	this.refs = append(this.refs, this.info[this.pos:this.pos+2])
+	this.pos += 2
+}
+
+func (this *refWalker) refs2(n uint16) {
+	for i := uint16(0); i < n && this.err == nil; i++ {
+		this.ref()
+	}
+}
+
+// references returns every slot outside the constant pool that holds a
+// constant pool index. Slots in attributes are slices of their info, so
+// rewriting them edits the attribute; the slots of the fixed structure
+// are copies that commit stores back. ldc operands are one byte long.
+func (this *ClassFile) references() (refs [][]byte, commit func(), err error) {
+	var fixed []func()
+	slot := func(p *uint16) {
+		b := u2bytes(*p)
+		refs = append(refs, b)
+		fixed = append(fixed, func() { *p = binary.BigEndian.Uint16(b) })
+	}
+	attributes := func(attrs []AttributeInfo) error {
+		for i := range attrs {
+			slot(&attrs[i].attribute_name_index)
+			name, err := utf8At(this.constant_pool, attrs[i].attribute_name_index)
+			if err != nil {
+				return err
+			}
+			w := &refWalker{infoReader: infoReader{info: attrs[i].info}}
+			if err := w.attribute(this.constant_pool, name); err != nil {
+				return err
+			}
+			refs = append(refs, w.refs...)
+		}
+		return nil
+	}
+	slot(&this.this_class)
+	slot(&this.super_class)
+	for i := range this.interfaces {
+		slot(&this.interfaces[i])
+	}
+	for i := range this.fields {
+		slot(&this.fields[i].name_index)
+		slot(&this.fields[i].descriptor_index)
+		if err := attributes(this.fields[i].attributes); err != nil {
+			return nil, nil, err
+		}
+	}
+	for i := range this.methods {
+		slot(&this.methods[i].name_index)
+		slot(&this.methods[i].descriptor_index)
+		if err := attributes(this.methods[i].attributes); err != nil {
+			return nil, nil, err
+		}
+	}
+	if err := attributes(this.attributes); err != nil {
+		return nil, nil, err
+	}
+	commit = func() {
+		for _, f := range fixed {
+			f()
+		}
+	}
+	return refs, commit, nil
+}
+
+// attribute walks the info of an attribute called name.
+func (this *refWalker) attribute(cp []CpInfo, name string) error {
+	switch name {
+	case "ConstantValue", "SourceFile", "Signature", "NestHost", "ModuleMainClass":
+		this.ref()
+	case "Exceptions", "NestMembers", "PermittedSubclasses", "ModulePackages":
+		this.refs2(this.u2())
+	case "EnclosingMethod":
+		this.ref()
+		this.ref()
+	case "Synthetic", "Deprecated", "SourceDebugExtension", "LineNumberTable":
+		this.pos = len(this.info)
+	case "InnerClasses":
+		n := this.u2()
+		for i := uint16(0); i < n && this.err == nil; i++ {
+			this.refs2(3)
+			this.u2()
+		}
+	case "LocalVariableTable", "LocalVariableTypeTable":
+		n := this.u2()
+		for i := uint16(0); i < n && this.err == nil; i++ {
+			this.u4()
+			this.refs2(2)
+			this.u2()
+		}
+	case "MethodParameters":
+		n := this.u1()
+		for i := byte(0); i < n && this.err == nil; i++ {
+			this.ref()
+			this.u2()
+		}
+	case "BootstrapMethods":
+		n := this.u2()
+		for i := uint16(0); i < n && this.err == nil; i++ {
+			this.ref()
+			this.refs2(this.u2())
+		}
+	case "Code":
+		this.u4()
+		code := this.bytes(int(this.u4()))
+		ins, err := decodeInstructions(code)
+		if err != nil {
+			return err
+		}
+		for _, in := range ins {
+			switch in.opcode {
+			case 0x12:
+				this.refs = append(this.refs, in.operands[:1])
+			case 0x13, 0x14, 0xb2, 0xb3, 0xb4, 0xb5, 0xb6, 0xb7, 0xb8, 0xb9, 0xba,
+				0xbb, 0xbd, 0xc0, 0xc1, 0xc5:
+				this.refs = append(this.refs, in.operands[:2])
+			}
+		}
+		n := this.u2()
+		for i := uint16(0); i < n && this.err == nil; i++ {
+			this.bytes(6)
+			this.ref()
+		}
+		if err := this.nested(cp); err != nil {
+			return err
+		}
+	case "StackMapTable":
+		this.stackMapTable()
+	case "Record":
+		n := this.u2()
+		for i := uint16(0); i < n && this.err == nil; i++ {
+			this.refs2(2)
+			if err := this.nested(cp); err != nil {
+				return err
+			}
+		}
+	case "RuntimeVisibleAnnotations", "RuntimeInvisibleAnnotations":
+		this.annotations(this.u2())
+	case "RuntimeVisibleParameterAnnotations", "RuntimeInvisibleParameterAnnotations":
+		n := this.u1()
+		for i := byte(0); i < n && this.err == nil; i++ {
+			this.annotations(this.u2())
+		}
+	case "RuntimeVisibleTypeAnnotations", "RuntimeInvisibleTypeAnnotations":
+		n := this.u2()
+		for i := uint16(0); i < n && this.err == nil; i++ {
+			this.targetInfo(this.u1())
+			this.bytes(2 * int(this.u1()))
+			this.annotationRefs()
+		}
+	case "AnnotationDefault":
+		this.elementValueRefs()
+	case "Module":
+		this.ref()
+		this.u2()
+		this.ref()
+		n := this.u2()
+		for i := uint16(0); i < n && this.err == nil; i++ {
+			this.ref()
+			this.u2()
+			this.ref()
+		}
+		for k := 0; k < 2; k++ {
+			// exports, then opens
+			n = this.u2()
+			for i := uint16(0); i < n && this.err == nil; i++ {
+				this.ref()
+				this.u2()
+				this.refs2(this.u2())
+			}
+		}
+		this.refs2(this.u2())
+		n = this.u2()
+		for i := uint16(0); i < n && this.err == nil; i++ {
+			this.ref()
+			this.refs2(this.u2())
+		}
+	default:
+		return fmt.Errorf("references of %s attributes are unknown", name)
+	}
+	if this.err != nil {
+		return fmt.Errorf("%s attribute: %v", name, this.err)
+	}
+	if this.pos != len(this.info) {
+		return fmt.Errorf("%s attribute has %d bytes left over", name, len(this.info)-this.pos)
+	}
+	return nil
+}
+
+// nested walks an attributes table inside an attribute.
+func (this *refWalker) nested(cp []CpInfo) error {
+	n := this.u2()
+	for i := uint16(0); i < n && this.err == nil; i++ {
+		var name string
+		if this.pos+2 <= len(this.info) {
+			name, _ = utf8At(cp, slotIndex(this.info[this.pos:this.pos+2]))
+		}
+		this.ref()
+		info := this.bytes(int(this.u4()))
+		if this.err != nil {
+			break
+		}
+		w := &refWalker{infoReader: infoReader{info: info}}
+		if err := w.attribute(cp, name); err != nil {
+			return err
+		}
+		this.refs = append(this.refs, w.refs...)
+	}
+	return nil
+}
+
+func (this *refWalker) verificationTypes(n int) {
+	for i := 0; i < n && this.err == nil; i++ {
+		switch tag := this.u1(); {
+		case tag == 7:
+			this.ref()
+		case tag == 8:
+			this.u2()
+		case tag > 8 && this.err == nil:
+			this.err = fmt.Errorf("unknown verification type %d", tag)
+		}
+	}
+}
+
+func (this *refWalker) stackMapTable() {
+	n := this.u2()
+	for i := uint16(0); i < n && this.err == nil; i++ {
+		switch frame := this.u1(); {
+		case frame < 64:
+		case frame < 128:
+			this.verificationTypes(1)
+		case frame < 247:
+			if this.err == nil {
+				this.err = fmt.Errorf("reserved frame type %d", frame)
+			}
+		case frame == 247:
+			this.u2()
+			this.verificationTypes(1)
+		case frame < 252:
+			this.u2()
+		case frame < 255:
+			this.u2()
+			this.verificationTypes(int(frame) - 251)
+		default:
+			this.u2()
+			this.verificationTypes(int(this.u2()))
+			this.verificationTypes(int(this.u2()))
+		}
+	}
+}
+
+func (this *refWalker) annotations(n uint16) {
+	for i := uint16(0); i < n && this.err == nil; i++ {
+		this.annotationRefs()
+	}
+}
+
+func (this *refWalker) annotationRefs() {
+	this.ref()
+	n := this.u2()
+	for i := uint16(0); i < n && this.err == nil; i++ {
+		this.ref()
+		this.elementValueRefs()
+	}
+}
+
+func (this *refWalker) elementValueRefs() {
+	tag := this.u1()
+	if this.depth >= maxNesting {
+		if this.err == nil {
+			this.err = fmt.Errorf("element values nested deeper than %d", maxNesting)
+		}
+		return
+	}
+	this.depth++
+	defer func() { this.depth-- }()
+	switch tag {
+	case 'B', 'C', 'D', 'F', 'I', 'J', 'S', 'Z', 's', 'c':
+		this.ref()
+	case 'e':
+		this.refs2(2)
+	case '@':
+		this.annotationRefs()
+	case '[':
+		n := this.u2()
+		for i := uint16(0); i < n && this.err == nil; i++ {
+			this.elementValueRefs()
+		}
+	default:
+		if this.err == nil {
+			this.err = fmt.Errorf("unknown element_value tag %q", tag)
+		}
+	}
+}
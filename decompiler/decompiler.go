@@ -4,13 +4,24 @@ import (
 	"bufio"
 	"encoding/binary"
 	"fmt"
+	"io"
 	"os"
+	"sort"
 	"strings"
 )
 
 type decompiler struct {
 	filename string
 	class    ClassFile
+	record   *recordInfo
+	set      *ClassSet
+	options  Options
+	failures Errors
+}
+
+// Options controls how a class is rendered.
+type Options struct {
+	LineNumbers LineNumberMode
 }
 
 const MAGIC uint32 = 0xCAFEBABE
@@ -22,27 +33,37 @@ func New(filename string) *decompiler {
 	return d
 }
 
+// SetOptions applies options to this class and to the classes nested in
+// it when they are written together.
+func (this *decompiler) SetOptions(options Options) {
+	this.options = options
+}
+
+// Name returns the internal name of the parsed class, e.g. java/lang/String.
+func (this *decompiler) Name() (string, error) {
+	return this.class.Name()
+}
+
 func (this *decompiler) getAtribules(reader *bufio.Reader) (AttributeInfo, error) {
 	atr := AttributeInfo{}
 	buff := make([]byte, 2)
-	_, err := reader.Read(buff)
+	_, err := io.ReadFull(reader, buff)
 	if err != nil {
 		return atr, err
 	}
 	atr.attribute_name_index = binary.BigEndian.Uint16(buff)
 	buff = make([]byte, 4)
-	_, err = reader.Read(buff)
+	_, err = io.ReadFull(reader, buff)
 	if err != nil {
 		return atr, err
 	}
 	atr.attribute_length = binary.BigEndian.Uint32(buff)
-	atr.info = make([]byte, atr.attribute_length)
-	buff = make([]byte, atr.attribute_length)
-	_, err = reader.Read(buff)
-	if err != nil {
-		return atr, err
+	// attribute_length comes from the file, so the buffer only grows with
+	// the bytes actually read
+	atr.info, err = io.ReadAll(io.LimitReader(reader, int64(atr.attribute_length)))
+	if err == nil && len(atr.info) < int(atr.attribute_length) {
+		err = io.ErrUnexpectedEOF
 	}
-	atr.info = buff
 	return atr, err
 }
 
@@ -52,9 +73,16 @@ func (this *decompiler) ParseFile() error {
 		return err
 	}
 	defer f.Close()
-	reader := bufio.NewReader(f)
+	return this.Parse(f)
+}
+
+// Parse reads the class from r, for class files that are not on disk such
+// as archive entries.
+func (this *decompiler) Parse(r io.Reader) (err error) {
+	defer recoverError(&err)
+	reader := bufio.NewReader(r)
 	buff := make([]byte, 4)
-	_, err = reader.Read(buff)
+	_, err = io.ReadFull(reader, buff)
 	if err != nil {
 		return err
 	}
@@ -65,21 +93,24 @@ func (this *decompiler) ParseFile() error {
 		magic: binary.BigEndian.Uint32(buff),
 	}
 	buff = make([]byte, 2)
-	_, err = reader.Read(buff)
+	_, err = io.ReadFull(reader, buff)
 	if err != nil {
 		return err
 	}
 	class.minor_version = binary.BigEndian.Uint16(buff)
-	_, err = reader.Read(buff)
+	_, err = io.ReadFull(reader, buff)
 	if err != nil {
 		return err
 	}
 	class.major_version = binary.BigEndian.Uint16(buff)
-	_, err = reader.Read(buff)
+	_, err = io.ReadFull(reader, buff)
 	if err != nil {
 		return err
 	}
 	class.constant_pool_count = binary.BigEndian.Uint16(buff)
+	if class.constant_pool_count == 0 {
+		return fmt.Errorf("constant_pool_count is 0")
+	}
 	class.constant_pool = make([]CpInfo, class.constant_pool_count-1)
 	for i := uint16(0); i < class.constant_pool_count-1; i++ {
 		tag, err := reader.ReadByte()
@@ -90,13 +121,13 @@ func (this *decompiler) ParseFile() error {
 		switch class.constant_pool[i].tag {
 		case CONSTANT_Utf8:
 			lbuff := make([]byte, 2)
-			_, err = reader.Read(lbuff)
+			_, err = io.ReadFull(reader, lbuff)
 			if err != nil {
 				return err
 			}
 			len := binary.BigEndian.Uint16(lbuff)
 			sbuff := make([]byte, len)
-			_, err = reader.Read(sbuff)
+			_, err = io.ReadFull(reader, sbuff)
 			if err != nil {
 				return err
 			}
@@ -130,35 +161,38 @@ func (this *decompiler) ParseFile() error {
 			class.constant_pool[i].info = make([]byte, 4)
 			buff = make([]byte, 4)
 		default:
-			fmt.Printf("TODO: case for %v\n", class.constant_pool[i].tag)
-			continue
+			return fmt.Errorf("unknown constant pool tag %d at index %d", class.constant_pool[i].tag, i+1)
 		}
-		_, err = reader.Read(buff)
+		_, err = io.ReadFull(reader, buff)
 		if err != nil {
 			return err
 		}
 		class.constant_pool[i].info = buff
+		if class.constant_pool[i].tag == CONSTANT_Long || class.constant_pool[i].tag == CONSTANT_Double {
+			// 8 byte constants take two entries, the second one is unusable
+			i++
+		}
 	}
 	buff = make([]byte, 2)
-	_, err = reader.Read(buff)
+	_, err = io.ReadFull(reader, buff)
 	if err != nil {
 		return err
 	}
 	class.access_flags = AccessFlags(binary.BigEndian.Uint16(buff))
 	buff = make([]byte, 2)
-	_, err = reader.Read(buff)
+	_, err = io.ReadFull(reader, buff)
 	if err != nil {
 		return err
 	}
 	class.this_class = binary.BigEndian.Uint16(buff)
 	buff = make([]byte, 2)
-	_, err = reader.Read(buff)
+	_, err = io.ReadFull(reader, buff)
 	if err != nil {
 		return err
 	}
 	class.super_class = binary.BigEndian.Uint16(buff)
 	buff = make([]byte, 2)
-	_, err = reader.Read(buff)
+	_, err = io.ReadFull(reader, buff)
 	if err != nil {
 		return err
 	}
@@ -166,47 +200,49 @@ func (this *decompiler) ParseFile() error {
 	class.interfaces = make([]uint16, class.interfaces_count)
 	buff = make([]byte, 2)
 	for i := uint16(0); i < class.interfaces_count; i++ {
-		_, err = reader.Read(buff)
+		_, err = io.ReadFull(reader, buff)
 		if err != nil {
 			return err
 		}
 		class.interfaces[i] = binary.BigEndian.Uint16(buff)
 	}
 	buff = make([]byte, 2)
-	_, err = reader.Read(buff)
+	_, err = io.ReadFull(reader, buff)
 	if err != nil {
 		return err
 	}
 	class.fields_count = binary.BigEndian.Uint16(buff)
 	class.fields = make([]FieldInfo, class.fields_count)
 	for i := uint16(0); i < class.fields_count; i++ {
-		fi := FieldInfo{}
+		fi := FieldInfo{
+			release: class.Release(),
+		}
 		buff = make([]byte, 2)
-		_, err = reader.Read(buff)
+		_, err = io.ReadFull(reader, buff)
 		if err != nil {
 			return err
 		}
 		fi.access_flags = AccessFlags(binary.BigEndian.Uint16(buff))
 		buff = make([]byte, 2)
-		_, err = reader.Read(buff)
+		_, err = io.ReadFull(reader, buff)
 		if err != nil {
 			return err
 		}
 		fi.name_index = binary.BigEndian.Uint16(buff)
 		buff = make([]byte, 2)
-		_, err = reader.Read(buff)
+		_, err = io.ReadFull(reader, buff)
 		if err != nil {
 			return err
 		}
 		fi.descriptor_index = binary.BigEndian.Uint16(buff)
 		buff = make([]byte, 2)
-		_, err = reader.Read(buff)
+		_, err = io.ReadFull(reader, buff)
 		if err != nil {
 			return err
 		}
 		fi.attributes_count = binary.BigEndian.Uint16(buff)
 		fi.attributes = make([]AttributeInfo, fi.attributes_count)
-		for i := uint16(0); i < class.attributes_count; i++ {
+		for i := uint16(0); i < fi.attributes_count; i++ {
 			fi.attributes[i], err = this.getAtribules(reader)
 			if err != nil {
 				return err
@@ -215,34 +251,36 @@ func (this *decompiler) ParseFile() error {
 		class.fields[i] = fi
 	}
 	buff = make([]byte, 2)
-	_, err = reader.Read(buff)
+	_, err = io.ReadFull(reader, buff)
 	if err != nil {
 		return err
 	}
 	class.methods_count = binary.BigEndian.Uint16(buff)
 	class.methods = make([]MethodInfo, class.methods_count)
 	for i := uint16(0); i < class.methods_count; i++ {
-		mt := MethodInfo{}
+		mt := MethodInfo{
+			release: class.Release(),
+		}
 		buff = make([]byte, 2)
-		_, err = reader.Read(buff)
+		_, err = io.ReadFull(reader, buff)
 		if err != nil {
 			return err
 		}
 		mt.access_flags = AccessFlags(binary.BigEndian.Uint16(buff))
 		buff = make([]byte, 2)
-		_, err = reader.Read(buff)
+		_, err = io.ReadFull(reader, buff)
 		if err != nil {
 			return err
 		}
 		mt.name_index = binary.BigEndian.Uint16(buff)
 		buff = make([]byte, 2)
-		_, err = reader.Read(buff)
+		_, err = io.ReadFull(reader, buff)
 		if err != nil {
 			return err
 		}
 		mt.descriptor_index = binary.BigEndian.Uint16(buff)
 		buff = make([]byte, 2)
-		_, err = reader.Read(buff)
+		_, err = io.ReadFull(reader, buff)
 		if err != nil {
 			return err
 		}
@@ -257,7 +295,7 @@ func (this *decompiler) ParseFile() error {
 		class.methods[i] = mt
 	}
 	buff = make([]byte, 2)
-	_, err = reader.Read(buff)
+	_, err = io.ReadFull(reader, buff)
 	if err != nil {
 		return err
 	}
@@ -274,99 +312,211 @@ func (this *decompiler) ParseFile() error {
 }
 
 func (this *decompiler) WriteFile(ofile string) error {
-	var err error
+	text, err := this.Source()
+	if err != nil {
+		return err
+	}
 	f, err := os.Create(ofile)
 	if err != nil {
 		return err
 	}
 	defer f.Close()
-	text := ""
 	writer := bufio.NewWriter(f)
-	defer writer.Flush()
-	str, err := this.accessFlagsToString()
+	_, err = writer.WriteString(text)
 	if err != nil {
 		return err
 	}
-	text += str
-	thclass := CONSTANT_Class_info{
-		this.class.constant_pool[this.class.this_class-1],
+	return writer.Flush()
+}
+
+// Source returns the Java source of the class with its nested classes.
+func (this *decompiler) Source() (text string, err error) {
+	defer recoverError(&err)
+	text, imports, err := this.classCode()
+	if err != nil {
+		return "", err
+	}
+	text = this.class.Release().Header() + importsCode(imports) + text
+	if this.options.LineNumbers == LineNumbersAlign {
+		text = alignLines(text)
 	}
-	i := thclass.NameIndex() - 1
-	nameclass := CONSTANT_Utf8_info{
-		CpInfo: this.class.constant_pool[i],
+	return text, nil
+}
+
+// Failures returns the fields and methods the last Source replaced with
+// stubs, including those of nested classes.
+func (this *decompiler) Failures() Errors {
+	return this.failures
+}
+
+// fail records a member that was replaced with a stub.
+func (this *decompiler) fail(member string, err error) {
+	name, _ := this.Name()
+	this.failures = append(this.failures, ClassError{name + "." + member, err})
+}
+
+// classCode renders the class declaration with its member, local and
+// anonymous classes nested in it, and returns the package declaration and
+// imports separately.
+func (this *decompiler) classCode() (string, string, error) {
+	var err error
+	text := ""
+	this.failures = nil
+	if this.class.access_flags&ACC_MODULE != 0 {
+		if !this.class.Release().Allows(FeatureModules) {
+			return "", "", fmt.Errorf("module-info in a %v class file", this.class.Release())
+		}
+		return this.moduleCode()
 	}
-	fmt.Print(nameclass.Values())
-	s, err := nameclass.Values()
+	this.record = this.recordInfo()
+	anonymous := this.isAnonymous()
+	str, err := this.accessFlagsToString()
 	if err != nil {
-		return err
+		return "", "", err
 	}
-	imports := this.addImport(s)
-	classnames := strings.Split(s, "/")
-	text += "/* " + s + " */" + classnames[len(classnames)-1]
-	if this.class.super_class > 0 {
-		text += " extends "
-		super := CONSTANT_Class_info{
-			this.class.constant_pool[this.class.super_class-1],
-		}
-		i = super.NameIndex() - 1
-		nameclass = CONSTANT_Utf8_info{
-			CpInfo: this.class.constant_pool[i],
+	s, err := this.Name()
+	if err != nil {
+		return "", "", err
+	}
+	annotations, imports := annotationsCode(this.class.constant_pool, this.class.attributes, "\n", this.class.Release())
+	text += annotations
+	if anonymous {
+		header, imp := this.anonymousHeader()
+		text += header
+		imports += imp
+	} else {
+		text += str
+		if this.innerEntry() == nil {
+			imports += this.addImport(s)
 		}
-		s, err := nameclass.Values()
+		text += this.innerName()
+	}
+	switch {
+	case anonymous:
+	case this.record != nil:
+		components, imp := this.record.header()
+		text += components
+		imports += imp
+	case this.class.access_flags&ACC_INTERFACE != 0:
+		// interfaces extend their super interfaces, java/lang/Object is implied
+	case this.class.super_class > 0:
+		s, err := classNameAt(this.class.constant_pool, this.class.super_class)
 		if err != nil {
-			return err
+			return "", "", err
+		}
+		if s == "java/lang/Object" || s == "java/lang/Enum" && this.class.access_flags&ACC_ENUM != 0 {
+			// implied
+			break
 		}
 		imports = this.addImport(s) + imports
-		classnames := strings.Split(s, "/")
-		text += "/* " + s + " */" + classnames[len(classnames)-1]
-	}
-	if this.class.interfaces_count > 0 {
-		text += "implements"
-		for i, inter := range this.class.interfaces {
-			sinter := CONSTANT_Class_info{
-				this.class.constant_pool[inter-1],
-			}
-			nameclass = CONSTANT_Utf8_info{}
-			nameclass.CpInfo = this.class.constant_pool[sinter.NameIndex()-1]
-			interfacename, err := nameclass.Values()
+		text += " extends " + simpleName(s)
+	}
+	interfaces := this.sourceInterfaces()
+	if len(interfaces) > 0 && !anonymous {
+		if this.class.access_flags&ACC_INTERFACE != 0 {
+			text += " extends"
+		} else {
+			text += " implements"
+		}
+		for i, inter := range interfaces {
+			interfacename, err := classNameAt(this.class.constant_pool, inter)
 			if err != nil {
-				return err
+				return "", "", err
 			}
-			text += " " + interfacename
-			if i < len(this.class.interfaces) {
+			text += " " + simpleName(interfacename)
+			imports += this.addImport(interfacename)
+			if i < len(interfaces)-1 {
 				text += ","
 			}
 		}
 	}
-	text += " {\n\t"
+	permits, imp, err := this.permitsClause()
+	if err != nil {
+		return "", "", err
+	}
+	text += permits
+	imports += imp
+	text += " {\n"
 
+	cp := this.class.constant_pool
 	if this.class.fields_count > 0 {
 		for _, field := range this.class.fields {
-			text += field.GetCode(this.class.constant_pool) + "\n\t"
-			imports += field.imports
+			if this.record != nil && this.isImplicitRecordField(field) {
+				continue
+			}
+			if isSyntheticOuterField(field, cp) {
+				continue
+			}
+			code, err := field.safeCode(cp)
 			if err != nil {
-				return err
+				this.fail(field.Name(cp), err)
+				code = "// " + field.Name(cp) + " " + field.Descriptor(cp) + ": " + err.Error()
 			}
-			//text += s + "\n"
-
+			text += member(code)
+			imports += field.imports
 		}
 	}
+	methods := make([]MethodInfo, len(this.class.methods))
+	copy(methods, this.class.methods)
+	unplaced, imp, err := this.placeLocalClasses(methods)
+	if err != nil {
+		return "", "", err
+	}
+	imports += imp
 	if this.class.methods_count > 0 {
-		for _, method := range this.class.methods {
-			text += method.GetCode(this.class.constant_pool) + "\n\t"
+		for _, method := range methods {
+			if isSyntheticAccessor(method, cp) {
+				continue
+			}
+			if anonymous && method.Name(cp) == "<init>" {
+				continue
+			}
+			if this.record != nil {
+				if this.isImplicitRecordMethod(method) {
+					continue
+				}
+				if canonical, body := this.compactConstructor(method); canonical {
+					if len(body) > 0 {
+						code := this.compactConstructorCode(method, body)
+						text += separated(text) + member(strings.Replace(code, "<init>", this.innerName(), 1))
+					}
+					continue
+				}
+			}
+			method.lineNumbers = this.options.LineNumbers
+			method.set = this.set
+			method.owner = s
+			if method.Name(cp) == "<init>" {
+				method.outer = this.outerInstance()
+			}
+			code, err := method.safeCode(cp)
+			if err != nil {
+				this.fail(method.Name(cp)+method.Descriptor(cp), err)
+				code = method.stubCode(cp, err)
+			}
+			if method.Name(cp) == "<init>" {
+				code = strings.Replace(code, "<init>", this.innerName(), 1) //Пока так
+			}
+			text += separated(text) + member(code)
 			imports += method.imports
 		}
 	}
+	for _, nested := range append(this.memberClasses(), unplaced...) {
+		nested.options = this.options
+		code, imp, err := nested.classCode()
+		if err != nil {
+			return "", "", err
+		}
+		this.failures = append(this.failures, nested.failures...)
+		text += separated(text) + member(code)
+		imports += imp
+	}
 
-	text += "\n}"
-	text = imports + "\n\n" + text
-	name := classnames[len(classnames)-1]
-	text = strings.ReplaceAll(text, "<init>", name) //Пока так
-	_, err = writer.WriteString(text)
-	if err != nil {
-		return err
+	text += "}"
+	if i := strings.LastIndex(s, "/"); i >= 0 && this.Outer() == nil {
+		imports = "package " + strings.ReplaceAll(s[:i], "/", ".") + ";\n" + imports
 	}
-	return err
+	return text, imports, err
 }
 
 /*
@@ -388,6 +538,16 @@ func (this *decompiler) fieldToString(fi FieldInfo) (string, error) {
 func (this *decompiler) accessFlagsToString() (string, error) {
 	str := ""
 	flags := this.class.access_flags
+	if e := this.innerEntry(); e != nil {
+		// InnerClasses keeps the flags from the source, including
+		// private, protected and static
+		flags = e.inner_class_access_flags
+	}
+	if this.record != nil {
+		// records are implicitly final
+		flags &^= ACC_FINAL
+	}
+	keyword := ""
 
 MAINLOOP:
 	for {
@@ -395,38 +555,114 @@ MAINLOOP:
 		case flags | ACC_PUBLIC:
 			flags = flags ^ ACC_PUBLIC
 			str = fmt.Sprint(str, "public ")
+		case flags | ACC_PRIVATE:
+			flags = flags ^ ACC_PRIVATE
+			str = fmt.Sprint(str, "private ")
+		case flags | ACC_PROTECTED:
+			flags = flags ^ ACC_PROTECTED
+			str = fmt.Sprint(str, "protected ")
+		case flags | ACC_STATIC:
+			flags = flags ^ ACC_STATIC
+			str = fmt.Sprint(str, "static ")
 		case flags | ACC_FINAL:
 			flags = flags ^ ACC_FINAL
 			str = fmt.Sprint(str, "final ")
 		case flags | ACC_SUPER:
+			// set by every compiler since 1.0.2, nothing in source
 			flags = flags ^ ACC_SUPER
-			str = fmt.Sprint(str, "/* super */ ")
 		case flags | ACC_ABSTRACT:
 			flags = flags ^ ACC_ABSTRACT
 			str = fmt.Sprint(str, "abstract ")
 		case flags | ACC_INTERFACE:
 			flags = flags ^ ACC_INTERFACE
-			str = fmt.Sprint(str, "interface ")
+			keyword = this.sealingModifier() + "interface "
 		case flags | ACC_SYNTHETIC:
 			flags = flags ^ ACC_SYNTHETIC
 			str = fmt.Sprint(str, "/* synthetic */ ")
 		case flags | ACC_ANNOTATION:
 			flags = flags ^ ACC_ANNOTATION
-			str = fmt.Sprint(str, "@interface ")
+			keyword = "@interface "
+			if !this.class.Release().Allows(FeatureAnnotations) {
+				keyword = "/* @ */interface "
+			}
 		case flags | ACC_ENUM:
 			flags = flags ^ ACC_ENUM
-			str = fmt.Sprint(str, "enum ")
+			keyword = "enum "
+			if !this.class.Release().Allows(FeatureEnums) {
+				keyword = "/* enum */ class "
+			}
 		case flags | ACC_MODULE:
 			flags = flags ^ ACC_MODULE
-			str = fmt.Sprint(str, "module ")
+			keyword = "module "
 		default:
-			str = fmt.Sprint(str, "class ")
+			switch {
+			case keyword != "":
+			case this.record != nil:
+				keyword = "record "
+			default:
+				keyword = this.sealingModifier() + "class "
+			}
+			str = fmt.Sprint(str, keyword)
 			break MAINLOOP
 		}
 	}
 	return str, nil
 }
 
+// sourceInterfaces drops java/lang/annotation/Annotation, which every
+// @interface extends implicitly.
+func (this *decompiler) sourceInterfaces() []uint16 {
+	if this.class.access_flags&ACC_ANNOTATION == 0 {
+		return this.class.interfaces
+	}
+	var interfaces []uint16
+	for _, inter := range this.class.interfaces {
+		if name, _ := classNameAt(this.class.constant_pool, inter); name != "java/lang/annotation/Annotation" {
+			interfaces = append(interfaces, inter)
+		}
+	}
+	return interfaces
+}
+
+// importsCode renders the package declaration and imports the members of
+// a class collected: once each, sorted, and without those of java.lang and
+// of the package itself, which need no import.
+func importsCode(collected string) string {
+	pkg := ""
+	seen := make(map[string]bool)
+	var imports []string
+	for _, line := range strings.Split(collected, "\n") {
+		if strings.HasPrefix(line, "package ") {
+			if pkg == "" {
+				pkg = line
+			}
+			continue
+		}
+		if !strings.HasPrefix(line, "import ") {
+			continue
+		}
+		name := strings.TrimPrefix(line, "import ")
+		if i := strings.Index(name, ";"); i >= 0 {
+			name = name[:i]
+		}
+		i := strings.LastIndex(name, ".")
+		if i < 0 || name[:i] == "java.lang" || "package "+name[:i]+";" == pkg || seen[name] {
+			continue
+		}
+		seen[name] = true
+		imports = append(imports, "import "+name+";\n")
+	}
+	sort.Strings(imports)
+	text := ""
+	if pkg != "" {
+		text = pkg + "\n\n"
+	}
+	if len(imports) > 0 {
+		text += strings.Join(imports, "") + "\n"
+	}
+	return text
+}
+
 func (this *decompiler) addImport(classname string) string {
 	imports := strings.ReplaceAll(classname, "/", ".")
 	return "import " + imports + ";\n"
@@ -0,0 +1,55 @@
+package decompiler
+
+import "fmt"
+
+// fieldDescriptorLen returns the length of the field descriptor at the
+// start of desc, e.g. 18 for "Ljava/lang/String;I".
+func fieldDescriptorLen(desc string) (int, error) {
+	i := 0
+	for i < len(desc) && desc[i] == '[' {
+		i++
+	}
+	if i >= len(desc) {
+		return 0, fmt.Errorf("descriptor %q truncated", desc)
+	}
+	switch desc[i] {
+	case 'B', 'C', 'D', 'F', 'I', 'J', 'S', 'Z':
+		return i + 1, nil
+	case 'L':
+		for j := i + 1; j < len(desc); j++ {
+			if desc[j] == ';' {
+				return j + 1, nil
+			}
+		}
+		return 0, fmt.Errorf("descriptor %q: missing ';'", desc)
+	}
+	return 0, fmt.Errorf("descriptor %q: unexpected %q", desc, desc[i])
+}
+
+// parseMethodDescriptor splits "(ILjava/lang/String;)V" into its parameter
+// descriptors and the return descriptor.
+func parseMethodDescriptor(desc string) ([]string, string, error) {
+	if len(desc) == 0 || desc[0] != '(' {
+		return nil, "", fmt.Errorf("method descriptor %q: missing '('", desc)
+	}
+	var params []string
+	i := 1
+	for i < len(desc) && desc[i] != ')' {
+		n, err := fieldDescriptorLen(desc[i:])
+		if err != nil {
+			return nil, "", err
+		}
+		params = append(params, desc[i:i+n])
+		i += n
+	}
+	if i >= len(desc) {
+		return nil, "", fmt.Errorf("method descriptor %q: missing ')'", desc)
+	}
+	ret := desc[i+1:]
+	if ret != "V" {
+		if n, err := fieldDescriptorLen(ret); err != nil || n != len(ret) {
+			return nil, "", fmt.Errorf("method descriptor %q: bad return type", desc)
+		}
+	}
+	return params, ret, nil
+}
@@ -0,0 +1,492 @@
+package decompiler
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// The disassembler writes the text format that Assemble reads, described
+// in assemble.go, so that its output can be edited and assembled again.
+
+var fieldFlagNames = []struct {
+	flag AccessFlags
+	name string
+}{
+	{ACC_PUBLIC, "public"},
+	{ACC_PRIVATE, "private"},
+	{ACC_PROTECTED, "protected"},
+	{ACC_STATIC, "static"},
+	{ACC_FINAL, "final"},
+	{ACC_VOLATILE, "volatile"},
+	{ACC_TRANSIENT, "transient"},
+	{ACC_SYNTHETIC, "synthetic"},
+	{ACC_ENUM, "enum"},
+}
+
+var methodFlagNames = []struct {
+	flag AccessFlags
+	name string
+}{
+	{ACC_PUBLIC, "public"},
+	{ACC_PRIVATE, "private"},
+	{ACC_PROTECTED, "protected"},
+	{ACC_STATIC, "static"},
+	{ACC_FINAL, "final"},
+	{ACC_SYNCHRONIZED, "synchronized"},
+	{ACC_BRIDGE, "bridge"},
+	{ACC_VARARGS, "varargs"},
+	{ACC_NATIVE, "native"},
+	{ACC_ABSTRACT, "abstract"},
+	{ACC_STRICT, "strict"},
+	{ACC_SYNTHETIC, "synthetic"},
+}
+
+var constantTagNames = map[ConstantTag]string{
+	CONSTANT_Utf8:               "Utf8",
+	CONSTANT_Integer:            "Int",
+	CONSTANT_Float:              "Float",
+	CONSTANT_Long:               "Long",
+	CONSTANT_Double:             "Double",
+	CONSTANT_Class:              "Class",
+	CONSTANT_String:             "String",
+	CONSTANT_Fieldref:           "Field",
+	CONSTANT_Methodref:          "Method",
+	CONSTANT_InterfaceMethodref: "InterfaceMethod",
+	CONSTANT_NameAndType:        "NameAndType",
+	CONSTANT_MethodHandle:       "MethodHandle",
+	CONSTANT_MethodType:         "MethodType",
+	CONSTANT_Dynamic:            "Dynamic",
+	CONSTANT_InvokeDynamic:      "InvokeDynamic",
+	CONSTANT_Module:             "Module",
+	CONSTANT_Package:            "Package",
+}
+
+var referenceKindNames = [...]string{
+	REF_getField:         "getField",
+	REF_getStatic:        "getStatic",
+	REF_putField:         "putField",
+	REF_putStatic:        "putStatic",
+	REF_invokeVirtual:    "invokeVirtual",
+	REF_invokeStatic:     "invokeStatic",
+	REF_invokeSpecial:    "invokeSpecial",
+	REF_newInvokeSpecial: "newInvokeSpecial",
+	REF_invokeInterface:  "invokeInterface",
+}
+
+// newarrayNames names the atype operand of newarray.
+var newarrayNames = map[byte]string{
+	4: "boolean", 5: "char", 6: "float", 7: "double",
+	8: "byte", 9: "short", 10: "int", 11: "long",
+}
+
+// rawIndex matches a constant pool index written as is, e.g. [12].
+var rawIndex = regexp.MustCompile(`^\[[0-9]+\]$`)
+
+// asmName writes s bare when the assembler reads it back as the same
+// token, and quoted otherwise.
+func asmName(s string) string {
+	if s == "" || s[0] == ';' || strings.HasPrefix(s, "0x") || rawIndex.MatchString(s) || !utf8.ValidString(s) {
+		return strconv.Quote(s)
+	}
+	for _, r := range s {
+		if r == '"' || r == '{' || r == '}' || unicode.IsSpace(r) || !unicode.IsPrint(r) {
+			return strconv.Quote(s)
+		}
+	}
+	return s
+}
+
+func flagsText(names []struct {
+	flag AccessFlags
+	name string
+}, flags AccessFlags) string {
+	var words []string
+	for _, f := range names {
+		if flags&f.flag != 0 {
+			words = append(words, f.name)
+			flags &^= f.flag
+		}
+	}
+	if flags != 0 {
+		words = append(words, fmt.Sprintf("%#04x", uint16(flags)))
+	}
+	return strings.Join(words, " ")
+}
+
+// asmFloat writes a float so that it parses back to the same bits: NaNs
+// as their raw bits, everything else in decimal.
+func asmFloat(bits uint64, size int) string {
+	if size == 32 {
+		v := math.Float32frombits(uint32(bits))
+		if v != v {
+			return fmt.Sprintf("0x%08x", bits)
+		}
+		return strconv.FormatFloat(float64(v), 'g', -1, 32)
+	}
+	v := math.Float64frombits(bits)
+	if v != v {
+		return fmt.Sprintf("0x%016x", bits)
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+type disassembler struct {
+	cp   []CpInfo
+	text strings.Builder
+}
+
+// Disassemble writes the class in the text format of Assemble: the header,
+// the fields and the methods with their bytecode and attributes, with
+// constant pool references written by value.
+func (this *decompiler) Disassemble() (_ string, err error) {
+	defer recoverError(&err)
+	d := &disassembler{cp: this.class.constant_pool}
+	d.class(&this.class)
+	return d.text.String(), nil
+}
+
+func (this *disassembler) class(class *ClassFile) {
+	fmt.Fprintf(&this.text, ".version %d %d\n", class.major_version, class.minor_version)
+	this.line("", ".class", flagsText(classFlagNames, class.access_flags), this.className(class.this_class))
+	if class.super_class != 0 {
+		this.line("", ".super", this.className(class.super_class))
+	}
+	for _, i := range class.interfaces {
+		this.line("", ".implements", this.className(i))
+	}
+	for i := range class.attributes {
+		this.attribute("", &class.attributes[i])
+	}
+	for _, f := range class.fields {
+		this.text.WriteString("\n")
+		this.line("", ".field", flagsText(fieldFlagNames, f.access_flags), this.utf8(f.name_index), this.utf8(f.descriptor_index))
+		for i := range f.attributes {
+			this.attribute("\t", &f.attributes[i])
+		}
+	}
+	for _, m := range class.methods {
+		this.text.WriteString("\n")
+		this.line("", ".method", flagsText(methodFlagNames, m.access_flags), this.utf8(m.name_index), this.utf8(m.descriptor_index))
+		for i := range m.attributes {
+			a := &m.attributes[i]
+			if name, _ := utf8At(this.cp, a.attribute_name_index); name != "Code" || !this.code(a) {
+				this.attribute("\t", a)
+			}
+		}
+		this.text.WriteString(".end method\n")
+	}
+	this.text.WriteString("\n.end class\n")
+}
+
+// line writes the non-empty words separated by spaces.
+func (this *disassembler) line(indent string, words ...string) {
+	this.text.WriteString(indent)
+	first := true
+	for _, w := range words {
+		if w == "" {
+			continue
+		}
+		if !first {
+			this.text.WriteString(" ")
+		}
+		first = false
+		this.text.WriteString(w)
+	}
+	this.text.WriteString("\n")
+}
+
+func (this *disassembler) utf8(i uint16) string {
+	if s, err := utf8At(this.cp, i); err == nil {
+		return asmName(s)
+	}
+	return fmt.Sprintf("[%d]", i)
+}
+
+func (this *disassembler) className(i uint16) string {
+	if s, err := classNameAt(this.cp, i); err == nil {
+		return asmName(s)
+	}
+	return fmt.Sprintf("[%d]", i)
+}
+
+// constant writes the entry at index i by value, or its index in brackets
+// when it is missing or malformed.
+func (this *disassembler) constant(i uint16) string {
+	if s, ok := this.value(i); ok {
+		return s
+	}
+	return fmt.Sprintf("[%d]", i)
+}
+
+func (this *disassembler) value(i uint16) (string, bool) {
+	if i == 0 || int(i) > len(this.cp) {
+		return "", false
+	}
+	c := this.cp[i-1]
+	tag, ok := constantTagNames[c.tag]
+	if !ok {
+		return "", false
+	}
+	switch c.tag {
+	case CONSTANT_Utf8:
+		s, err := utf8At(this.cp, i)
+		return tag + " " + strconv.Quote(s), err == nil
+	case CONSTANT_Integer:
+		return tag + " " + strconv.Itoa(int(int32(binary.BigEndian.Uint32(c.info)))), true
+	case CONSTANT_Float:
+		return tag + " " + asmFloat(uint64(binary.BigEndian.Uint32(c.info)), 32), true
+	case CONSTANT_Long:
+		return tag + " " + strconv.FormatInt(int64(binary.BigEndian.Uint64(c.info)), 10), true
+	case CONSTANT_Double:
+		return tag + " " + asmFloat(binary.BigEndian.Uint64(c.info), 64), true
+	case CONSTANT_String:
+		s, err := utf8At(this.cp, binary.BigEndian.Uint16(c.info))
+		return tag + " " + strconv.Quote(s), err == nil
+	case CONSTANT_Class, CONSTANT_MethodType, CONSTANT_Module, CONSTANT_Package:
+		s, err := utf8At(this.cp, binary.BigEndian.Uint16(c.info))
+		return tag + " " + asmName(s), err == nil
+	case CONSTANT_NameAndType:
+		name, desc, err := nameAndTypeAt(this.cp, i)
+		return tag + " " + asmName(name) + " " + asmName(desc), err == nil
+	case CONSTANT_Fieldref, CONSTANT_Methodref, CONSTANT_InterfaceMethodref:
+		owner, name, desc, err := memberRefAt(this.cp, i)
+		return tag + " " + asmName(owner) + " " + asmName(name) + " " + asmName(desc), err == nil
+	case CONSTANT_MethodHandle:
+		mh := CONSTANT_MethodHandle_info{c}
+		ref := mh.ReferenceIndex()
+		if _, _, _, err := memberRefAt(this.cp, ref); err != nil {
+			return "", false
+		}
+		kind := strconv.Itoa(int(mh.ReferenceKind()))
+		if int(mh.ReferenceKind()) < len(referenceKindNames) && mh.ReferenceKind() != 0 {
+			kind = referenceKindNames[mh.ReferenceKind()]
+		}
+		return tag + " " + kind + " " + this.constant(ref), true
+	case CONSTANT_Dynamic, CONSTANT_InvokeDynamic:
+		d := CONSTANT_Dynamic_info{c}
+		name, desc, err := nameAndTypeAt(this.cp, d.NameAndTypeIndex())
+		return fmt.Sprintf("%s %d %s %s", tag, d.BootstrapMethodAttrIndex(), asmName(name), asmName(desc)), err == nil
+	}
+	return "", false
+}
+
+// attribute writes an attribute as bytes in hex and constant pool
+// references by value, for the attributes whose references are known.
+func (this *disassembler) attribute(indent string, a *AttributeInfo) {
+	items, err := this.attributeItems(a)
+	if err != nil {
+		this.line(indent, ";", err.Error()+", indices are written as is")
+	}
+	head := indent + ".attribute " + this.utf8(a.attribute_name_index) + " {"
+	if short := strings.Join(append([]string{head}, items...), " ") + " }"; len(short) <= 100 {
+		this.text.WriteString(short + "\n")
+		return
+	}
+	this.text.WriteString(head + "\n")
+	for _, item := range items {
+		this.text.WriteString(indent + "\t" + item + "\n")
+	}
+	this.text.WriteString(indent + "}\n")
+}
+
+// attributeItems splits the info of an attribute into hex bytes and
+// constant pool references by value. When the references cannot be found
+// it is all hex, with the reason as error.
+func (this *disassembler) attributeItems(a *AttributeInfo) ([]string, error) {
+	name, err := utf8At(this.cp, a.attribute_name_index)
+	w := &refWalker{infoReader: infoReader{info: a.info}}
+	if err == nil {
+		err = w.attribute(this.cp, name)
+	}
+	slots := w.refs
+	for _, slot := range slots {
+		if len(slot) != 2 {
+			err = fmt.Errorf("one byte constant pool references in %s attribute", name)
+		}
+	}
+	if err != nil {
+		slots = nil
+	}
+	offset := func(slot []byte) int {
+		return cap(a.info) - cap(slot)
+	}
+	sort.Slice(slots, func(i, j int) bool {
+		return offset(slots[i]) < offset(slots[j])
+	})
+	var items []string
+	hexItems := func(b []byte) {
+		for len(b) > 0 {
+			n := len(b)
+			if n > 16 {
+				n = 16
+			}
+			items = append(items, "0x"+hex.EncodeToString(b[:n]))
+			b = b[n:]
+		}
+	}
+	pos := 0
+	for _, slot := range slots {
+		hexItems(a.info[pos:offset(slot)])
+		items = append(items, this.constant(slotIndex(slot)))
+		pos = offset(slot) + 2
+	}
+	hexItems(a.info[pos:])
+	return items, err
+}
+
+// code writes a Code attribute as instructions with labels, or reports
+// false when its bytes cannot be written that way and read back the same,
+// e.g. for branches into the middle of instructions.
+func (this *disassembler) code(a *AttributeInfo) bool {
+	ca, err := a.ToCodeAttribute()
+	if err != nil {
+		return false
+	}
+	size := 12 + len(ca.code) + 8*len(ca.exception_table)
+	for _, attr := range ca.attributes {
+		size += 6 + len(attr.info)
+	}
+	if size != len(a.info) {
+		return false
+	}
+	ins, err := decodeInstructions(ca.code)
+	if err != nil {
+		return false
+	}
+	boundary := map[int]bool{len(ca.code): true}
+	for _, in := range ins {
+		boundary[in.pc] = true
+	}
+	targets := make(map[int]bool)
+	ok := true
+	label := func(pc int) string {
+		if !boundary[pc] {
+			ok = false
+		}
+		targets[pc] = true
+		return "L" + strconv.Itoa(pc)
+	}
+	lines := make([]string, len(ins))
+	for i, in := range ins {
+		var valid bool
+		lines[i], valid = this.instruction(in, label)
+		ok = ok && valid
+	}
+	var catches []string
+	for _, e := range ca.exception_table {
+		catch := "any"
+		if e.catch_type != 0 {
+			catch = this.constant(e.catch_type)
+		}
+		catches = append(catches, fmt.Sprintf("\t\t.catch %s from %s to %s using %s\n",
+			catch, label(int(e.start_pc)), label(int(e.end_pc)), label(int(e.handler_pc))))
+	}
+	if !ok {
+		return false
+	}
+	fmt.Fprintf(&this.text, "\t.code stack %d locals %d\n", ca.max_stack, ca.max_locals)
+	for i, in := range ins {
+		if targets[in.pc] {
+			fmt.Fprintf(&this.text, "\tL%d:\n", in.pc)
+		}
+		this.text.WriteString("\t\t" + lines[i] + "\n")
+	}
+	if targets[len(ca.code)] {
+		fmt.Fprintf(&this.text, "\tL%d:\n", len(ca.code))
+	}
+	for _, c := range catches {
+		this.text.WriteString(c)
+	}
+	for i := range ca.attributes {
+		this.attribute("\t\t", &ca.attributes[i])
+	}
+	this.text.WriteString("\t.end code\n")
+	return true
+}
+
+// isLocalInstruction reports whether op takes a local variable index.
+func isLocalInstruction(op byte) bool {
+	return op >= 0x15 && op <= 0x19 || op >= 0x36 && op <= 0x3a || op == 0xa9
+}
+
+// instruction writes in with its operands; false means its operand bytes
+// would not be assembled back the same.
+func (this *disassembler) instruction(in Instruction, label func(pc int) string) (string, bool) {
+	op := in.opcode
+	name := in.Name()
+	u4 := func(i int) int32 {
+		return int32(binary.BigEndian.Uint32(in.operands[i:]))
+	}
+	switch {
+	case op == 0xc4:
+		sub := in.operands[0]
+		index := strconv.Itoa(int(binary.BigEndian.Uint16(in.operands[1:])))
+		switch {
+		case sub == 0x84:
+			return name + " iinc " + index + " " + strconv.Itoa(int(int16(binary.BigEndian.Uint16(in.operands[3:])))), true
+		case isLocalInstruction(sub):
+			return name + " " + opcodeNames[sub] + " " + index, true
+		}
+		return "", false
+	case isLocalInstruction(op):
+		return name + " " + strconv.Itoa(int(in.operands[0])), true
+	case op == 0x10:
+		return name + " " + strconv.Itoa(int(int8(in.operands[0]))), true
+	case op == 0x11:
+		return name + " " + strconv.Itoa(int(int16(in.Index()))), true
+	case op == 0x84:
+		return name + " " + strconv.Itoa(int(in.operands[0])) + " " + strconv.Itoa(int(int8(in.operands[1]))), true
+	case op == 0xbc:
+		if elem, ok := newarrayNames[in.operands[0]]; ok {
+			return name + " " + elem, true
+		}
+		return name + " " + strconv.Itoa(int(in.operands[0])), true
+	case op == 0x12:
+		return name + " " + this.constant(uint16(in.operands[0])), true
+	case op == 0xb9:
+		return name + " " + this.constant(in.Index()) + " " + strconv.Itoa(int(in.operands[2])), in.operands[3] == 0
+	case op == 0xba:
+		return name + " " + this.constant(in.Index()), in.operands[2] == 0 && in.operands[3] == 0
+	case op == 0xc5:
+		return name + " " + this.constant(in.Index()) + " " + strconv.Itoa(int(in.operands[2])), true
+	case op == 0x13 || op == 0x14 || op >= 0xb2 && op <= 0xb8 || op == 0xbb || op == 0xbd || op == 0xc0 || op == 0xc1:
+		return name + " " + this.constant(in.Index()), true
+	case op >= 0x99 && op <= 0xa8 || op == 0xc6 || op == 0xc7:
+		return name + " " + label(in.pc+int(int16(in.Index()))), true
+	case op == 0xc8 || op == 0xc9:
+		return name + " " + label(in.pc+int(u4(0))), true
+	case op == 0xaa || op == 0xab:
+		pad := 3 - in.pc%4
+		for _, b := range in.operands[:pad] {
+			if b != 0 {
+				return "", false
+			}
+		}
+		var text strings.Builder
+		text.WriteString(name)
+		def := label(in.pc + int(u4(pad)))
+		if op == 0xaa {
+			low := u4(pad + 4)
+			fmt.Fprintf(&text, " %d {\n", low)
+			for i := pad + 12; i < len(in.operands); i += 4 {
+				text.WriteString("\t\t\t" + label(in.pc+int(u4(i))) + "\n")
+			}
+		} else {
+			text.WriteString(" {\n")
+			for i := pad + 8; i < len(in.operands); i += 8 {
+				fmt.Fprintf(&text, "\t\t\t%d %s\n", u4(i), label(in.pc+int(u4(i+4))))
+			}
+		}
+		text.WriteString("\t\t} default " + def)
+		return text.String(), true
+	}
+	return name, true
+}
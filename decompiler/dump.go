@@ -0,0 +1,408 @@
+package decompiler
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ClassDump is a class file with its constant pool references resolved,
+// for encoding as JSON. The schema is stable: fields may be added, but
+// none are renamed, removed or change type. Names are internal names such
+// as java/lang/String, flags are the lower case Java keywords or JVMS
+// names (super, bridge, varargs, ...) with unknown bits as hex, and
+// constants are written as in the text format of Assemble, e.g.
+// `String "text"` or `Method java/lang/Object <init> ()V`.
+type ClassDump struct {
+	Name       string          `json:"name"`
+	Major      int             `json:"major"`
+	Minor      int             `json:"minor"`
+	Release    int             `json:"release"`
+	Preview    bool            `json:"preview,omitempty"`
+	Flags      []string        `json:"flags"`
+	Super      string          `json:"super,omitempty"` // empty for java/lang/Object and module-info
+	Interfaces []string        `json:"interfaces"`
+	Fields     []MemberDump    `json:"fields"`
+	Methods    []MemberDump    `json:"methods"`
+	Attributes []AttributeDump `json:"attributes"`
+}
+
+// MemberDump is a field or method. Code is the Code attribute of a
+// method, which is then not listed among the attributes.
+type MemberDump struct {
+	Name       string          `json:"name"`
+	Descriptor string          `json:"descriptor"`
+	Flags      []string        `json:"flags"`
+	Code       *CodeDump       `json:"code,omitempty"`
+	Attributes []AttributeDump `json:"attributes"`
+}
+
+// CodeDump is a Code attribute. Instructions are only dumped on request.
+type CodeDump struct {
+	MaxStack       int               `json:"max_stack"`
+	MaxLocals      int               `json:"max_locals"`
+	Length         int               `json:"length"`
+	Instructions   []InstructionDump `json:"instructions,omitempty"`
+	ExceptionTable []HandlerDump     `json:"exception_table"`
+	Attributes     []AttributeDump   `json:"attributes"`
+}
+
+// InstructionDump is an instruction at code offset Pc. Operands are those
+// of the text format of Assemble, with code offsets as branch targets:
+// `Method java/io/PrintStream println (Ljava/lang/String;)V`,
+// `0 { 28 30 } default 32` for a tableswitch.
+type InstructionDump struct {
+	Pc       int    `json:"pc"`
+	Op       string `json:"op"`
+	Operands string `json:"operands,omitempty"`
+}
+
+// HandlerDump is an exception table entry; CatchType is empty for any.
+type HandlerDump struct {
+	Start     int    `json:"start"`
+	End       int    `json:"end"`
+	Handler   int    `json:"handler"`
+	CatchType string `json:"catch_type,omitempty"`
+}
+
+// AttributeDump is an attribute. Value holds the attributes that are
+// decoded:
+//
+//	SourceFile, Signature, NestHost, ModuleMainClass  string
+//	ConstantValue                                     constant
+//	Exceptions, NestMembers, PermittedSubclasses,
+//	ModulePackages                                    []string
+//	InnerClasses                                      []InnerClassDump
+//	EnclosingMethod                                   EnclosingMethodDump
+//	LineNumberTable                                   []LineNumberDump
+//	BootstrapMethods                                  []BootstrapMethodDump
+//	Record                                            []MemberDump
+//
+// Deprecated and Synthetic have no value. Items holds the other
+// attributes, and those that fail to decode, as hex bytes and constants by
+// value like the .attribute statements of Assemble; Error says why an
+// attribute could not be decoded.
+type AttributeDump struct {
+	Name   string      `json:"name"`
+	Length int         `json:"length"`
+	Value  interface{} `json:"value,omitempty"`
+	Items  []string    `json:"items,omitempty"`
+	Error  string      `json:"error,omitempty"`
+}
+
+// InnerClassDump is an entry of InnerClasses; Outer and Name are empty for
+// local and anonymous classes.
+type InnerClassDump struct {
+	Inner string   `json:"inner"`
+	Outer string   `json:"outer,omitempty"`
+	Name  string   `json:"name,omitempty"`
+	Flags []string `json:"flags"`
+}
+
+// EnclosingMethodDump is an EnclosingMethod attribute. Method is the name
+// and descriptor, empty outside of a method.
+type EnclosingMethodDump struct {
+	Class  string `json:"class"`
+	Method string `json:"method,omitempty"`
+}
+
+type LineNumberDump struct {
+	Pc   int `json:"pc"`
+	Line int `json:"line"`
+}
+
+type BootstrapMethodDump struct {
+	Method    string   `json:"method"`
+	Arguments []string `json:"arguments"`
+}
+
+var innerClassFlagNames = []struct {
+	flag AccessFlags
+	name string
+}{
+	{ACC_PUBLIC, "public"},
+	{ACC_PRIVATE, "private"},
+	{ACC_PROTECTED, "protected"},
+	{ACC_STATIC, "static"},
+	{ACC_FINAL, "final"},
+	{ACC_INTERFACE, "interface"},
+	{ACC_ABSTRACT, "abstract"},
+	{ACC_SYNTHETIC, "synthetic"},
+	{ACC_ANNOTATION, "annotation"},
+	{ACC_ENUM, "enum"},
+}
+
+func flagList(names []struct {
+	flag AccessFlags
+	name string
+}, flags AccessFlags) []string {
+	list := strings.Fields(flagsText(names, flags))
+	if list == nil {
+		list = []string{}
+	}
+	return list
+}
+
+type dumper struct {
+	disassembler
+	code bool
+}
+
+// Dump returns the class for encoding as JSON, with the instructions of
+// the methods when code is set.
+func (this *decompiler) Dump(code bool) (_ *ClassDump, err error) {
+	defer recoverError(&err)
+	class := &this.class
+	name, err := this.Name()
+	if err != nil {
+		return nil, err
+	}
+	d := &dumper{disassembler: disassembler{cp: class.constant_pool}, code: code}
+	release := class.Release()
+	dump := &ClassDump{
+		Name:       name,
+		Major:      int(class.major_version),
+		Minor:      int(class.minor_version),
+		Release:    release.Number(),
+		Preview:    release.Preview(),
+		Flags:      flagList(classFlagNames, class.access_flags),
+		Interfaces: []string{},
+		Fields:     []MemberDump{},
+		Methods:    []MemberDump{},
+		Attributes: d.attributes(class.attributes),
+	}
+	if class.super_class != 0 {
+		dump.Super = d.name(class.super_class)
+	}
+	for _, i := range class.interfaces {
+		dump.Interfaces = append(dump.Interfaces, d.name(i))
+	}
+	for i := range class.fields {
+		f := &class.fields[i]
+		dump.Fields = append(dump.Fields, MemberDump{
+			Name:       d.utf8(f.name_index),
+			Descriptor: d.utf8(f.descriptor_index),
+			Flags:      flagList(fieldFlagNames, f.access_flags),
+			Attributes: d.attributes(f.attributes),
+		})
+	}
+	for i := range class.methods {
+		m := &class.methods[i]
+		member := MemberDump{
+			Name:       d.utf8(m.name_index),
+			Descriptor: d.utf8(m.descriptor_index),
+			Flags:      flagList(methodFlagNames, m.access_flags),
+			Attributes: []AttributeDump{},
+		}
+		for j := range m.attributes {
+			a := &m.attributes[j]
+			if n, _ := utf8At(d.cp, a.attribute_name_index); n == "Code" && member.Code == nil {
+				if member.Code = d.codeDump(a); member.Code != nil {
+					continue
+				}
+			}
+			member.Attributes = append(member.Attributes, d.attribute(a))
+		}
+		dump.Methods = append(dump.Methods, member)
+	}
+	return dump, nil
+}
+
+// utf8 and name resolve without the quoting of the text format, keeping
+// the index in brackets for broken references.
+func (this *dumper) utf8(i uint16) string {
+	if s, err := utf8At(this.cp, i); err == nil {
+		return s
+	}
+	return fmt.Sprintf("[%d]", i)
+}
+
+// name resolves a Class, Module or Package entry to its name.
+func (this *dumper) name(i uint16) string {
+	if i == 0 || int(i) > len(this.cp) {
+		return fmt.Sprintf("[%d]", i)
+	}
+	switch c := this.cp[i-1]; c.tag {
+	case CONSTANT_Class, CONSTANT_Module, CONSTANT_Package:
+		if s, err := utf8At(this.cp, uint16(c.info[0])<<8|uint16(c.info[1])); err == nil {
+			return s
+		}
+	}
+	return fmt.Sprintf("[%d]", i)
+}
+
+func (this *dumper) names(indices []uint16) []string {
+	names := []string{}
+	for _, i := range indices {
+		names = append(names, this.name(i))
+	}
+	return names
+}
+
+func (this *dumper) attributes(attrs []AttributeInfo) []AttributeDump {
+	dumps := []AttributeDump{}
+	for i := range attrs {
+		dumps = append(dumps, this.attribute(&attrs[i]))
+	}
+	return dumps
+}
+
+func (this *dumper) attribute(a *AttributeInfo) AttributeDump {
+	name, _ := utf8At(this.cp, a.attribute_name_index)
+	dump := AttributeDump{Name: this.utf8(a.attribute_name_index), Length: len(a.info)}
+	value, known, err := this.value(name, a)
+	switch {
+	case err != nil:
+		dump.Error = err.Error()
+	case known:
+		dump.Value = value
+		return dump
+	}
+	items, err := this.attributeItems(a)
+	dump.Items = items
+	if err != nil && dump.Error == "" {
+		dump.Error = err.Error()
+	}
+	return dump
+}
+
+// value decodes the attributes listed at AttributeDump; known is false
+// for the others.
+func (this *dumper) value(name string, a *AttributeInfo) (value interface{}, known bool, err error) {
+	r := infoReader{info: a.info}
+	switch name {
+	case "Deprecated", "Synthetic":
+		return nil, true, nil
+	case "SourceFile", "Signature":
+		value = this.utf8(r.u2())
+	case "NestHost", "ModuleMainClass":
+		value = this.name(r.u2())
+	case "ConstantValue":
+		value = this.constant(r.u2())
+	case "Exceptions", "NestMembers", "PermittedSubclasses", "ModulePackages":
+		value = this.names(r.u2s(r.u2()))
+	case "InnerClasses":
+		ia, err := a.ToInnerClassesAttribute()
+		if err != nil {
+			return nil, false, err
+		}
+		classes := []InnerClassDump{}
+		for _, c := range ia.classes {
+			inner := InnerClassDump{
+				Inner: this.name(c.inner_class_info_index),
+				Flags: flagList(innerClassFlagNames, c.inner_class_access_flags),
+			}
+			if c.outer_class_info_index != 0 {
+				inner.Outer = this.name(c.outer_class_info_index)
+			}
+			if c.inner_name_index != 0 {
+				inner.Name = this.utf8(c.inner_name_index)
+			}
+			classes = append(classes, inner)
+		}
+		return classes, true, nil
+	case "EnclosingMethod":
+		ea, err := a.ToEnclosingMethodAttribute()
+		if err != nil {
+			return nil, false, err
+		}
+		dump := EnclosingMethodDump{Class: this.name(ea.class_index)}
+		if ea.method_index != 0 {
+			name, desc, err := nameAndTypeAt(this.cp, ea.method_index)
+			if err != nil {
+				return nil, false, err
+			}
+			dump.Method = name + desc
+		}
+		return dump, true, nil
+	case "LineNumberTable":
+		la, err := a.ToLineNumberTableAttribute()
+		if err != nil {
+			return nil, false, err
+		}
+		lines := []LineNumberDump{}
+		for _, e := range la.line_number_table {
+			lines = append(lines, LineNumberDump{int(e.start_pc), int(e.line_number)})
+		}
+		return lines, true, nil
+	case "BootstrapMethods":
+		ba, err := a.ToBootstrapMethodsAttribute()
+		if err != nil {
+			return nil, false, err
+		}
+		methods := []BootstrapMethodDump{}
+		for _, bm := range ba.bootstrap_methods {
+			dump := BootstrapMethodDump{Method: this.constant(bm.bootstrap_method_ref), Arguments: []string{}}
+			for _, arg := range bm.bootstrap_arguments {
+				dump.Arguments = append(dump.Arguments, this.constant(arg))
+			}
+			methods = append(methods, dump)
+		}
+		return methods, true, nil
+	case "Record":
+		ra, err := a.ToRecordAttribute()
+		if err != nil {
+			return nil, false, err
+		}
+		components := []MemberDump{}
+		for _, c := range ra.components {
+			components = append(components, MemberDump{
+				Name:       this.utf8(c.name_index),
+				Descriptor: this.utf8(c.descriptor_index),
+				Flags:      []string{},
+				Attributes: this.attributes(c.attributes),
+			})
+		}
+		return components, true, nil
+	default:
+		return nil, false, nil
+	}
+	if r.err == nil && r.pos != len(r.info) {
+		r.err = fmt.Errorf("%d bytes left over", len(r.info)-r.pos)
+	}
+	if r.err != nil {
+		return nil, false, r.err
+	}
+	return value, true, nil
+}
+
+// codeDump decodes a Code attribute, or returns nil when it is malformed
+// so that it is dumped as items instead.
+func (this *dumper) codeDump(a *AttributeInfo) *CodeDump {
+	ca, err := a.ToCodeAttribute()
+	if err != nil {
+		return nil
+	}
+	dump := &CodeDump{
+		MaxStack:       int(ca.max_stack),
+		MaxLocals:      int(ca.max_locals),
+		Length:         len(ca.code),
+		ExceptionTable: []HandlerDump{},
+		Attributes:     this.attributes(ca.attributes),
+	}
+	if this.code {
+		ins, err := decodeInstructions(ca.code)
+		if err != nil {
+			return nil
+		}
+		for _, in := range ins {
+			text, _ := this.instruction(in, strconv.Itoa)
+			if in.opcode == 0xaa || in.opcode == 0xab {
+				text = strings.Join(strings.Fields(text), " ")
+			}
+			dump.Instructions = append(dump.Instructions, InstructionDump{
+				Pc:       in.pc,
+				Op:       in.Name(),
+				Operands: strings.TrimPrefix(strings.TrimPrefix(text, in.Name()), " "),
+			})
+		}
+	}
+	for _, e := range ca.exception_table {
+		h := HandlerDump{Start: int(e.start_pc), End: int(e.end_pc), Handler: int(e.handler_pc)}
+		if e.catch_type != 0 {
+			h.CatchType = this.name(e.catch_type)
+		}
+		dump.ExceptionTable = append(dump.ExceptionTable, h)
+	}
+	return dump
+}
@@ -0,0 +1,38 @@
+package decompiler
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestDump(t *testing.T) {
+	for _, b := range goldenClasses(t) {
+		dump, err := parseClass(t, b).Dump(true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := json.Marshal(dump); err != nil {
+			t.Errorf("%s: %v", dump.Name, err)
+		}
+	}
+	dump, err := parseClass(t, assemble(t, handWritten)).Dump(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := json.Marshal(dump)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		`"name":"odd name","major":49,"minor":0,"release":5,"flags":["public","super"],"super":"java/lang/Object","interfaces":[]`,
+		`{"name":"InnerClasses","length":10,"value":[{"inner":"odd name$1","flags":[]}]}`,
+		`{"name":"NaN","descriptor":"F","flags":["static","final"],"attributes":[{"name":"ConstantValue","length":2,"value":"Float 0x7fc00001"}]}`,
+		`{"pc":1,"op":"tableswitch","operands":"-1 { 24 52 } default 69"}`,
+		`"exception_table":[{"start":0,"end":73,"handler":73}]`,
+	} {
+		if !strings.Contains(string(b), want) {
+			t.Errorf("dump lacks %s:\n%s", want, b)
+		}
+	}
+}
@@ -0,0 +1,136 @@
+package decompiler
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Module is a module of an enterprise archive as declared in
+// META-INF/application.xml.
+type Module struct {
+	Kind        string // ejb, web, java or connector
+	URI         string
+	ContextRoot string
+}
+
+type applicationXml struct {
+	Modules []struct {
+		Ejb       string `xml:"ejb"`
+		Java      string `xml:"java"`
+		Connector string `xml:"connector"`
+		Web       struct {
+			URI         string `xml:"web-uri"`
+			ContextRoot string `xml:"context-root"`
+		} `xml:"web"`
+	} `xml:"module"`
+}
+
+// Modules reads the modules of an ear file from META-INF/application.xml.
+// The descriptor is optional since Java EE 5; without it every jar and war
+// at the top of the archive is a module.
+func (this *Archive) Modules() ([]Module, error) {
+	f := this.file("META-INF/application.xml")
+	if f == nil {
+		var modules []Module
+		for _, f := range this.files {
+			if strings.Contains(f.name, "/") {
+				continue
+			}
+			switch strings.ToLower(path.Ext(f.name)) {
+			case ".war":
+				modules = append(modules, Module{Kind: "web", URI: f.name})
+			case ".jar":
+				modules = append(modules, Module{Kind: "ejb", URI: f.name})
+			}
+		}
+		sort.Slice(modules, func(i, j int) bool {
+			return modules[i].URI < modules[j].URI
+		})
+		return modules, nil
+	}
+	rc, err := f.open()
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	var app applicationXml
+	if err := xml.NewDecoder(rc).Decode(&app); err != nil {
+		return nil, fmt.Errorf("%s!/%s: %w", this.path, f.name, err)
+	}
+	var modules []Module
+	for _, m := range app.Modules {
+		switch {
+		case m.Ejb != "":
+			modules = append(modules, Module{Kind: "ejb", URI: m.Ejb})
+		case m.Web.URI != "":
+			modules = append(modules, Module{Kind: "web", URI: m.Web.URI, ContextRoot: m.Web.ContextRoot})
+		case m.Java != "":
+			modules = append(modules, Module{Kind: "java", URI: m.Java})
+		case m.Connector != "":
+			modules = append(modules, Module{Kind: "connector", URI: m.Connector})
+		}
+	}
+	return modules, nil
+}
+
+// OpenModule reads the archive of a module into memory. Module URIs are
+// also used as output directories, so those leaving the ear are refused.
+func (this *Archive) OpenModule(m Module) (*Archive, error) {
+	name := path.Clean(m.URI)
+	if path.IsAbs(name) || name == ".." || strings.HasPrefix(name, "../") {
+		return nil, fmt.Errorf("%s: module %s is outside the archive", this.path, m.URI)
+	}
+	f := this.file(name)
+	if f == nil {
+		return nil, fmt.Errorf("%s: module %s not found", this.path, m.URI)
+	}
+	return this.openNested(*f)
+}
+
+// isDescriptor reports the deployment descriptors kept next to the
+// decompiled sources: the xml files directly in META-INF or WEB-INF, such
+// as application.xml, ejb-jar.xml, web.xml or jboss-web.xml.
+func isDescriptor(name string) bool {
+	dir, file := path.Split(name)
+	return (dir == "META-INF/" || dir == "WEB-INF/") && strings.EqualFold(path.Ext(file), ".xml")
+}
+
+// WriteDescriptors copies the deployment descriptors of the archive to
+// the same paths below dir.
+func (this *Archive) WriteDescriptors(dir string) error {
+	for _, f := range this.files {
+		if !isDescriptor(f.name) {
+			continue
+		}
+		if err := copyEntry(f.open, filepath.Join(dir, filepath.FromSlash(f.name))); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func copyEntry(open func() (io.ReadCloser, error), name string) error {
+	rc, err := open()
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+		return err
+	}
+	out, err := os.Create(name)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, rc); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
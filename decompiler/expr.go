@@ -0,0 +1,946 @@
+package decompiler
+
+import (
+	"fmt"
+	"strings"
+)
+
+// The expression pass turns straight-line method bodies, those without
+// branches, switches or exception handlers, into Java statements. Methods
+// it cannot handle keep the instruction listing of opcodeTostring.
+
+// maxExpression is the longest expression text the pass builds before
+// falling back to the listing.
+const maxExpression = 4096
+
+// nullType is the type of the null literal.
+const nullType = "null"
+
+// expr is a value on the simulated operand stack.
+type expr struct {
+	text string
+	// field descriptor of the static type, or nullType
+	typ string
+	// value of int constants, which javac also uses for boolean, char,
+	// byte and short literals
+	constant *int32
+	// set for binary, cast and other expressions that need parentheses
+	// when they are an operand
+	compound bool
+	// "new T" before its constructor has been called
+	uninitialized bool
+	// an assignment, which may stand as a statement
+	assignment bool
+	// 1 + slot of a local variable read, 0 for other expressions
+	local int
+}
+
+func (this expr) operand() string {
+	if this.compound {
+		return "(" + this.text + ")"
+	}
+	return this.text
+}
+
+// statement is a Java statement and the code range it came from.
+type statement struct {
+	pc   int
+	end  int
+	text string
+}
+
+// exprContext holds what the pass needs to know about the method.
+type exprContext struct {
+	cp      []CpInfo
+	set     *ClassSet
+	owner   string // internal name of the class of the method
+	method  string
+	static  bool
+	returns string
+	locals  map[int]string // slot to name
+	types   map[int]string // slot to field descriptor
+	imports string
+	// the outer instance parameter of an inner class constructor
+	outerThis string
+	// slot of the first local that is not a parameter
+	firstLocal int
+	// LocalVariableTable entries, when the class was compiled with -g
+	variables []LocalVariableEntry
+	// slot to the boolean, char, byte or short type an int local is
+	// used as
+	hints map[int]string
+	// source of the local and anonymous classes created in the method,
+	// keyed by internal name
+	inline map[string]string
+	err    error
+}
+
+// errUnsupported makes the pass give up on a method.
+type errUnsupported struct {
+	pc     int
+	opcode byte
+}
+
+func (this errUnsupported) Error() string {
+	return fmt.Sprintf("%s at %d is not supported", opcodeNames[this.opcode], this.pc)
+}
+
+// statementsOf decompiles a straight-line method body. It returns false
+// when the method uses an instruction the pass does not handle, or code
+// the pass cannot make sense of, so that it stays a listing.
+func (this *MethodInfo) statementsOf(ca *CodeAttribute, cp []CpInfo) (statements []statement, imports string, ok bool) {
+	defer func() {
+		// stack underflows and values used before they are initialized
+		if r := recover(); r != nil {
+			statements, imports, ok = nil, "", false
+		}
+	}()
+	if len(ca.exception_table) > 0 || this.owner == "" {
+		return nil, "", false
+	}
+	desc := this.Descriptor(cp)
+	params, ret, err := parseMethodDescriptor(desc)
+	if err != nil {
+		return nil, "", false
+	}
+	ins, err := decodeInstructions(ca.code)
+	if err != nil {
+		return nil, "", false
+	}
+	variables := localVariables(ca, cp)
+	context := func(hints map[int]string) *exprContext {
+		ctx := &exprContext{
+			cp:        cp,
+			set:       this.set,
+			owner:     this.owner,
+			method:    this.Name(cp),
+			static:    this.access_flags&ACC_STATIC != 0,
+			returns:   ret,
+			locals:    make(map[int]string),
+			types:     make(map[int]string),
+			variables: variables,
+			hints:     hints,
+			inline:    this.inline,
+		}
+		slot := 0
+		if !ctx.static {
+			ctx.locals[0] = "this"
+			ctx.types[0] = "L" + ctx.owner + ";"
+			slot = 1
+		}
+		hidden := this.outerParameter(params)
+		if hidden > 0 {
+			ctx.outerThis = ctx.className(this.outer) + ".this"
+		}
+		for i, p := range params {
+			ctx.locals[slot] = fmt.Sprintf("param%d", i-hidden)
+			if i < hidden {
+				ctx.locals[slot] = ctx.outerThis
+			}
+			ctx.types[slot] = p
+			slot++
+			if p == "J" || p == "D" {
+				slot++
+			}
+		}
+		ctx.firstLocal = slot
+		return ctx
+	}
+	ctx := context(make(map[int]string))
+	statements, err = ctx.run(ins)
+	if len(ctx.hints) > 0 {
+		// locals declared int are used as boolean, char, byte or short:
+		// declare them so
+		ctx = context(ctx.hints)
+		statements, err = ctx.run(ins)
+	}
+	if err != nil {
+		return nil, "", false
+	}
+	kept := statements[:0]
+	for _, s := range statements {
+		if s.text != "" {
+			kept = append(kept, s)
+		}
+	}
+	return kept, ctx.imports, true
+}
+
+// outerParameter returns 1 when the first of params is the outer instance
+// javac passes to the constructors of inner classes, 0 otherwise.
+func (this *MethodInfo) outerParameter(params []string) int {
+	if this.outer == "" || len(params) == 0 || params[0] != "L"+this.outer+";" {
+		return 0
+	}
+	return 1
+}
+
+func (this *exprContext) typeName(desc string) string {
+	t, imports, err := typeFromSignature(desc)
+	if err != nil && this.err == nil {
+		this.err = err
+	}
+	this.imports += imports
+	return strings.TrimSpace(t)
+}
+
+// className renders a class by its source name: Outer.Inner for member
+// classes and the simple name for local classes.
+func (this *exprContext) className(internal string) string {
+	if strings.HasPrefix(internal, "[") {
+		return this.typeName(internal)
+	}
+	nested := ""
+	// InnerClasses may be made up to loop
+	for depth := 0; depth < 16; depth++ {
+		d := this.set.Lookup(internal)
+		if d == nil || d.innerEntry() == nil || d.isAnonymous() {
+			break
+		}
+		if d.isLocal() {
+			return d.innerName() + nested
+		}
+		outer := d.outerName()
+		if outer == "" {
+			break
+		}
+		nested = "." + d.innerName() + nested
+		internal = outer
+	}
+	return this.typeName("L"+internal+";") + nested
+}
+
+func intConstant(v int32) expr {
+	return expr{text: javaInt(v), typ: "I", constant: &v}
+}
+
+func (this *exprContext) run(ins []Instruction) ([]statement, error) {
+	var stack []expr
+	var statements []statement
+	start := 0
+	pop := func() expr {
+		if len(stack) == 0 {
+			panic(fmt.Errorf("operand stack underflow"))
+		}
+		e := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		return e
+	}
+	// local class declarations that go before the next statement
+	var declarations []string
+	declared := make(map[string]bool)
+	long := false
+	push := func(e expr) {
+		// nested expressions copy their operands, keep that linear
+		long = long || len(e.text) > maxExpression
+		stack = append(stack, e)
+	}
+	emit := func(in Instruction, text string) error {
+		if len(stack) > 0 {
+			// a statement between the operands of an expression would run
+			// in a different order in source
+			return errUnsupported{in.pc, in.opcode}
+		}
+		for _, d := range declarations {
+			statements = append(statements, statement{start, start, d})
+		}
+		declarations = nil
+		statements = append(statements, statement{start, in.pc + in.Len(), text})
+		start = in.pc + in.Len()
+		return nil
+	}
+	for _, in := range ins {
+		if long {
+			return nil, errUnsupported{in.pc, in.opcode}
+		}
+		op := in.opcode
+		switch {
+		case op == 0x00: // nop
+		case op == 0x01:
+			push(expr{text: "null", typ: nullType})
+		case op >= 0x02 && op <= 0x08:
+			push(intConstant(int32(op) - 3))
+		case op == 0x09 || op == 0x0a:
+			push(expr{text: javaLong(int64(op - 0x09)), typ: "J"})
+		case op >= 0x0b && op <= 0x0d:
+			push(expr{text: javaFloat(float32(op - 0x0b)), typ: "F"})
+		case op == 0x0e || op == 0x0f:
+			push(expr{text: javaDouble(float64(op - 0x0e)), typ: "D"})
+		case op == 0x10:
+			push(intConstant(int32(int8(in.operands[0]))))
+		case op == 0x11:
+			push(intConstant(int32(int16(in.Index()))))
+		case op == 0x12, op == 0x13, op == 0x14:
+			index := in.Index()
+			if op == 0x12 {
+				index = uint16(in.operands[0])
+			}
+			e, ok := this.ldc(index)
+			if !ok {
+				return nil, errUnsupported{in.pc, op}
+			}
+			push(e)
+		case op >= 0x15 && op <= 0x19:
+			push(this.load(int(in.operands[0])))
+		case op >= 0x1a && op <= 0x2d:
+			push(this.load(int(op-0x1a) % 4))
+		case op >= 0x2e && op <= 0x35:
+			index := pop()
+			array := pop()
+			elem := "Ljava/lang/Object;"
+			if strings.HasPrefix(array.typ, "[") {
+				elem = array.typ[1:]
+			}
+			push(expr{text: array.operand() + "[" + index.text + "]", typ: elem})
+		case op >= 0x36 && op <= 0x3a:
+			text, ok := this.store(int(in.operands[0]), pop(), in.pc+in.Len())
+			if !ok {
+				return nil, errUnsupported{in.pc, op}
+			}
+			if err := emit(in, text); err != nil {
+				return nil, err
+			}
+		case op >= 0x3b && op <= 0x4e:
+			text, ok := this.store(int(op-0x3b)%4, pop(), in.pc+in.Len())
+			if !ok {
+				return nil, errUnsupported{in.pc, op}
+			}
+			if err := emit(in, text); err != nil {
+				return nil, err
+			}
+		case op >= 0x4f && op <= 0x56:
+			v := pop()
+			index := pop()
+			array := pop()
+			elem := "Ljava/lang/Object;"
+			if strings.HasPrefix(array.typ, "[") {
+				elem = array.typ[1:]
+			}
+			text := array.operand() + "[" + index.text + "] = " + this.coerce(v, elem, false).text + ";"
+			if err := emit(in, text); err != nil {
+				return nil, err
+			}
+		case op == 0x57: // pop
+			v := pop()
+			if !v.assignment && (!strings.HasSuffix(v.text, ")") || v.compound) {
+				return nil, errUnsupported{in.pc, op}
+			}
+			if err := emit(in, v.text+";"); err != nil {
+				return nil, err
+			}
+		case op == 0x59: // dup, only for new T(...)
+			if len(stack) == 0 || !stack[len(stack)-1].uninitialized {
+				return nil, errUnsupported{in.pc, op}
+			}
+			push(stack[len(stack)-1])
+		case op >= 0x60 && op <= 0x83:
+			if e, ok := arithmetic(op, pop); ok {
+				push(e)
+			} else {
+				return nil, errUnsupported{in.pc, op}
+			}
+		case op == 0x84: // iinc
+			name := this.local(int(in.operands[0]))
+			c := int8(in.operands[1])
+			text := name + " += " + javaInt(int32(c)) + ";"
+			if c == 1 {
+				text = name + "++;"
+			} else if c == -1 {
+				text = name + "--;"
+			}
+			if err := emit(in, text); err != nil {
+				return nil, err
+			}
+		case op >= 0x85 && op <= 0x93:
+			v := pop()
+			to := "JFDIFDIJDIJFBCS"[op-0x85 : op-0x85+1]
+			push(expr{text: "(" + this.typeName(to) + ") " + v.operand(), typ: to, compound: true})
+		case op >= 0xac && op <= 0xb0:
+			v := pop()
+			if err := emit(in, "return "+this.coerce(v, this.returns, false).text+";"); err != nil {
+				return nil, err
+			}
+		case op == 0xb1:
+			if err := emit(in, "return;"); err != nil {
+				return nil, err
+			}
+		case op >= 0xb2 && op <= 0xb5:
+			owner, name, desc, err := memberRefAt(this.cp, in.Index())
+			if err != nil {
+				return nil, err
+			}
+			switch op {
+			case 0xb2:
+				push(expr{text: this.className(owner) + "." + name, typ: desc})
+			case 0xb3:
+				v := pop()
+				if err := emit(in, this.className(owner)+"."+name+" = "+this.coerce(v, desc, false).text+";"); err != nil {
+					return nil, err
+				}
+			case 0xb4:
+				v := pop()
+				if v.text == "this" && owner == this.owner && strings.HasPrefix(desc, "L") && this.set.isOuterThis(owner, name, desc) {
+					push(expr{text: this.className(desc[1:len(desc)-1]) + ".this", typ: desc})
+					continue
+				}
+				push(expr{text: this.receiver(v, owner) + "." + name, typ: desc})
+			case 0xb5:
+				v := pop()
+				recv := this.receiver(pop(), owner)
+				if recv == "this" && this.outerThis != "" && v.text == this.outerThis && strings.HasPrefix(name, "this$") {
+					// javac stores the outer instance before the super call
+					if err := emit(in, ""); err != nil {
+						return nil, err
+					}
+					continue
+				}
+				if err := emit(in, recv+"."+name+" = "+this.coerce(v, desc, false).text+";"); err != nil {
+					return nil, err
+				}
+			}
+		case op >= 0xb6 && op <= 0xb9:
+			owner, name, desc, err := memberRefAt(this.cp, in.Index())
+			if err != nil {
+				return nil, err
+			}
+			params, ret, err := parseMethodDescriptor(desc)
+			if err != nil {
+				return nil, err
+			}
+			args := make([]expr, len(params))
+			for i := len(params) - 1; i >= 0; i-- {
+				args[i] = pop()
+			}
+			if name == "<init>" && this.set.hasOuterParameter(owner, params) {
+				// the outer instance is implied
+				desc = "(" + strings.Join(params[1:], "") + ")V"
+				outer := args[0]
+				args = args[1:]
+				if outer.text != "this" && !strings.HasSuffix(outer.text, ".this") {
+					return nil, errUnsupported{in.pc, op}
+				}
+			}
+			if e, ok := this.access(owner, name, desc, args); ok && op == 0xb8 {
+				if ret == "V" {
+					if err := emit(in, e.text+";"); err != nil {
+						return nil, err
+					}
+				} else {
+					push(e)
+				}
+				continue
+			}
+			argText := this.arguments(owner, name, desc, args)
+			var call string
+			switch {
+			case op == 0xb8:
+				call = this.className(owner) + "." + name + "(" + argText + ")"
+			case op == 0xb7 && name == "<init>":
+				target := pop()
+				switch {
+				case target.uninitialized:
+					created := expr{text: "new " + this.className(owner) + "(" + argText + ")", typ: "L" + owner + ";"}
+					if body := this.inline[owner]; body != "" && this.set.Lookup(owner).isAnonymous() {
+						// the class body stands for the constructor, which
+						// only passes on the outer instance
+						if len(args) > 0 {
+							return nil, errUnsupported{in.pc, op}
+						}
+						created.text = body
+					}
+					if len(stack) > 0 && stack[len(stack)-1].uninitialized && stack[len(stack)-1].text == target.text {
+						stack[len(stack)-1] = created
+						continue
+					}
+					call = created.text
+				case target.text == "this" && owner == this.owner:
+					call = "this(" + argText + ")"
+				case target.text == "this":
+					call = "super(" + argText + ")"
+				default:
+					return nil, errUnsupported{in.pc, op}
+				}
+			case op == 0xb7:
+				target := pop()
+				if target.text != "this" {
+					return nil, errUnsupported{in.pc, op}
+				}
+				if owner == this.owner {
+					call = "this." + name + "(" + argText + ")"
+				} else {
+					call = "super." + name + "(" + argText + ")"
+				}
+			default:
+				call = this.receiver(pop(), owner) + "." + name + "(" + argText + ")"
+			}
+			if ret == "V" {
+				if err := emit(in, call+";"); err != nil {
+					return nil, err
+				}
+			} else {
+				push(expr{text: call, typ: ret})
+			}
+		case op == 0xbb:
+			owner, err := classNameAt(this.cp, in.Index())
+			if err != nil {
+				return nil, err
+			}
+			if body := this.inline[owner]; body != "" && !declared[owner] && !this.set.Lookup(owner).isAnonymous() {
+				declarations = append(declarations, body)
+				declared[owner] = true
+			}
+			push(expr{text: fmt.Sprintf("new %s@%d", owner, in.pc), typ: "L" + owner + ";", uninitialized: true})
+		case op == 0xbc:
+			n := pop()
+			elem := newarrayTypes[in.operands[0]]
+			if elem == "" {
+				return nil, errUnsupported{in.pc, op}
+			}
+			push(expr{text: "new " + this.typeName(elem) + "[" + n.text + "]", typ: "[" + elem})
+		case op == 0xbd:
+			owner, err := classNameAt(this.cp, in.Index())
+			if err != nil {
+				return nil, err
+			}
+			n := pop()
+			elem := "L" + owner + ";"
+			if strings.HasPrefix(owner, "[") {
+				elem = owner
+			}
+			name := this.typeName(elem)
+			i := strings.Index(name, "[")
+			if i < 0 {
+				i = len(name)
+			}
+			push(expr{text: "new " + name[:i] + "[" + n.text + "]" + name[i:], typ: "[" + elem})
+		case op == 0xbe:
+			array := pop()
+			push(expr{text: array.operand() + ".length", typ: "I"})
+		case op == 0xbf:
+			v := pop()
+			if err := emit(in, "throw "+v.text+";"); err != nil {
+				return nil, err
+			}
+		case op == 0xc0:
+			owner, err := classNameAt(this.cp, in.Index())
+			if err != nil {
+				return nil, err
+			}
+			v := pop()
+			push(this.cast(v, internalToDescriptor(owner)))
+		case op == 0xc1:
+			owner, err := classNameAt(this.cp, in.Index())
+			if err != nil {
+				return nil, err
+			}
+			v := pop()
+			push(expr{text: v.operand() + " instanceof " + this.className(owner), typ: "Z", compound: true})
+		default:
+			return nil, errUnsupported{in.pc, op}
+		}
+	}
+	if len(stack) > 0 {
+		return nil, fmt.Errorf("values left on the operand stack")
+	}
+	return statements, this.err
+}
+
+var newarrayTypes = map[byte]string{4: "Z", 5: "C", 6: "F", 7: "D", 8: "B", 9: "S", 10: "I", 11: "J"}
+
+func internalToDescriptor(name string) string {
+	if strings.HasPrefix(name, "[") {
+		return name
+	}
+	return "L" + name + ";"
+}
+
+// arithmetic builds the binary and unary expressions of iadd to lxor.
+func arithmetic(op byte, pop func() expr) (expr, bool) {
+	types := "IJFD"
+	switch {
+	case op >= 0x60 && op <= 0x73:
+		symbol := []string{"+", "-", "*", "/", "%"}[(op-0x60)/4]
+		b, a := pop(), pop()
+		return expr{text: a.operand() + " " + symbol + " " + b.operand(), typ: types[(op-0x60)%4 : (op-0x60)%4+1], compound: true}, true
+	case op >= 0x74 && op <= 0x77:
+		a := pop()
+		return expr{text: "-" + a.operand(), typ: types[op-0x74 : op-0x74+1], compound: true}, true
+	case op >= 0x78 && op <= 0x7d:
+		symbol := []string{"<<", ">>", ">>>"}[(op-0x78)/2]
+		b, a := pop(), pop()
+		return expr{text: a.operand() + " " + symbol + " " + b.operand(), typ: types[(op-0x78)%2 : (op-0x78)%2+1], compound: true}, true
+	case op >= 0x7e && op <= 0x83:
+		symbol := []string{"&", "|", "^"}[(op-0x7e)/2]
+		b, a := pop(), pop()
+		return expr{text: a.operand() + " " + symbol + " " + b.operand(), typ: types[(op-0x7e)%2 : (op-0x7e)%2+1], compound: true}, true
+	}
+	return expr{}, false
+}
+
+func (this *exprContext) ldc(index uint16) (expr, bool) {
+	if index == 0 || int(index) > len(this.cp) {
+		return expr{}, false
+	}
+	switch this.cp[index-1].tag {
+	case CONSTANT_Integer:
+		i := CONSTANT_Integer_info{this.cp[index-1]}
+		return intConstant(int32(i.Values())), true
+	case CONSTANT_Float:
+		return expr{text: constantToString(this.cp, index), typ: "F", compound: true}, true
+	case CONSTANT_Long:
+		return expr{text: constantToString(this.cp, index), typ: "J"}, true
+	case CONSTANT_Double:
+		return expr{text: constantToString(this.cp, index), typ: "D", compound: true}, true
+	case CONSTANT_String:
+		return expr{text: constantToString(this.cp, index), typ: "Ljava/lang/String;"}, true
+	case CONSTANT_Class:
+		name, err := classNameAt(this.cp, index)
+		if err != nil {
+			return expr{}, false
+		}
+		return expr{text: this.className(name) + ".class", typ: "Ljava/lang/Class;"}, true
+	}
+	return expr{}, false
+}
+
+func (this *exprContext) local(slot int) string {
+	if name, ok := this.locals[slot]; ok {
+		return name
+	}
+	return fmt.Sprintf("local%d", slot)
+}
+
+func (this *exprContext) load(slot int) expr {
+	typ, ok := this.types[slot]
+	if !ok {
+		typ = "Ljava/lang/Object;"
+	}
+	return expr{text: this.local(slot), typ: typ, local: slot + 1}
+}
+
+// localVariables returns the LocalVariableTable entries of a method.
+func localVariables(ca *CodeAttribute, cp []CpInfo) []LocalVariableEntry {
+	attr := findAttribute(cp, ca.attributes, "LocalVariableTable")
+	if attr == nil {
+		return nil
+	}
+	lv, err := attr.ToLocalVariableTableAttribute()
+	if err != nil {
+		return nil
+	}
+	return lv.local_variable_table
+}
+
+// declaredType returns the type of the local the LocalVariableTable has in
+// slot at pc, or "".
+func (this *exprContext) declaredType(slot, pc int) string {
+	for _, v := range this.variables {
+		if int(v.index) == slot && int(v.start_pc) <= pc && pc < int(v.start_pc)+int(v.length) {
+			if desc, err := utf8At(this.cp, v.descriptor_index); err == nil && validFieldDescriptor(desc) {
+				return desc
+			}
+		}
+	}
+	return ""
+}
+
+// store assigns a local variable, declaring it on the first store. It
+// fails when javac reused the slot for a variable of another type. next is
+// the pc after the store, where the scope of a new local starts. javac
+// keeps boolean, char, byte and short values in int locals, so their
+// declared type comes from the LocalVariableTable or from their uses.
+func (this *exprContext) store(slot int, v expr, next int) (string, bool) {
+	if name, ok := this.locals[slot]; ok {
+		typ := this.types[slot]
+		if !this.storable(v, typ) {
+			return "", false
+		}
+		return name + " = " + this.coerce(v, typ, false).text + ";", true
+	}
+	typ := v.typ
+	if typ == nullType {
+		typ = "Ljava/lang/Object;"
+	}
+	if declared := this.declaredType(slot, next); declared != "" && this.storable(v, declared) {
+		typ = declared
+	} else if hint := this.hints[slot]; hint != "" && typ == "I" {
+		typ = hint
+	}
+	name := fmt.Sprintf("local%d", slot)
+	this.locals[slot] = name
+	this.types[slot] = typ
+	return this.typeName(typ) + " " + name + " = " + this.coerce(v, typ, false).text + ";", true
+}
+
+// storable reports whether v can be assigned to a local of type typ. Int
+// constants also stand for boolean, char, byte and short values.
+func (this *exprContext) storable(v expr, typ string) bool {
+	if v.constant != nil {
+		switch typ {
+		case "Z", "B", "C", "S", "I":
+			return true
+		}
+	}
+	return this.assignable(v.typ, typ)
+}
+
+// access renders a call of an access$NNN bridge, which javac generated
+// for private members before nest based access control, as the member
+// access it stands for.
+func (this *exprContext) access(owner, name, desc string, args []expr) (expr, bool) {
+	in, cp, ok := this.set.accessedMember(owner, name, desc)
+	if !ok {
+		return expr{}, false
+	}
+	mowner, mname, mdesc, err := memberRefAt(cp, in.Index())
+	if err != nil || mname == "<init>" {
+		return expr{}, false
+	}
+	switch in.opcode {
+	case 0xb2:
+		if len(args) == 0 {
+			return expr{text: this.className(mowner) + "." + mname, typ: mdesc}, true
+		}
+	case 0xb3:
+		if len(args) == 1 {
+			text := this.className(mowner) + "." + mname + " = " + this.coerce(args[0], mdesc, false).text
+			return expr{text: text, typ: mdesc, compound: true, assignment: true}, true
+		}
+	case 0xb4:
+		if len(args) == 1 {
+			return expr{text: this.receiver(args[0], mowner) + "." + mname, typ: mdesc}, true
+		}
+	case 0xb5:
+		if len(args) == 2 {
+			text := this.receiver(args[0], mowner) + "." + mname + " = " + this.coerce(args[1], mdesc, false).text
+			return expr{text: text, typ: mdesc, compound: true, assignment: true}, true
+		}
+	default:
+		params, ret, err := parseMethodDescriptor(mdesc)
+		if err != nil {
+			return expr{}, false
+		}
+		if in.opcode == 0xb8 && len(args) == len(params) {
+			return expr{text: this.className(mowner) + "." + mname + "(" + this.arguments(mowner, mname, mdesc, args) + ")", typ: ret}, true
+		}
+		// invokespecial of another class is a super call
+		if in.opcode != 0xb8 && len(args) == len(params)+1 && (in.opcode != 0xb7 || mowner == owner) {
+			return expr{text: this.receiver(args[0], mowner) + "." + mname + "(" + this.arguments(mowner, mname, mdesc, args[1:]) + ")", typ: ret}, true
+		}
+	}
+	return expr{}, false
+}
+
+// receiver renders the object a field or method is accessed on, with a
+// cast when its static type does not have the member.
+func (this *exprContext) receiver(v expr, owner string) string {
+	if v.uninitialized {
+		panic(fmt.Errorf("uninitialized receiver"))
+	}
+	if this.assignable(v.typ, internalToDescriptor(owner)) {
+		return v.operand()
+	}
+	return "(" + this.cast(v, internalToDescriptor(owner)).text + ")"
+}
+
+func (this *exprContext) cast(v expr, typ string) expr {
+	if v.typ == typ {
+		return v
+	}
+	if len(v.typ) == 1 && len(typ) == 1 && (v.typ == "Z") != (typ == "Z") && this.err == nil {
+		// no cast converts between boolean and the numeric types
+		this.err = fmt.Errorf("%s is %s, not %s", v.text, v.typ, typ)
+	}
+	return expr{text: "(" + this.typeName(typ) + ") " + v.operand(), typ: typ, compound: true}
+}
+
+// coerce renders v where a value of type typ is expected. Int constants
+// become boolean and char literals. In method arguments, unlike in
+// assignments, an int constant does not narrow to byte or short on its
+// own, so invocation adds a cast.
+func (this *exprContext) coerce(v expr, typ string, invocation bool) expr {
+	if v.local > this.firstLocal && v.typ == "I" && this.hints[v.local-1] == "" {
+		switch typ {
+		case "Z", "C", "B", "S":
+			this.hints[v.local-1] = typ
+		}
+	}
+	if v.constant != nil {
+		c := *v.constant
+		switch typ {
+		case "Z":
+			return expr{text: javaBoolean(c), typ: "Z"}
+		case "C":
+			return expr{text: javaChar(uint16(c)), typ: "C"}
+		case "B", "S":
+			if invocation {
+				return expr{text: "(" + this.typeName(typ) + ") " + v.text, typ: typ, compound: true}
+			}
+			return expr{text: v.text, typ: typ}
+		}
+	}
+	if !this.assignable(v.typ, typ) {
+		return this.cast(v, typ)
+	}
+	return v
+}
+
+// widening lists the primitive types each primitive type widens to.
+var widening = map[string]string{
+	"B": "SIJFD",
+	"S": "IJFD",
+	"C": "IJFD",
+	"I": "JFD",
+	"J": "FD",
+	"F": "D",
+}
+
+// assignable reports whether a value of type from converts to type to in
+// a method invocation without boxing. Classes that cannot be found are
+// assumed to be assignable, as javac would know them.
+func (this *exprContext) assignable(from, to string) bool {
+	return this.convertible(from, to, true)
+}
+
+// convertible is assignable with the answer for classes that cannot be
+// found given by assume.
+func (this *exprContext) convertible(from, to string, assume bool) bool {
+	switch {
+	case from == to:
+		return true
+	case from == nullType:
+		return to[0] == 'L' || to[0] == '['
+	case len(from) == 1 || len(to) == 1:
+		return len(from) == 1 && len(to) == 1 && strings.Contains(widening[from], to)
+	case to == "Ljava/lang/Object;":
+		return true
+	case from[0] == '[':
+		switch {
+		case to[0] == '[':
+			return len(from) > 2 && len(to) > 2 && from[1] != 'L' && from[1] != '[' ||
+				this.convertible(from[1:], to[1:], assume) && len(from[1:]) > 1 && len(to[1:]) > 1
+		default:
+			return to == "Ljava/lang/Cloneable;" || to == "Ljava/io/Serializable;"
+		}
+	case to[0] == '[':
+		return false
+	}
+	is, known := this.set.IsSubtype(from[1:len(from)-1], to[1:len(to)-1])
+	return is || !known && assume
+}
+
+// moreSpecific reports whether every parameter of a is assignable to the
+// same parameter of b, assuming assume for unknown classes.
+func (this *exprContext) moreSpecific(a, b []string, assume bool) bool {
+	for i := range a {
+		if !this.convertible(a[i], b[i], assume) {
+			return false
+		}
+	}
+	return true
+}
+
+// overloads returns the parameter lists of the other methods called name
+// with as many parameters: those the class path knows for owner and those
+// referenced from the constant pool.
+func (this *exprContext) overloads(owner, name, desc string, arity int) [][]string {
+	seen := map[string]bool{desc: true}
+	var candidates [][]string
+	add := func(d string) {
+		if seen[d] {
+			return
+		}
+		seen[d] = true
+		params, _, err := parseMethodDescriptor(d)
+		if err == nil && len(params) == arity {
+			candidates = append(candidates, params)
+		}
+	}
+	for _, m := range this.set.Methods(owner, name) {
+		add(m.Descriptor)
+	}
+	for i, c := range this.cp {
+		if c.tag != CONSTANT_Methodref && c.tag != CONSTANT_InterfaceMethodref {
+			continue
+		}
+		o, n, d, err := memberRefAt(this.cp, uint16(i+1))
+		if err == nil && n == name && (o == owner || this.assignable("L"+owner+";", "L"+o+";")) {
+			add(d)
+		}
+	}
+	return candidates
+}
+
+// arguments renders the arguments of a call so that javac picks the
+// method the bytecode calls: literals are adapted to the parameter types,
+// and arguments are cast to them when their static types do not fit or
+// another overload would be at least as specific.
+func (this *exprContext) arguments(owner, name, desc string, args []expr) string {
+	params, _, err := parseMethodDescriptor(desc)
+	if err != nil || len(params) != len(args) {
+		panic(fmt.Errorf("arguments of %s.%s%s", owner, name, desc))
+	}
+	coerced := make([]expr, len(args))
+	types := make([]string, len(args))
+	for i := range args {
+		coerced[i] = this.coerce(args[i], params[i], true)
+		types[i] = coerced[i].typ
+	}
+	ambiguous := false
+	for _, other := range this.overloads(owner, name, desc, len(args)) {
+		// when in doubt, the other method is taken to be applicable and
+		// not less specific, which only costs a cast
+		if this.moreSpecific(types, other, true) && !this.moreSpecific(params, other, false) {
+			ambiguous = true
+			break
+		}
+	}
+	texts := make([]string, len(args))
+	for i := range coerced {
+		if ambiguous && coerced[i].typ != params[i] {
+			coerced[i] = this.cast(coerced[i], params[i])
+		}
+		texts[i] = coerced[i].text
+	}
+	return strings.Join(texts, ", ")
+}
+
+// statementsToString lays out statements like opcodeTostring lays out
+// instructions. A statement carries the line of the first line start in
+// its code range.
+func statementsToString(statements []statement, lines *lineNumbers) string {
+	var str strings.Builder
+	align := lines != nil && lines.align
+	open := false
+	for _, s := range statements {
+		line, starts := 0, false
+		for pc := s.pc; pc < s.end && !starts; pc++ {
+			line, starts = lines.at(pc)
+		}
+		if align {
+			if starts || !open {
+				if open {
+					str.WriteString("\n")
+				}
+				str.WriteString("\t")
+				if starts {
+					fmt.Fprintf(&str, "/* %d */ ", line)
+				}
+				open = true
+			} else {
+				str.WriteString(" ")
+			}
+			str.WriteString(indent(s.text))
+		} else {
+			str.WriteString("\t")
+			if starts {
+				fmt.Fprintf(&str, "/* %d */ ", line)
+			}
+			str.WriteString(indent(s.text) + "\n")
+		}
+	}
+	if open {
+		str.WriteString("\n")
+	}
+	return str.String()
+}
@@ -0,0 +1,88 @@
+package decompiler
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestLocalTypes(t *testing.T) {
+	source, err := parseClass(t, assemble(t, `
+.version 52 0
+.class public super l/L
+.super java/lang/Object
+
+.method static declared ()I
+	.code stack 1 locals 1
+		iconst_1
+		istore_0
+		iconst_0
+		ireturn
+		.attribute LocalVariableTable { 0x0001 0x0002 0x0002 Utf8 "flag" Utf8 "Z" 0x0000 }
+	.end code
+.end method
+
+.method static narrow (I)Z
+	.code stack 1 locals 1
+		iload_0
+		ireturn
+	.end code
+.end method
+.end class
+`)).Source()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(source, "\t\tboolean local0 = true;\n\t\treturn 0;\n\t}\n") {
+		t.Errorf("the LocalVariableTable type is not used or the body is not indented:\n%s", source)
+	}
+	// an int is no boolean, not even with a cast
+	if strings.Contains(source, "(boolean)") || !strings.Contains(source, "// iload_0") {
+		t.Errorf("narrow is not left as a listing:\n%s", source)
+	}
+}
+
+// TestListingFallback checks that methods the expression pass cannot make
+// sense of keep their listing instead of becoming stubs or wrong casts.
+func TestListingFallback(t *testing.T) {
+	source, err := parseClass(t, assemble(t, `
+.version 52 0
+.class public super l/L
+.super java/lang/Object
+
+.method static reuse ()V
+	.code stack 1 locals 1
+		ldc String "s"
+		astore_0
+		iconst_5
+		istore_0
+		return
+	.end code
+.end method
+
+.method static underflow ()V
+	.code stack 1 locals 0
+		pop
+		return
+	.end code
+.end method
+
+.method static uninitialized ()V
+	.code stack 1 locals 0
+		new Class l/L
+		invokevirtual Method l/L hashCode ()I
+		pop
+		return
+	.end code
+.end method
+.end class
+`)).Source()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(source, "(String) 5") || !strings.Contains(source, "// istore_0") {
+		t.Errorf("an int stored into a String local is not left as a listing:\n%s", source)
+	}
+	if strings.Contains(source, "decompilation failed") || !strings.Contains(source, "// pop") || !strings.Contains(source, "// invokevirtual") {
+		t.Errorf("methods the pass cannot follow are not left as listings:\n%s", source)
+	}
+}
@@ -0,0 +1,94 @@
+package decompiler
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Filter selects classes by internal name, e.g. com/acme/Foo. Patterns are
+// globs in which * matches within a package, ** across packages and ?
+// matches one character; a pattern starting with "re:" is a regular
+// expression instead. Both must match the whole name. Nested classes are
+// selected with their top level class, so com/acme/Foo$Bar follows
+// com/acme/Foo.
+type Filter struct {
+	include []*regexp.Regexp
+	exclude []*regexp.Regexp
+}
+
+func NewFilter(include, exclude []string) (*Filter, error) {
+	f := &Filter{}
+	for _, p := range include {
+		re, err := filterPattern(p)
+		if err != nil {
+			return nil, err
+		}
+		f.include = append(f.include, re)
+	}
+	for _, p := range exclude {
+		re, err := filterPattern(p)
+		if err != nil {
+			return nil, err
+		}
+		f.exclude = append(f.exclude, re)
+	}
+	return f, nil
+}
+
+func filterPattern(pattern string) (*regexp.Regexp, error) {
+	if strings.HasPrefix(pattern, "re:") {
+		re, err := regexp.Compile("^(?:" + pattern[3:] + ")$")
+		if err != nil {
+			return nil, fmt.Errorf("filter %q: %w", pattern, err)
+		}
+		return re, nil
+	}
+	expr := "^"
+	for i := 0; i < len(pattern); i++ {
+		switch c := pattern[i]; {
+		case strings.HasPrefix(pattern[i:], "**/"):
+			// any number of packages, including none
+			expr += "(?:.*/)?"
+			i += 2
+		case strings.HasPrefix(pattern[i:], "**"):
+			expr += ".*"
+			i++
+		case c == '*':
+			expr += "[^/]*"
+		case c == '?':
+			expr += "[^/]"
+		default:
+			expr += regexp.QuoteMeta(string(c))
+		}
+	}
+	return regexp.Compile(expr + "$")
+}
+
+// Match reports whether the class is selected: it matches an include
+// pattern, or there are none, and no exclude pattern. A nil filter selects
+// every class.
+func (this *Filter) Match(name string) bool {
+	if this == nil {
+		return true
+	}
+	if i := strings.IndexByte(name, '$'); i > 0 {
+		name = name[:i]
+	}
+	included := len(this.include) == 0
+	for _, re := range this.include {
+		if re.MatchString(name) {
+			included = true
+			break
+		}
+	}
+	if !included {
+		return false
+	}
+	for _, re := range this.exclude {
+		if re.MatchString(name) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,283 @@
+package decompiler
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Malformed input must end in an error: the fuzz targets call the decoders
+// directly, so any panic fails them, and a panic recovered by Parse is
+// reported as well.
+
+// goldenClasses returns the classes of the golden corpus, the seed corpus
+// of the fuzz targets.
+func goldenClasses(t testing.TB) [][]byte {
+	var classes [][]byte
+	filepath.Walk(filepath.Join("testdata", "golden"), func(path string, info os.FileInfo, err error) error {
+		if err == nil && filepath.Ext(path) == ".class" {
+			if b, err := os.ReadFile(path); err == nil {
+				classes = append(classes, b)
+			}
+		}
+		return nil
+	})
+	if len(classes) == 0 {
+		t.Fatal("no classes in testdata/golden")
+	}
+	return classes
+}
+
+// decodeAttribute runs the decoder that belongs to the attribute name.
+func decodeAttribute(cp []CpInfo, name string, a *AttributeInfo) error {
+	var err error
+	switch name {
+	case "Code":
+		var ca CodeAttribute
+		ca, err = a.ToCodeAttribute()
+		if err == nil {
+			decodeCode(cp, &ca)
+		}
+	case "Record":
+		var ra RecordAttribute
+		ra, err = a.ToRecordAttribute()
+		for _, rc := range ra.components {
+			decodeAttributes(cp, rc.attributes)
+		}
+	case "BootstrapMethods":
+		_, err = a.ToBootstrapMethodsAttribute()
+	case "PermittedSubclasses":
+		_, err = a.ToPermittedSubclassesAttribute()
+	case "InnerClasses":
+		_, err = a.ToInnerClassesAttribute()
+	case "EnclosingMethod":
+		_, err = a.ToEnclosingMethodAttribute()
+	case "NestHost":
+		_, err = a.ToNestHostAttribute()
+	case "NestMembers":
+		_, err = a.ToNestMembersAttribute()
+	case "LineNumberTable":
+		_, err = a.ToLineNumberTableAttribute()
+	case "LocalVariableTable":
+		_, err = a.ToLocalVariableTableAttribute()
+	case "RuntimeVisibleAnnotations", "RuntimeInvisibleAnnotations":
+		_, err = a.ToRuntimeAnnotationsAttribute()
+	case "RuntimeVisibleParameterAnnotations", "RuntimeInvisibleParameterAnnotations":
+		_, err = a.ToParameterAnnotationsAttribute()
+	case "RuntimeVisibleTypeAnnotations", "RuntimeInvisibleTypeAnnotations":
+		_, err = a.ToTypeAnnotationsAttribute()
+	case "AnnotationDefault":
+		_, err = a.ToAnnotationDefaultAttribute()
+	case "Module":
+		_, err = a.ToModuleAttribute()
+	case "ModulePackages":
+		_, err = a.ToModulePackagesAttribute()
+	case "ModuleMainClass":
+		_, err = a.ToModuleMainClassAttribute()
+	}
+	return err
+}
+
+func decodeAttributes(cp []CpInfo, attrs []AttributeInfo) {
+	for i := range attrs {
+		name, _ := utf8At(cp, attrs[i].attribute_name_index)
+		decodeAttribute(cp, name, &attrs[i])
+	}
+}
+
+func decodeCode(cp []CpInfo, ca *CodeAttribute) {
+	decodeAttributes(cp, ca.attributes)
+	opcodeTostring(ca.code, cp, nil, lineNumbersOf(ca, cp, true))
+}
+
+func FuzzParse(f *testing.F) {
+	for _, b := range goldenClasses(f) {
+		f.Add(b)
+	}
+	f.Add([]byte{0xca, 0xfe, 0xba, 0xbe, 0, 0, 0, 52, 0, 0})
+	f.Fuzz(func(t *testing.T, data []byte) {
+		d := New("fuzz.class")
+		err := d.Parse(bytes.NewReader(data))
+		var p panicError
+		if errors.As(err, &p) {
+			t.Fatal(err)
+		}
+		if err != nil {
+			return
+		}
+		cp := d.class.constant_pool
+		decodeAttributes(cp, d.class.attributes)
+		for i := range d.class.fields {
+			decodeAttributes(cp, d.class.fields[i].attributes)
+		}
+		for i := range d.class.methods {
+			decodeAttributes(cp, d.class.methods[i].attributes)
+		}
+		text, err := d.Disassemble()
+		if errors.As(err, &p) {
+			t.Fatal(err)
+		}
+		if err == nil {
+			if _, err := Assemble("fuzz.j", strings.NewReader(text)); err != nil {
+				t.Fatalf("disassembly does not assemble: %v\n%s", err, text)
+			}
+		}
+		if _, err := d.Verify(); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := d.Dump(true); errors.As(err, &p) {
+			t.Fatal(err)
+		}
+		out, err := d.Class().Bytes()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.HasPrefix(data, out) {
+			t.Fatal("written class differs from the input")
+		}
+		if d.Class().Reindex() != nil {
+			return
+		}
+		out, err = d.Class().Bytes()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := New("reindexed.class").Parse(bytes.NewReader(out)); err != nil {
+			t.Fatalf("reindexed class does not parse: %v", err)
+		}
+	})
+}
+
+// FuzzSource renders classes as Java source in every line number mode,
+// through the member code and the expression pass FuzzParse does not
+// reach. Members replaced with stubs are fine, panics are not.
+func FuzzSource(f *testing.F) {
+	for _, b := range goldenClasses(f) {
+		f.Add(b)
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		for _, mode := range []LineNumberMode{LineNumbersOff, LineNumbersComment, LineNumbersAlign} {
+			set := NewClassSet()
+			if err := set.AddReader("fuzz.class", bytes.NewReader(data)); err != nil {
+				return
+			}
+			err := set.Write(io.Discard, Options{LineNumbers: mode})
+			var failed Errors
+			if !errors.As(err, &failed) {
+				continue
+			}
+			for _, e := range failed {
+				var p panicError
+				if errors.As(e, &p) {
+					t.Fatal(e)
+				}
+			}
+		}
+	})
+}
+
+// TestSourceErrors checks that members the source cannot be rendered for
+// become stubs with an error instead of a recovered panic.
+func TestSourceErrors(t *testing.T) {
+	b, err := os.ReadFile(filepath.Join("testdata", "golden", "straight", "classes", "straight", "Calc.class"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := parseClass(t, b)
+	d.class.methods[0].access_flags = ACC_ANNOTATION
+	d.class.methods[1].descriptor_index = 0
+	d.class.fields = append(d.class.fields, FieldInfo{name_index: 0xffff})
+	d.class.fields_count = uint16(len(d.class.fields))
+	if _, err := d.Source(); err != nil {
+		t.Fatal(err)
+	}
+	if len(d.Failures()) != 3 {
+		t.Errorf("failures %v, want three", d.Failures())
+	}
+	for _, e := range d.Failures() {
+		var p panicError
+		if errors.As(e, &p) {
+			t.Error(e)
+		}
+	}
+}
+
+func FuzzAttribute(f *testing.F) {
+	for _, name := range []string{"Code", "Record", "BootstrapMethods", "InnerClasses",
+		"RuntimeVisibleAnnotations", "RuntimeVisibleTypeAnnotations", "Module"} {
+		f.Add(name, []byte{0, 1, 0, 2, 0, 0, 0, 1, 0xb1, 0, 0, 0, 0})
+	}
+	f.Add("AnnotationDefault", bytes.Repeat([]byte{'['}, 300))
+	f.Fuzz(func(t *testing.T, name string, info []byte) {
+		a := AttributeInfo{
+			attribute_length: uint32(len(info)),
+			info:             info,
+		}
+		decodeAttribute(nil, name, &a)
+	})
+}
+
+func FuzzInstructions(f *testing.F) {
+	for _, b := range goldenClasses(f) {
+		d := New("seed.class")
+		if err := d.Parse(bytes.NewReader(b)); err != nil {
+			continue
+		}
+		for i := range d.class.methods {
+			if ca, err := d.class.methods[i].Code(d.class.constant_pool); err == nil && ca != nil {
+				f.Add(ca.code)
+			}
+		}
+	}
+	f.Add([]byte{0xaa, 0, 0, 0, 0, 0, 0, 0, 0x7f, 0xff, 0xff, 0xff, 0x80, 0, 0, 0})
+	f.Add([]byte{0xab, 0, 0, 0, 0, 0, 0, 0, 0x7f, 0xff, 0xff, 0xff})
+	f.Add([]byte{0xc4, 0x84, 0, 1, 0, 1})
+	f.Fuzz(func(t *testing.T, code []byte) {
+		ins, _ := decodeInstructions(code)
+		pc := 0
+		for _, in := range ins {
+			if in.pc != pc {
+				t.Fatalf("instruction at %d, expected %d", in.pc, pc)
+			}
+			pc += in.Len()
+		}
+		if pc > len(code) {
+			t.Fatalf("instructions end at %d after %d bytes", pc, len(code))
+		}
+		for _, in := range ins {
+			operandInfo(in, nil)
+		}
+		opcodeTostring(code, nil, nil, nil)
+		rawDisassembly(code)
+	})
+}
+
+func FuzzAssemble(f *testing.F) {
+	for _, b := range goldenClasses(f) {
+		d := New("seed.class")
+		if err := d.Parse(bytes.NewReader(b)); err != nil {
+			continue
+		}
+		if text, err := d.Disassemble(); err == nil {
+			f.Add(text)
+		}
+	}
+	f.Add(handWritten)
+	f.Fuzz(func(t *testing.T, text string) {
+		class, err := Assemble("fuzz.j", strings.NewReader(text))
+		if err != nil {
+			return
+		}
+		out, err := class.Bytes()
+		if err != nil {
+			return
+		}
+		if err := New("assembled.class").Parse(bytes.NewReader(out)); err != nil {
+			t.Fatalf("assembled class does not parse: %v", err)
+		}
+	})
+}
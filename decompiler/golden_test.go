@@ -0,0 +1,308 @@
+package decompiler
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// The golden corpus lives in testdata/golden, one directory per case:
+//
+//	src/            Java sources
+//	javac.txt       javac flags the classes are compiled with
+//	classes/        the compiled classes, in package layout
+//	compiler.txt    javac -version of the compiler that built classes/
+//	expected.txt    decompiled output of classes/
+//	recompile.txt   methods whose bytecode survives recompilation
+//
+// go test -javac -update compiles every case with the javac for its
+// release, records that compiler in compiler.txt and rewrites expected.txt
+// and recompile.txt. The javac for release N is $JAVAC_N, e.g. JAVAC_8, so
+// that each case can be built by its own JDK generation, or else javac
+// from PATH when it supports the release. Cases that were never built are
+// skipped.
+//
+// TestGolden compares the output with expected.txt. TestRecompile compiles
+// the output again and counts the methods whose instructions match the
+// original ones; any javac error, a count below the recorded one and a
+// case without recompile.txt are failures.
+//
+// The classes of the cases whose compiler.txt says so were assembled by
+// hand, not by javac; -javac -update replaces them.
+
+var (
+	update  = flag.Bool("update", false, "rewrite expected.txt and recompile.txt in testdata/golden")
+	rebuild = flag.Bool("javac", false, "compile the classes of testdata/golden from their sources first")
+)
+
+func goldenCases(t *testing.T) []string {
+	dirs, err := filepath.Glob(filepath.Join("testdata", "golden", "*", "javac.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var cases []string
+	for _, d := range dirs {
+		cases = append(cases, filepath.Dir(d))
+	}
+	sort.Strings(cases)
+	return cases
+}
+
+func javacFlags(t *testing.T, dir string) []string {
+	b, err := os.ReadFile(filepath.Join(dir, "javac.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strings.Fields(string(b))
+}
+
+func javaFiles(t *testing.T, dir string) []string {
+	var files []string
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err == nil && strings.HasSuffix(path, ".java") {
+			files = append(files, path)
+		}
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return files
+}
+
+// caseRelease returns the release the javac flags of a case compile for.
+func caseRelease(t *testing.T, flags []string) int {
+	for i, f := range flags {
+		if (f == "--release" || f == "-source" || f == "--source") && i+1 < len(flags) {
+			if n, err := strconv.Atoi(strings.TrimPrefix(flags[i+1], "1.")); err == nil {
+				return n
+			}
+		}
+	}
+	t.Fatalf("no --release in %v", flags)
+	return 0
+}
+
+// javacFor returns the javac that compiles for release, $JAVAC_<release>
+// or javac from PATH when it is recent enough, and skips the test when
+// there is none.
+func javacFor(t *testing.T, release int) string {
+	if path := os.Getenv(fmt.Sprintf("JAVAC_%d", release)); path != "" {
+		return path
+	}
+	path, err := exec.LookPath("javac")
+	if err != nil {
+		t.Skip("javac not found")
+	}
+	if v := javacVersion(t, path); v < release {
+		t.Skipf("javac %d does not compile for release %d, set JAVAC_%d", v, release, release)
+	}
+	return path
+}
+
+// javacVersion returns the feature release of a javac: 8 for
+// "javac 1.8.0_392", 21 for "javac 21.0.1".
+func javacVersion(t *testing.T, path string) int {
+	out, err := exec.Command(path, "-version").CombinedOutput()
+	if err != nil {
+		t.Fatalf("%s -version: %v", path, err)
+	}
+	if fields := strings.Fields(string(out)); len(fields) >= 2 {
+		v := strings.TrimPrefix(fields[1], "1.")
+		if i := strings.IndexAny(v, ".-+_"); i >= 0 {
+			v = v[:i]
+		}
+		if n, err := strconv.Atoi(v); err == nil {
+			return n
+		}
+	}
+	t.Fatalf("%s -version: %q", path, out)
+	return 0
+}
+
+// javac compiles the sources under src to out and returns the compiler
+// output when it fails.
+func javac(t *testing.T, path string, flags []string, src, out string) (string, bool) {
+	args := append(append([]string{}, flags...), "-d", out)
+	args = append(args, javaFiles(t, src)...)
+	text, err := exec.Command(path, args...).CombinedOutput()
+	return string(text), err == nil
+}
+
+// built holds the cases -javac compiled in this run.
+var built = make(map[string]bool)
+
+// caseClasses returns the classes of a case, compiled from its sources
+// first with -javac. It skips cases that were never built.
+func caseClasses(t *testing.T, dir string) string {
+	classes := filepath.Join(dir, "classes")
+	if *rebuild && !built[dir] {
+		flags := javacFlags(t, dir)
+		path := javacFor(t, caseRelease(t, flags))
+		if err := os.RemoveAll(classes); err != nil {
+			t.Fatal(err)
+		}
+		if text, ok := javac(t, path, flags, filepath.Join(dir, "src"), classes); !ok {
+			t.Fatalf("javac failed:\n%s", text)
+		}
+		version, err := exec.Command(path, "-version").CombinedOutput()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, "compiler.txt"), version, 0644); err != nil {
+			t.Fatal(err)
+		}
+		built[dir] = true
+	}
+	if _, err := os.Stat(classes); err != nil {
+		t.Skipf("%s was never built, run go test -javac -update with javac installed", dir)
+	}
+	return classes
+}
+
+func decompileDir(t *testing.T, dir string) string {
+	set := NewClassSet()
+	if err := set.AddDir(dir); err != nil {
+		t.Fatal(err)
+	}
+	var b bytes.Buffer
+	if err := set.Write(&b, Options{}); err != nil {
+		t.Fatal(err)
+	}
+	return b.String()
+}
+
+func TestGolden(t *testing.T) {
+	for _, dir := range goldenCases(t) {
+		dir := dir
+		t.Run(filepath.Base(dir), func(t *testing.T) {
+			got := decompileDir(t, caseClasses(t, dir))
+			expected := filepath.Join(dir, "expected.txt")
+			if *update {
+				if err := os.WriteFile(expected, []byte(got), 0644); err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			want, err := os.ReadFile(expected)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != string(want) {
+				t.Errorf("output differs from %s at %s", expected, firstDifference(got, string(want)))
+			}
+		})
+	}
+}
+
+func firstDifference(got, want string) string {
+	g := strings.Split(got, "\n")
+	w := strings.Split(want, "\n")
+	for i := 0; i < len(g) || i < len(w); i++ {
+		var a, b string
+		if i < len(g) {
+			a = g[i]
+		}
+		if i < len(w) {
+			b = w[i]
+		}
+		if a != b {
+			return fmt.Sprintf("line %d:\n\tgot:  %q\n\twant: %q", i+1, a, b)
+		}
+	}
+	return "end of output"
+}
+
+// shapes maps "class.name descriptor" to the opcode names of every method
+// of the classes under dir. Operands are left out as constant pool
+// indices differ between compilations.
+func shapes(t *testing.T, dir string) map[string]string {
+	m := make(map[string]string)
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || !strings.HasSuffix(path, ".class") {
+			return err
+		}
+		d := New(path)
+		if err := d.ParseFile(); err != nil {
+			return err
+		}
+		name, err := d.Name()
+		if err != nil {
+			return err
+		}
+		cp := d.class.constant_pool
+		for _, method := range d.class.methods {
+			var ops []string
+			if ca, _ := method.Code(cp); ca != nil {
+				ins, _ := decodeInstructions(ca.code)
+				for _, in := range ins {
+					ops = append(ops, strings.TrimSuffix(in.Name(), "_w"))
+				}
+			}
+			m[name+"."+method.Name(cp)+method.Descriptor(cp)] = strings.Join(ops, " ")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+func TestRecompile(t *testing.T) {
+	for _, dir := range goldenCases(t) {
+		dir := dir
+		t.Run(filepath.Base(dir), func(t *testing.T) {
+			classes := caseClasses(t, dir)
+			flags := javacFlags(t, dir)
+			path := javacFor(t, caseRelease(t, flags))
+			tmp := t.TempDir()
+			set := NewClassSet()
+			if err := set.AddDir(classes); err != nil {
+				t.Fatal(err)
+			}
+			if err := set.WriteDir(filepath.Join(tmp, "src"), Options{}); err != nil {
+				t.Fatal(err)
+			}
+			if text, ok := javac(t, path, flags, filepath.Join(tmp, "src"), filepath.Join(tmp, "classes")); !ok {
+				t.Fatalf("javac rejects the output:\n%s", text)
+			}
+			original := shapes(t, classes)
+			recompiled := shapes(t, filepath.Join(tmp, "classes"))
+			matched := 0
+			for method, ops := range original {
+				if recompiled[method] == ops {
+					matched++
+				} else {
+					t.Logf("%s differs", method)
+				}
+			}
+			t.Logf("%d of %d methods recompile to the same instructions", matched, len(original))
+			baseline := filepath.Join(dir, "recompile.txt")
+			if *update {
+				if err := os.WriteFile(baseline, []byte(strconv.Itoa(matched)+"\n"), 0644); err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			b, err := os.ReadFile(baseline)
+			if err != nil {
+				t.Fatalf("%v; record the baseline with go test -run TestRecompile -javac -update", err)
+			}
+			want, err := strconv.Atoi(strings.TrimSpace(string(b)))
+			if err != nil {
+				t.Fatalf("%s: %v", baseline, err)
+			}
+			if matched < want {
+				t.Errorf("%d methods recompile to the same instructions, %d before", matched, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,104 @@
+package decompiler
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+var classFlagNames = []struct {
+	flag AccessFlags
+	name string
+}{
+	{ACC_PUBLIC, "public"},
+	{ACC_FINAL, "final"},
+	{ACC_SUPER, "super"},
+	{ACC_INTERFACE, "interface"},
+	{ACC_ABSTRACT, "abstract"},
+	{ACC_SYNTHETIC, "synthetic"},
+	{ACC_ANNOTATION, "annotation"},
+	{ACC_ENUM, "enum"},
+	{ACC_MODULE, "module"},
+}
+
+// Info describes the class file itself rather than the source it was
+// compiled from: version, flags, super types, member counts and the names
+// of the class attributes.
+func (this *decompiler) Info() (string, error) {
+	cp := this.class.constant_pool
+	name, err := this.Name()
+	if err != nil {
+		return "", err
+	}
+	text := "class: " + name + "\n"
+	text += "version: " + this.class.Release().String() + "\n"
+	var flags []string
+	for _, f := range classFlagNames {
+		if this.class.access_flags&f.flag != 0 {
+			flags = append(flags, f.name)
+		}
+	}
+	text += fmt.Sprintf("flags: %#04x %s\n", uint16(this.class.access_flags), strings.Join(flags, " "))
+	if this.class.super_class != 0 {
+		super, err := classNameAt(cp, this.class.super_class)
+		if err != nil {
+			return "", err
+		}
+		text += "super: " + super + "\n"
+	}
+	for _, i := range this.class.interfaces {
+		iface, err := classNameAt(cp, i)
+		if err != nil {
+			return "", err
+		}
+		text += "interface: " + iface + "\n"
+	}
+	if attr := findAttribute(cp, this.class.attributes, "SourceFile"); attr != nil && len(attr.info) >= 2 {
+		if source, err := utf8At(cp, uint16(attr.info[0])<<8|uint16(attr.info[1])); err == nil {
+			text += "source: " + source + "\n"
+		}
+	}
+	text += fmt.Sprintf("constant pool: %d entries\n", len(cp))
+	text += fmt.Sprintf("fields: %d\n", len(this.class.fields))
+	text += fmt.Sprintf("methods: %d\n", len(this.class.methods))
+	var attrs []string
+	for _, attr := range this.class.attributes {
+		n, _ := utf8At(cp, attr.attribute_name_index)
+		attrs = append(attrs, n)
+	}
+	text += "attributes: " + strings.Join(attrs, ", ") + "\n"
+	return text, nil
+}
+
+// Search returns what in the class matches re, one "kind: text" line per
+// match: the class name, field and method names with their descriptors,
+// and string constants.
+func (this *decompiler) Search(re *regexp.Regexp) []string {
+	cp := this.class.constant_pool
+	var matches []string
+	if name, err := this.Name(); err == nil && re.MatchString(name) {
+		matches = append(matches, "class: "+name)
+	}
+	for i := range this.class.fields {
+		member := this.class.fields[i].Name(cp) + " " + this.class.fields[i].Descriptor(cp)
+		if re.MatchString(member) {
+			matches = append(matches, "field: "+member)
+		}
+	}
+	for i := range this.class.methods {
+		member := this.class.methods[i].Name(cp) + this.class.methods[i].Descriptor(cp)
+		if re.MatchString(member) {
+			matches = append(matches, "method: "+member)
+		}
+	}
+	for i, c := range cp {
+		if c.tag != CONSTANT_String {
+			continue
+		}
+		s := CONSTANT_String_info{c}
+		if v, err := utf8At(cp, s.StringIndex()); err == nil && re.MatchString(v) {
+			matches = append(matches, fmt.Sprintf("string #%d: %s", i+1, javaString(v)))
+		}
+	}
+	return matches
+}
@@ -0,0 +1,384 @@
+package decompiler
+
+import (
+	"strings"
+)
+
+// innerEntry returns the InnerClasses entry that describes this class
+// itself, or nil for top level classes.
+func (this *decompiler) innerEntry() *InnerClassEntry {
+	cp := this.class.constant_pool
+	attr := findAttribute(cp, this.class.attributes, "InnerClasses")
+	if attr == nil {
+		return nil
+	}
+	ia, err := attr.ToInnerClassesAttribute()
+	if err != nil {
+		return nil
+	}
+	for i := range ia.classes {
+		if ia.classes[i].inner_class_info_index == this.class.this_class {
+			return &ia.classes[i]
+		}
+	}
+	return nil
+}
+
+func (this *decompiler) isAnonymous() bool {
+	e := this.innerEntry()
+	return e != nil && e.inner_name_index == 0
+}
+
+func (this *decompiler) isLocal() bool {
+	e := this.innerEntry()
+	return e != nil && e.inner_name_index != 0 && e.outer_class_info_index == 0
+}
+
+// innerName returns the simple source name, "Inner" for Outer$Inner.
+func (this *decompiler) innerName() string {
+	if e := this.innerEntry(); e != nil && e.inner_name_index != 0 {
+		if name, err := utf8At(this.class.constant_pool, e.inner_name_index); err == nil {
+			return name
+		}
+	}
+	name, _ := this.Name()
+	return simpleName(name)
+}
+
+// outerName returns the internal name of the class this one is declared
+// in, using InnerClasses, then EnclosingMethod, then NestHost.
+func (this *decompiler) outerName() string {
+	cp := this.class.constant_pool
+	if e := this.innerEntry(); e != nil && e.outer_class_info_index != 0 {
+		name, _ := classNameAt(cp, e.outer_class_info_index)
+		return name
+	}
+	if attr := findAttribute(cp, this.class.attributes, "EnclosingMethod"); attr != nil {
+		em, _ := attr.ToEnclosingMethodAttribute()
+		name, _ := classNameAt(cp, em.class_index)
+		return name
+	}
+	if attr := findAttribute(cp, this.class.attributes, "NestHost"); attr != nil {
+		nh, _ := attr.ToNestHostAttribute()
+		host, _ := classNameAt(cp, nh.host_class_index)
+		if d := this.set.member(host); d != nil && d.isNestMember(this) {
+			return host
+		}
+	}
+	return ""
+}
+
+func (this *decompiler) isNestMember(member *decompiler) bool {
+	cp := this.class.constant_pool
+	attr := findAttribute(cp, this.class.attributes, "NestMembers")
+	if attr == nil {
+		return false
+	}
+	name, err := member.Name()
+	if err != nil {
+		return false
+	}
+	na, err := attr.ToNestMembersAttribute()
+	if err != nil {
+		return false
+	}
+	for _, c := range na.classes {
+		if n, _ := classNameAt(cp, c); n == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Outer returns the class of the same ClassSet this class is written into:
+// the declaring class of a member class or the class around a local or
+// anonymous class. It is nil for top level classes and for nested classes
+// whose outer class is not part of the set; those are written on their own.
+func (this *decompiler) Outer() *decompiler {
+	name := this.outerName()
+	if name == "" {
+		return nil
+	}
+	return this.set.member(name)
+}
+
+// memberClasses returns the member classes of this class found in the set,
+// in InnerClasses order.
+func (this *decompiler) memberClasses() []*decompiler {
+	cp := this.class.constant_pool
+	attr := findAttribute(cp, this.class.attributes, "InnerClasses")
+	if attr == nil {
+		return nil
+	}
+	ia, err := attr.ToInnerClassesAttribute()
+	if err != nil {
+		return nil
+	}
+	var members []*decompiler
+	for _, e := range ia.classes {
+		if e.outer_class_info_index != this.class.this_class || e.inner_name_index == 0 {
+			continue
+		}
+		name, err := classNameAt(cp, e.inner_class_info_index)
+		if err != nil {
+			continue
+		}
+		if d := this.set.member(name); d != nil && d.Outer() == this {
+			members = append(members, d)
+		}
+	}
+	return members
+}
+
+// localClasses returns the local and anonymous classes declared in the
+// methods of this class.
+func (this *decompiler) localClasses() []*decompiler {
+	name, err := this.Name()
+	if err != nil {
+		return nil
+	}
+	var locals []*decompiler
+	for _, d := range this.set.nestedIn(name) {
+		if d.isAnonymous() || d.isLocal() {
+			locals = append(locals, d)
+		}
+	}
+	return locals
+}
+
+// placeLocalClasses assigns the source of local and anonymous classes to
+// the methods they are declared in. A class goes with the first "new" that
+// creates it, or at the top of its EnclosingMethod when it is never
+// instantiated there. The returned classes could not be placed.
+func (this *decompiler) placeLocalClasses(methods []MethodInfo) ([]*decompiler, string, error) {
+	cp := this.class.constant_pool
+	var rest []*decompiler
+	imports := ""
+	for _, local := range this.localClasses() {
+		name, _ := local.Name()
+		local.options = this.options
+		text, imp, err := local.classCode()
+		if err != nil {
+			return nil, "", err
+		}
+		this.failures = append(this.failures, local.failures...)
+		imports += imp
+		placed := false
+		for i := range methods {
+			ca, _ := methods[i].Code(cp)
+			if ca == nil {
+				continue
+			}
+			ins, _ := decodeInstructions(ca.code)
+			for _, in := range ins {
+				if in.opcode != 0xbb {
+					continue
+				}
+				if n, _ := classNameAt(cp, in.Index()); n == name {
+					if methods[i].inline == nil {
+						methods[i].inline = make(map[string]string)
+					}
+					methods[i].inline[name] = text
+					placed = true
+					break
+				}
+			}
+			if placed {
+				break
+			}
+		}
+		if !placed {
+			if i := local.enclosingMethodIn(this, methods); i >= 0 {
+				methods[i].prelude += "\t" + indent(text) + "\n"
+				placed = true
+			}
+		}
+		if !placed {
+			rest = append(rest, local)
+		}
+	}
+	return rest, imports, nil
+}
+
+// enclosingMethodIn finds the method named by the EnclosingMethod
+// attribute among the methods of outer.
+func (this *decompiler) enclosingMethodIn(outer *decompiler, methods []MethodInfo) int {
+	cp := this.class.constant_pool
+	attr := findAttribute(cp, this.class.attributes, "EnclosingMethod")
+	if attr == nil {
+		return -1
+	}
+	em, err := attr.ToEnclosingMethodAttribute()
+	if err != nil || em.method_index == 0 {
+		return -1
+	}
+	name, desc, err := nameAndTypeAt(cp, em.method_index)
+	if err != nil {
+		return -1
+	}
+	ocp := outer.class.constant_pool
+	for i := range methods {
+		if methods[i].Name(ocp) == name && methods[i].Descriptor(ocp) == desc {
+			return i
+		}
+	}
+	return -1
+}
+
+// anonymousHeader renders "new Runnable()" from the single interface or
+// the super class of an anonymous class.
+func (this *decompiler) anonymousHeader() (string, string) {
+	cp := this.class.constant_pool
+	index := this.class.super_class
+	if len(this.class.interfaces) == 1 {
+		if super, _ := classNameAt(cp, index); super == "java/lang/Object" {
+			index = this.class.interfaces[0]
+		}
+	}
+	name, err := classNameAt(cp, index)
+	if err != nil {
+		return "new Object()", ""
+	}
+	return "new " + simpleName(strings.ReplaceAll(name, "$", "/")) + "()", this.addImport(name)
+}
+
+// isSyntheticOuterField reports the this$0 and val$x fields javac adds to
+// inner and local classes.
+func isSyntheticOuterField(field FieldInfo, cp []CpInfo) bool {
+	if field.access_flags&ACC_SYNTHETIC == 0 {
+		return false
+	}
+	name := field.Name(cp)
+	return strings.HasPrefix(name, "this$") || strings.HasPrefix(name, "val$")
+}
+
+// isSyntheticAccessor reports the static access$NNN bridges javac
+// generated before nest based access control. A method named like one that
+// is not synthetic, or that does more than forward to a member, is kept.
+func isSyntheticAccessor(method MethodInfo, cp []CpInfo) bool {
+	_, ok := accessorTarget(method, cp)
+	return ok
+}
+
+// accessorTarget returns the field or method instruction an access$NNN
+// bridge wraps, when the bridge passes its parameters on to it unchanged:
+// a read, a write or a call.
+func accessorTarget(method MethodInfo, cp []CpInfo) (Instruction, bool) {
+	if method.access_flags&(ACC_SYNTHETIC|ACC_STATIC) != ACC_SYNTHETIC|ACC_STATIC {
+		return Instruction{}, false
+	}
+	if !strings.HasPrefix(method.Name(cp), "access$") {
+		return Instruction{}, false
+	}
+	params, _, err := parseMethodDescriptor(method.Descriptor(cp))
+	ca, _ := method.Code(cp)
+	if err != nil || ca == nil {
+		return Instruction{}, false
+	}
+	ins, err := decodeInstructions(ca.code)
+	if err != nil || len(ins) < len(params)+2 {
+		return Instruction{}, false
+	}
+	slot := 0
+	for i, p := range params {
+		if s, kind, ok := loadOf(ins[i]); !ok || s != slot || kind != loadKindOf(p) {
+			return Instruction{}, false
+		}
+		slot += slots([]string{p})
+	}
+	rest := ins[len(params):]
+	if len(rest) == 3 && (rest[1].opcode == 0xb3 || rest[1].opcode == 0xb5) {
+		// dup, dup2, dup_x1 or dup2_x1 of the value a setter returns
+		switch rest[0].opcode {
+		case 0x59, 0x5a, 0x5c, 0x5d:
+			rest = rest[1:]
+		}
+	}
+	if len(rest) != 2 || rest[1].opcode < 0xac || rest[1].opcode > 0xb1 || rest[0].opcode < 0xb2 || rest[0].opcode > 0xb9 {
+		return Instruction{}, false
+	}
+	return rest[0], true
+}
+
+// indent indents all lines of text but the first by one tab, leaving
+// empty lines empty.
+func indent(text string) string {
+	lines := strings.Split(text, "\n")
+	for i := 1; i < len(lines); i++ {
+		if lines[i] != "" {
+			lines[i] = "\t" + lines[i]
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// separated returns the empty line that goes before a method or nested
+// class, unless it is the first member of the class body text.
+func separated(text string) string {
+	if strings.HasSuffix(text, "{\n") {
+		return ""
+	}
+	return "\n"
+}
+
+// member renders a field, method or nested class inside its class body.
+func member(code string) string {
+	return "\t" + indent(strings.TrimSuffix(code, "\n")) + "\n"
+}
+
+// outerInstance returns the internal name of the class whose instance javac
+// passes to the constructors of this inner class, or "" for top level and
+// static nested classes.
+func (this *decompiler) outerInstance() string {
+	e := this.innerEntry()
+	if e == nil || e.inner_class_access_flags&ACC_STATIC != 0 || this.class.access_flags&ACC_INTERFACE != 0 {
+		return ""
+	}
+	return this.outerName()
+}
+
+// hasOuterParameter reports whether a constructor of class owner with the
+// given parameters takes the outer instance first.
+func (this *ClassSet) hasOuterParameter(owner string, params []string) bool {
+	d := this.Lookup(owner)
+	if d == nil || len(params) == 0 {
+		return false
+	}
+	outer := d.outerInstance()
+	return outer != "" && params[0] == "L"+outer+";"
+}
+
+// isOuterThis reports whether field name of class owner is the this$N
+// field holding the outer instance.
+func (this *ClassSet) isOuterThis(owner, name, desc string) bool {
+	d := this.Lookup(owner)
+	if d == nil || !strings.HasPrefix(name, "this$") {
+		return false
+	}
+	cp := d.class.constant_pool
+	for _, f := range d.class.fields {
+		if f.Name(cp) == name && f.Descriptor(cp) == desc {
+			return isSyntheticOuterField(f, cp)
+		}
+	}
+	return false
+}
+
+// accessedMember decodes the access$NNN bridge name desc of class owner.
+// It returns the instruction the bridge wraps and the constant pool it
+// indexes.
+func (this *ClassSet) accessedMember(owner, name, desc string) (Instruction, []CpInfo, bool) {
+	d := this.Lookup(owner)
+	if d == nil || !strings.HasPrefix(name, "access$") {
+		return Instruction{}, nil, false
+	}
+	cp := d.class.constant_pool
+	for _, m := range d.class.methods {
+		if m.Name(cp) != name || m.Descriptor(cp) != desc {
+			continue
+		}
+		in, ok := accessorTarget(m, cp)
+		return in, cp, ok
+	}
+	return Instruction{}, nil, false
+}
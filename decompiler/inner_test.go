@@ -0,0 +1,261 @@
+package decompiler
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+const outerWithAccessors = `
+.version 52 0
+.class public super o/Outer
+.super java/lang/Object
+.attribute InnerClasses { 0x0001 Class o/Outer$Inner Class o/Outer Utf8 "Inner" 0x0002 }
+.field private secret I
+
+.method public <init> ()V
+	.code stack 1 locals 1
+		aload_0
+		invokespecial Method java/lang/Object <init> ()V
+		return
+	.end code
+.end method
+
+.method private hide (I)V
+	.code stack 0 locals 2
+		return
+	.end code
+.end method
+
+.method public make ()Ljava/lang/Object;
+	.code stack 3 locals 1
+		new Class o/Outer$Inner
+		dup
+		aload_0
+		invokespecial Method o/Outer$Inner <init> (Lo/Outer;)V
+		areturn
+	.end code
+.end method
+
+.method static synthetic access$000 (Lo/Outer;)I
+	.code stack 1 locals 1
+		aload_0
+		getfield Field o/Outer secret I
+		ireturn
+	.end code
+.end method
+
+.method static synthetic access$002 (Lo/Outer;I)I
+	.code stack 3 locals 2
+		aload_0
+		iload_1
+		dup_x1
+		putfield Field o/Outer secret I
+		ireturn
+	.end code
+.end method
+
+.method static synthetic access$100 (Lo/Outer;I)V
+	.code stack 2 locals 2
+		aload_0
+		iload_1
+		invokespecial Method o/Outer hide (I)V
+		return
+	.end code
+.end method
+
+.method static access$foo ()I
+	.code stack 1 locals 0
+		iconst_1
+		ireturn
+	.end code
+.end method
+
+.method static synthetic access$200 (I)I
+	.code stack 2 locals 1
+		iload_0
+		iconst_1
+		iadd
+		ireturn
+	.end code
+.end method
+.end class
+`
+
+const innerWithAccessors = `
+.version 52 0
+.class super o/Outer$Inner
+.super java/lang/Object
+.attribute InnerClasses { 0x0001 Class o/Outer$Inner Class o/Outer Utf8 "Inner" 0x0002 }
+.field final synthetic this$0 Lo/Outer;
+
+.method <init> (Lo/Outer;)V
+	.code stack 2 locals 2
+		aload_0
+		aload_1
+		putfield Field o/Outer$Inner this$0 Lo/Outer;
+		aload_0
+		invokespecial Method java/lang/Object <init> ()V
+		return
+	.end code
+.end method
+
+.method set ()I
+	.code stack 2 locals 1
+		aload_0
+		getfield Field o/Outer$Inner this$0 Lo/Outer;
+		iconst_5
+		invokestatic Method o/Outer access$002 (Lo/Outer;I)I
+		pop
+		aload_0
+		getfield Field o/Outer$Inner this$0 Lo/Outer;
+		iconst_1
+		invokestatic Method o/Outer access$100 (Lo/Outer;I)V
+		aload_0
+		getfield Field o/Outer$Inner this$0 Lo/Outer;
+		invokestatic Method o/Outer access$000 (Lo/Outer;)I
+		ireturn
+	.end code
+.end method
+.end class
+`
+
+// TestInnerAccessors decompiles an inner class of a class compiled before
+// nest mates, with access$NNN bridges for a field and a private method.
+// Methods named access$ that are not synthetic or do not forward to a
+// member stay in the output.
+func TestInnerAccessors(t *testing.T) {
+	set := NewClassSet()
+	for name, text := range map[string]string{"Outer.class": outerWithAccessors, "Outer$Inner.class": innerWithAccessors} {
+		if err := set.AddReader(name, bytes.NewReader(assemble(t, text))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var out bytes.Buffer
+	if err := set.Write(&out, Options{}); err != nil {
+		t.Fatal(err)
+	}
+	source := out.String()
+	for _, s := range []string{
+		"return new Outer.Inner();",
+		"\t\tInner() {\n\t\t\tsuper();",
+		"Outer.this.secret = 5;",
+		"Outer.this.hide(1);",
+		"return Outer.this.secret;",
+		"access$foo()",
+		"access$200(int param0)",
+	} {
+		if !strings.Contains(source, s) {
+			t.Errorf("source lacks %q:\n%s", s, source)
+		}
+	}
+	for _, s := range []string{"access$0", "access$1", "this$0", "Outer param0"} {
+		if strings.Contains(source, s) {
+			t.Errorf("source has %q:\n%s", s, source)
+		}
+	}
+}
+
+const outerWithLocals = `
+.version 52 0
+.class public super o/A
+.super java/lang/Object
+.attribute InnerClasses { 0x0003 Class o/A$1L 0x0000 Utf8 "L" 0x0000 Class o/A$1 0x0000 0x0000 0x0000 Class o/A$2 0x0000 0x0000 0x0000 }
+
+.method public make ()V
+	.code stack 3 locals 1
+		new Class o/A$1L
+		dup
+		aload_0
+		invokespecial Method o/A$1L <init> (Lo/A;)V
+		invokevirtual Method o/A$1L hello ()V
+		return
+	.end code
+.end method
+
+.method public task ()Ljava/lang/Runnable;
+	.code stack 3 locals 1
+		new Class o/A$1
+		dup
+		aload_0
+		invokespecial Method o/A$1 <init> (Lo/A;)V
+		areturn
+	.end code
+.end method
+
+.method public captures ()Ljava/lang/Object;
+	.code stack 4 locals 1
+		new Class o/A$2
+		dup
+		aload_0
+		iconst_1
+		invokespecial Method o/A$2 <init> (Lo/A;I)V
+		areturn
+	.end code
+.end method
+.end class
+`
+
+// localClass assembles a local or anonymous class of o/A declared in
+// method, with the given constructor parameters after the outer instance.
+func localClass(name, inner, method, desc, params, super string) string {
+	return `
+.version 52 0
+.class super ` + name + `
+.super ` + super + `
+.attribute InnerClasses { 0x0001 Class ` + name + ` 0x0000 ` + inner + ` 0x0000 }
+.attribute EnclosingMethod { Class o/A NameAndType ` + method + ` ` + desc + ` }
+.field final synthetic this$0 Lo/A;
+
+.method <init> (Lo/A;` + params + `)V
+	.code stack 2 locals 3
+		aload_0
+		aload_1
+		putfield Field ` + name + ` this$0 Lo/A;
+		aload_0
+		invokespecial Method ` + super + ` <init> ()V
+		return
+	.end code
+.end method
+
+.method public hello ()V
+	.code stack 0 locals 1
+		return
+	.end code
+.end method
+.end class
+`
+}
+
+// TestLocalClassPlacement checks that local classes are declared before
+// the statement that creates them and anonymous classes replace their
+// creation expression.
+func TestLocalClassPlacement(t *testing.T) {
+	set := NewClassSet()
+	for name, text := range map[string]string{
+		"A.class":    outerWithLocals,
+		"A$1L.class": localClass("o/A$1L", `Utf8 "L"`, "make", "()V", "", "java/lang/Object"),
+		"A$1.class":  localClass("o/A$1", "0x0000", "task", "()Ljava/lang/Runnable;", "", "java/lang/Thread"),
+		"A$2.class":  localClass("o/A$2", "0x0000", "captures", "()Ljava/lang/Object;", "I", "java/lang/Object"),
+	} {
+		if err := set.AddReader(name, bytes.NewReader(assemble(t, text))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var out bytes.Buffer
+	if err := set.Write(&out, Options{}); err != nil {
+		t.Fatal(err)
+	}
+	source := out.String()
+	for _, s := range []string{
+		"\tpublic void make() {\n\t\tclass L {\n",
+		"\t\t}\n\t\tnew L().hello();\n\t\treturn;\n",
+		"\t\treturn new Thread() {\n\t\t\tpublic void hello() {\n\t\t\t\treturn;\n\t\t\t}\n\t\t};\n",
+		// the captured value has no place in source
+		"| o/A$2\n\t\tnew Object() {\n",
+	} {
+		if !strings.Contains(source, s) {
+			t.Errorf("source lacks %q:\n%s", s, source)
+		}
+	}
+}
@@ -4,7 +4,6 @@ import (
 	"bytes"
 	"encoding/binary"
 	"fmt"
-	"log"
 	"regexp"
 	"strings"
 	"sync"
@@ -27,6 +26,10 @@ type ClassFile struct {
 	methods             []MethodInfo
 	attributes_count    uint16
 	attributes          []AttributeInfo
+	// constants indexes the first indexed entries of the pool by tag and
+	// info for addConstant
+	constants map[string]uint16
+	indexed   int
 }
 
 type ConstantTag byte
@@ -326,6 +329,7 @@ type FieldInfo struct {
 	attributes_count uint16
 	attributes       []AttributeInfo
 	imports          string
+	release          Release
 }
 
 func (this *CONSTANT_Utf8_info) IsSimple() (bool, error) {
@@ -333,29 +337,50 @@ func (this *CONSTANT_Utf8_info) IsSimple() (bool, error) {
 	if e != nil {
 		return false, e
 	}
-	switch v {
-	case "I", "Z":
+	switch {
+	case v == "B", v == "C", v == "D", v == "F", v == "I", v == "J", v == "S", v == "Z":
 		this.m.Lock()
 		defer this.m.Unlock()
-		this.valuestype, _ = typeFromSignature(v)
+		this.valuestype, _, _ = typeFromSignature(v)
 		return true, nil
+	case strings.HasPrefix(v, "["):
+		this.m.Lock()
+		defer this.m.Unlock()
+		var err error
+		this.valuestype, this.imports, err = typeFromSignature(v)
+		return false, err
 	default:
 		this.parseType()
 		return false, nil
 	}
 }
 
-func typeFromSignature(signature string) (string, string) {
+func typeFromSignature(signature string) (string, string, error) {
 	if len(signature) == 0 {
-		return "", ""
+		return "", "", nil
 	}
 	switch signature[0] {
 	case 'I':
-		return "int ", ""
+		return "int ", "", nil
 	case 'Z':
-		return "boolean ", ""
+		return "boolean ", "", nil
 	case 'V':
-		return "void ", ""
+		return "void ", "", nil
+	case 'B':
+		return "byte ", "", nil
+	case 'C':
+		return "char ", "", nil
+	case 'S':
+		return "short ", "", nil
+	case 'J':
+		return "long ", "", nil
+	case 'F':
+		return "float ", "", nil
+	case 'D':
+		return "double ", "", nil
+	case '[':
+		t, i, err := typeFromSignature(signature[1:])
+		return strings.TrimSuffix(t, " ") + "[] ", i, err
 	case 'L':
 		CLASSL := regexp.MustCompile(`(?m)L(.*\/)*(?P<classname>.*);`)
 		if CLASSL.MatchString(signature) {
@@ -365,15 +390,14 @@ func typeFromSignature(signature string) (string, string) {
 				if "classname" == name {
 					t := m[i] + " "
 					i := "import " + strings.ReplaceAll(signature[1:], "/", ".") + "// " + signature + "\n"
-					return t, i
+					return t, i, nil
 				}
 			}
 		}
 	default:
-		log.Panicf("signature: %s", signature)
-		//return false, nil
+		return "", "", fmt.Errorf("signature: %s", signature)
 	}
-	return "", ""
+	return "", "", nil
 }
 
 func (this *CONSTANT_Utf8_info) parseType() {
@@ -405,39 +429,37 @@ func (this *CONSTANT_Utf8_info) GetType() string {
 	return this.valuestype
 }
 
-func (this *FieldInfo) GetCode(cp []CpInfo) string {
+func (this *FieldInfo) GetCode(cp []CpInfo) (string, error) {
 	text, err := this.accessToString()
 	if err != nil {
-		log.Panic(err)
-		return ""
+		return "", err
 	}
-	c := cp[this.name_index-1]
-	CUtf8 := CONSTANT_Utf8_info{}
-	CUtf8.CpInfo = c
-	name, err := CUtf8.Values()
+	name, err := utf8At(cp, this.name_index)
 	if err != nil {
-		log.Panic(err)
-		return ""
+		return "", err
+	}
+	if _, err := utf8At(cp, this.descriptor_index); err != nil {
+		return "", err
 	}
 	d := CONSTANT_Utf8_info{}
 	d.CpInfo = cp[this.descriptor_index-1]
 	b, err := d.IsSimple()
 	if err != nil {
-		log.Panic(err)
-		return ""
+		return "", err
 	}
 	if b {
 		ft := d.GetType()
-		text += ft + " " + name
+		text += strings.TrimSpace(ft) + " " + name
 	} else {
 		this.imports = d.GetImports()
 		ft := d.GetType()
-		text += ft + " " + name
-	}
-	if this.attributes_count > 0 {
-		log.Printf("TODO: attributes")
+		text += strings.TrimSpace(ft) + " " + name
 	}
-	return text + ";"
+	desc, _ := d.Values()
+	text += constantValueCode(cp, this.attributes, desc, this.release)
+	annotations, imports := annotationsCode(cp, this.attributes, "\n", this.release)
+	this.imports += imports
+	return annotations + text + ";", nil
 }
 
 func (this *FieldInfo) accessToString() (string, error) {
@@ -472,7 +494,11 @@ MAINLOOP:
 			str += "/* synthetic */ "
 		case flag | ACC_ENUM:
 			flag = flag ^ ACC_ENUM
-			str += "enum "
+			if this.release.Allows(FeatureEnums) {
+				str += "enum "
+			} else {
+				str += "/* enum */ "
+			}
 		case 0:
 			break MAINLOOP
 		default:
@@ -488,47 +514,31 @@ type AttributeInfo struct {
 	info                 []byte
 }
 
-func (this *AttributeInfo) ToCodeAttribute() CodeAttribute {
+func (this *AttributeInfo) ToCodeAttribute() (CodeAttribute, error) {
+	r := infoReader{info: this.info}
 	ca := CodeAttribute{
 		attribute_name_index: this.attribute_name_index,
 		attribute_length:     this.attribute_length,
+		max_stack:            r.u2(),
+		max_locals:           r.u2(),
+		code_length:          r.u4(),
 	}
-	ca.max_stack = binary.BigEndian.Uint16(this.info[:2])
-	ca.max_locals = binary.BigEndian.Uint16(this.info[2:4])
-	ca.code_length = binary.BigEndian.Uint32(this.info[4:8])
-	ca.code = make([]byte, ca.code_length)
-	last := 8 + ca.code_length
-	copy(ca.code, this.info[8:last])
-	ca.exception_table_length = binary.BigEndian.Uint16(this.info[last : last+2])
-	last += 2
-	ca.exception_table = make([]ExceptionTable, ca.exception_table_length)
-	for i := range ca.exception_table {
-		et := ExceptionTable{}
-		et.start_pc = binary.BigEndian.Uint16(this.info[last : last+2])
-		last += 2
-		et.end_pc = binary.BigEndian.Uint16(this.info[last : last+2])
-		last += 2
-		et.handler_pc = binary.BigEndian.Uint16(this.info[last : last+2])
-		last += 2
-		et.catch_type = binary.BigEndian.Uint16(this.info[last : last+2])
-		last += 2
-		ca.exception_table[i] = et
-	}
-	ca.attributes_count = binary.BigEndian.Uint16(this.info[last : last+2])
-	last += 2
-	ca.attributes = make([]AttributeInfo, ca.attributes_count)
-	for i := range ca.attributes {
-		a := AttributeInfo{
-			attribute_name_index: binary.BigEndian.Uint16(this.info[last : last+2]),
-		}
-		last += 2
-		a.attribute_length = binary.BigEndian.Uint32(this.info[last : last+4])
-		last += 4
-		a.info = this.info[last : last+a.attribute_length]
-		last += a.attribute_length
-		ca.attributes[i] = a
+	if ca.code_length > 65535 || int64(ca.code_length) > int64(len(this.info)) {
+		return ca, fmt.Errorf("code_length %d out of range", ca.code_length)
+	}
+	ca.code = r.bytes(int(ca.code_length))
+	ca.exception_table_length = r.u2()
+	for i := uint16(0); i < ca.exception_table_length && r.err == nil; i++ {
+		ca.exception_table = append(ca.exception_table, ExceptionTable{
+			start_pc:   r.u2(),
+			end_pc:     r.u2(),
+			handler_pc: r.u2(),
+			catch_type: r.u2(),
+		})
 	}
-	return ca
+	ca.attributes_count = r.u2()
+	ca.attributes = r.attributes(ca.attributes_count)
+	return ca, r.err
 }
 
 type ExceptionTable struct {
@@ -557,80 +567,116 @@ type MethodInfo struct {
 	attributes_count uint16
 	attributes       []AttributeInfo
 	imports          string
-}
-
-func (this *MethodInfo) GetCode(cps []CpInfo) string {
+	inline           map[string]string
+	prelude          string
+	lineNumbers      LineNumberMode
+	stub             string
+	set              *ClassSet
+	owner            string
+	release          Release
+	// internal name of the outer class of inner class constructors
+	outer string
+}
+
+func (this *MethodInfo) GetCode(cps []CpInfo) (string, error) {
 	text, err := this.accessToString()
 	if err != nil {
-		log.Panic(err)
+		return "", err
 	}
-	c := cps[this.name_index-1]
-	CUtf8 := CONSTANT_Utf8_info{}
-	CUtf8.CpInfo = c
-	name, err := CUtf8.Values()
+	name, err := utf8At(cps, this.name_index)
 	if err != nil {
-		log.Panic(err)
-		return ""
+		return "", err
+	}
+	dstring, err := utf8At(cps, this.descriptor_index)
+	if err != nil {
+		return "", err
+	}
+	params, ret, err := parseMethodDescriptor(dstring)
+	if err != nil {
+		return "", err
+	}
+	t, imports, err := typeFromSignature(ret)
+	if err != nil {
+		return "", err
 	}
-
-	//if name != "<init>" {
-	d := CONSTANT_Utf8_info{}
-	d.CpInfo = cps[this.descriptor_index-1]
-	dstring, _ := d.Values()
-	rettype := regexp.MustCompile(`(?m).*\)(.*)`)
-	if !rettype.MatchString(dstring) {
-		log.Panic("rettype not match")
-	}
-	srettype := rettype.FindStringSubmatch(dstring)
-	t, imports := typeFromSignature(srettype[1])
 	if len(imports) > 0 {
 		this.imports += imports
 	}
-	reparam := regexp.MustCompile(`(?m)\((I|Z|L.*\;|.?)*\).*`)
-	if !reparam.MatchString(dstring) {
-		log.Panic("rettype not match")
-	}
-	sparam := reparam.FindStringSubmatch(dstring)
-	//}
+	annotations, imports := annotationsCode(cps, this.attributes, "\n", this.release)
+	this.imports += imports
+	text = annotations + text
 	if name == "<init>" {
 		text += name
 	} else {
 		text += t + name
 	}
 	text += "("
-	for i, param := range sparam[1:] {
-		tp, imports := typeFromSignature(param)
+	paramAnnotations, imports := parameterAnnotationsCode(cps, this.attributes, len(params), this.release)
+	this.imports += imports
+	var statements []statement
+	statementImports, decompiled := "", false
+	if this.stub == "" {
+		if ca, _ := this.Code(cps); ca != nil {
+			statements, statementImports, decompiled = this.statementsOf(ca, cps)
+		}
+	}
+	// the outer instance of an inner class is implied in source, unless
+	// the body stays a listing that refers to it
+	hidden := 0
+	if decompiled {
+		hidden = this.outerParameter(params)
+	}
+	for i, param := range params[hidden:] {
+		tp, imports, err := typeFromSignature(param)
+		if err != nil {
+			return "", err
+		}
 		if len(imports) > 0 {
 			this.imports += imports
 		}
-		if len(tp) > 0 {
-			str := fmt.Sprintf("%sparam%d,", tp, i)
-			text += str
+		if i > 0 {
+			text += ", "
 		}
-
+		text += fmt.Sprintf("%s%sparam%d", paramAnnotations[i+hidden], tp, i)
 	}
-	if text[len(text)-1] == ',' {
-		text = text[:len(text)-1]
+	text += ")"
+	defaultValue, imports := annotationDefaultCode(cps, this.attributes, this.release)
+	this.imports += imports
+	text += defaultValue
+	if this.access_flags&(ACC_ABSTRACT|ACC_NATIVE) != 0 {
+		return text + ";\n", nil
 	}
-	text += ") {\n\t"
+	text += " {\n"
 	for _, attr := range this.attributes {
-		attrname := CONSTANT_Utf8_info{
-			CpInfo: cps[attr.attribute_name_index-1],
-		}
-		val, err := attrname.Values()
+		val, err := utf8At(cps, attr.attribute_name_index)
 		if err != nil {
-			log.Panic(err)
-			return ""
+			return "", err
 		}
 		switch val {
 		case "Code":
-			ca := attr.ToCodeAttribute()
-			text += opcodeTostring(ca.code, cps)
-			log.Printf("name: %s Code: %#v", name, ca.code)
+			if this.stub != "" {
+				text += this.stub
+				continue
+			}
+			ca, err := attr.ToCodeAttribute()
+			if err != nil {
+				return "", err
+			}
+			text += this.prelude
+			var lines *lineNumbers
+			if this.lineNumbers != LineNumbersOff {
+				lines = lineNumbersOf(&ca, cps, this.lineNumbers == LineNumbersAlign)
+			}
+			if decompiled {
+				this.imports += statementImports
+				text += statementsToString(statements, lines)
+				continue
+			}
+			text += opcodeTostring(ca.code, cps, this.inline, lines)
 		}
 	}
 	text += "}\n"
-	return text
+	return text, nil
 }
 
 type opCode struct {
@@ -670,149 +716,168 @@ func (this *opCodes) GetPrevCode() opCode {
 	return this.GetCurrentCode()
 }
 
-func opcodeTostring(opcode []byte, cps []CpInfo) string {
-	str := ""
-	ops := opCodes.Init(opCodes{})
-	for i := 0; i < len(opcode); i++ {
-		str += "\t"
-		switch opcode[i] {
-		case 0x0:
-			ops.Add(opCode{opcode: opcode[i]})
-			/* opCode{
-				opcode: opcode[i],
-				param:  []interface{}{},
-			}*/
-			str += "// nop\n"
-		case 0x1:
-			ops.Add(opCode{opcode: opcode[i]})
-			str += "// aconst_null\n"
-		case 0x2:
-			ops.Add(opCode{opcode: opcode[i]})
-			str += "// iconst_m1 | -1\n"
-		case 0x3:
-			ops.Add(opCode{opcode: opcode[i]})
-			str += "// iconst_0\n"
-		case 0x4:
-			ops.Add(opCode{opcode: opcode[i]})
-			str += "// iconst_1\n"
-		case 0x5:
-			ops.Add(opCode{opcode: opcode[i]})
-			str += "// iconst_2\n"
-		case 0x6:
-			str += "// iconst_3\n"
-		case 0x7:
-			str += "// iconst_4\n"
-		case 0x8:
-			str += "// iconst_5\n"
-		case 0x9:
-			str += "// lconst_0\n"
-		case 0xa:
-			str += "// lconst_1\n"
-		case 0xb:
-			str += "// fconst_0\n"
-		case 0xc:
-			str += "// fconst_1\n"
-		case 0xd:
-			str += "// fconst_2\n"
-		case 0xe:
-			str += "// dconst_0\n"
-		case 0xf:
-			str += "// dconst_1\n"
-		case 0x10:
-			str += fmt.Sprintf("// bipush %#x\n", opcode[i+1])
-			i += 1
-		case 0x11:
-			str += fmt.Sprintf("// sipush %#x %#x\n", opcode[i+1], opcode[i+2])
-			i += 2
-		case 0x12:
-			str += fmt.Sprintf("// ldc %#x\n", opcode[i+1])
-			i += 1
-		case 0x13:
-			str += fmt.Sprintf("// ldc_w %#x %#x\n", opcode[i+1], opcode[i+2])
-			i += 2
-		case 0x14:
-			str += fmt.Sprintf("// ldc2_w %#x %#x\n", opcode[i+1], opcode[i+2])
-			i += 2
-		case 0x15:
-			str += fmt.Sprintf("// iload %#x\n", opcode[i+1])
-			i += 1
-		case 0x16:
-			str += fmt.Sprintf("// lload %#x\n", opcode[i+1])
-			i += 1
-		case 0x1b:
-			str += "// iload_1 \n"
-		case 0x20:
-			str += "// lload_2\n"
-		case 0x2a:
-			str += "// aload_0  | this.\n"
-		case 0x2b:
-			str += "// aload_1\n"
-		case 0x9f:
-			str += fmt.Sprintf("// if_icmpeq %#x %#x | != \n", opcode[i+1], opcode[i+2])
-			i += 2
-		case 0xa0:
-			str += fmt.Sprintf("// if_icmpne %#x %#x | == \n", opcode[i+1], opcode[i+2])
-			i += 2
-		case 0xa7:
-			str += fmt.Sprintf("// goto %#x %#x\n", opcode[i+1], opcode[i+2])
-			i += 2
-		case 0xac:
-			str += "// ireturn\n"
-		case 0xb1:
-			str += "return;\n"
-		case 0xb4:
-			str += fmt.Sprintf("// getfield %#x %#x | ", opcode[i+1], opcode[i+2])
-			n, _ := getName(opcode[i+1], opcode[i+2], cps)
-			str += n + "\n"
-			i += 2
-		case 0xb5:
-			str += fmt.Sprintf("// putfield %#x %#x | ", opcode[i+1], opcode[i+2])
-			n, _ := getName(opcode[i+1], opcode[i+2], cps)
-			str += n + "\n"
-			i += 2
-		case 0xb6:
-			str += fmt.Sprintf("// invokevirtual %#x %#x\n", opcode[i+1], opcode[i+2])
-			i += 2
-		case 0xb7:
-			str += fmt.Sprintf("// invokespecial %#x %#x\n", opcode[i+1], opcode[i+2])
-			i += 2
-		default:
-			fmt.Printf("TODO opcode %#x %d\n", opcode[i], opcode[i])
+// opcodeTostring renders code as one commented instruction per line. inline
+// holds the source of local and anonymous classes keyed by internal name;
+// it is emitted right after the "new" that instantiates the class. With
+// lines, instructions that start a source line are prefixed by /* line */;
+// in align mode all instructions of a source line share one output line.
+func opcodeTostring(opcode []byte, cps []CpInfo, inline map[string]string, lines *lineNumbers) string {
+	var str strings.Builder
+	ins, err := decodeInstructions(opcode)
+	align := lines != nil && lines.align
+	open := false
+	for _, in := range ins {
+		line, starts := lines.at(in.pc)
+		text := instructionToString(in, cps)
+		if align {
+			if starts || !open {
+				if open {
+					str.WriteString("\n")
+				}
+				str.WriteString("\t")
+				if starts {
+					fmt.Fprintf(&str, "/* %d */ ", line)
+				}
+				open = true
+			} else {
+				str.WriteString(" ")
+			}
+			str.WriteString(blockComment(text))
+		} else {
+			str.WriteString("\t")
+			if starts {
+				fmt.Fprintf(&str, "/* %d */ ", line)
+			}
+			str.WriteString(text + "\n")
+		}
+		if in.opcode == 0xbb {
+			if name, err := classNameAt(cps, in.Index()); err == nil && inline[name] != "" {
+				if open {
+					str.WriteString("\n")
+					open = false
+				}
+				str.WriteString("\t" + indent(inline[name]) + "\n")
+			}
 		}
 	}
-	return str
+	if open {
+		str.WriteString("\n")
+	}
+	if err != nil {
+		fmt.Fprintf(&str, "\t// %v\n", err)
+	}
+	return str.String()
 }
 
-func getName(op1, op2 byte, cps []CpInfo) (string, error) {
-	refaddress := uint16(uint16(op1)<<8 | uint16(op2))
-	ref := cps[refaddress-1]
-	fdef := CONSTANT_Fieldref_info{
-		CpInfo: ref,
+func instructionToString(in Instruction, cps []CpInfo) string {
+	if in.opcode == 0xb1 {
+		return "return;"
+	}
+	str := "// " + in.Name()
+	for _, b := range in.operands {
+		str += fmt.Sprintf(" %#x", b)
+	}
+	if info := operandInfo(in, cps); info != "" {
+		str += " | " + info
 	}
+	return str
+}
 
-	classindex := cps[fdef.ClassIndex()-1]
-	switch classindex.tag {
-	case CONSTANT_Class:
-		cinfo := CONSTANT_Class_info{
-			CpInfo: classindex,
+// blockComment turns a "// ..." line into "/* ... */" so that more code can
+// follow it on the same line.
+func blockComment(text string) string {
+	if !strings.HasPrefix(text, "// ") {
+		return text
+	}
+	return "/* " + strings.ReplaceAll(text[3:], "*/", "* /") + " */"
+}
+
+// operandInfo resolves the operands of in to something readable: constants,
+// member and class names, branch targets.
+func operandInfo(in Instruction, cps []CpInfo) string {
+	switch in.opcode {
+	case 0x02:
+		return "-1"
+	case 0x2a:
+		return "this."
+	case 0x10:
+		return fmt.Sprint(int8(in.operands[0]))
+	case 0x11:
+		return fmt.Sprint(int16(in.Index()))
+	case 0x12:
+		return constantToString(cps, uint16(in.operands[0]))
+	case 0x13, 0x14:
+		return constantToString(cps, in.Index())
+	case 0xb2, 0xb3, 0xb4, 0xb5:
+		_, name, _, err := memberRefAt(cps, in.Index())
+		if err != nil {
+			return ""
 		}
-		cname := CONSTANT_Utf8_info{
-			CpInfo: cps[cinfo.NameIndex()-1],
+		return name
+	case 0xb6, 0xb7, 0xb8, 0xb9:
+		owner, name, desc, err := memberRefAt(cps, in.Index())
+		if err != nil {
+			return ""
+		}
+		return owner + "." + name + desc
+	case 0xba:
+		if int(in.Index()) > len(cps) || in.Index() == 0 || cps[in.Index()-1].tag != CONSTANT_InvokeDynamic {
+			return ""
+		}
+		indy := CONSTANT_InvokeDynamic_info{
+			CpInfo: cps[in.Index()-1],
+		}
+		name, desc, err := nameAndTypeAt(cps, indy.NameAndTypeIndex())
+		if err != nil {
+			return ""
+		}
+		return name + desc
+	case 0xbb, 0xbd, 0xc0, 0xc1, 0xc5:
+		name, err := classNameAt(cps, in.Index())
+		if err != nil {
+			return ""
 		}
-		log.Print(cname.Values())
+		return name
+	case 0x99, 0x9a, 0x9b, 0x9c, 0x9d, 0x9e, 0x9f, 0xa0, 0xa1, 0xa2,
+		0xa3, 0xa4, 0xa5, 0xa6, 0xa7, 0xa8, 0xc6, 0xc7:
+		return fmt.Sprintf("-> %d", in.pc+int(int16(in.Index())))
+	case 0xc8, 0xc9:
+		return fmt.Sprintf("-> %d", in.pc+int(int32(binary.BigEndian.Uint32(in.operands))))
 	}
-	//			fmt.Printf("classindex: %v\n", classindex)
-	//fdef.ClassIndex()-1
-	NT1 := CONSTANT_NameAndType_info{
-		CpInfo: cps[fdef.NameAndTypeIndex()-1],
+	return ""
+}
+
+// constantToString renders a loadable constant pool entry.
+func constantToString(cps []CpInfo, index uint16) string {
+	if index == 0 || int(index) > len(cps) {
+		return ""
 	}
-	Name1 := CONSTANT_Utf8_info{
-		CpInfo: cps[NT1.NameIndex()-1],
+	c := cps[index-1]
+	switch c.tag {
+	case CONSTANT_Integer:
+		i := CONSTANT_Integer_info{c}
+		return javaInt(int32(i.Values()))
+	case CONSTANT_Float:
+		f := CONSTANT_Float_info{c}
+		return javaFloat(f.Values())
+	case CONSTANT_Long:
+		l := CONSTANT_Long_info{c}
+		return javaLong(l.Values())
+	case CONSTANT_Double:
+		d := CONSTANT_Double_info{c}
+		return javaDouble(d.Values())
+	case CONSTANT_String:
+		str := CONSTANT_String_info{c}
+		v, err := utf8At(cps, str.StringIndex())
+		if err != nil {
+			return ""
+		}
+		return javaString(v)
+	case CONSTANT_Class:
+		name, _ := classNameAt(cps, index)
+		return name + ".class"
 	}
-
-	log.Print(Name1.Values())
-
-	return Name1.Values()
+	return ""
 }
 
 func (this *MethodInfo) accessToString() (string, error) {
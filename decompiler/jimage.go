@@ -0,0 +1,218 @@
+package decompiler
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// A jimage, the lib/modules file of a JDK or of a jlink image, starts with
+// a header followed by a hash table of resource names, the location
+// attributes of each resource and a table of strings. Resources follow the
+// header, the tables and the strings. The image is in the byte order of
+// the platform that built it.
+const (
+	imageMagic        = 0xCAFEDADA
+	imageHeaderSize   = 7 * 4
+	compressedMagic   = 0xCAFEFAFA
+	compressedHdrSize = 29
+	// maxImageResource bounds the size of a decompressed resource, against
+	// headers that claim more than any class or resource holds.
+	maxImageResource = 1 << 28
+)
+
+// Location attribute kinds.
+const (
+	imageAttributeEnd = iota
+	imageAttributeModule
+	imageAttributeParent
+	imageAttributeBase
+	imageAttributeExtension
+	imageAttributeOffset
+	imageAttributeCompressed
+	imageAttributeUncompressed
+)
+
+func isImageMagic(magic []byte) bool {
+	return binary.LittleEndian.Uint32(magic) == imageMagic || binary.BigEndian.Uint32(magic) == imageMagic
+}
+
+type imageHeader struct {
+	magic          uint32
+	version        uint32
+	flags          uint32
+	resource_count uint32
+	table_length   uint32
+	locations_size uint32
+	strings_size   uint32
+}
+
+// imageEntries lists the resources of a jimage as module/path entries,
+// e.g. java.base/java/lang/String.class.
+func imageEntries(r io.ReaderAt, size int64) ([]entry, error) {
+	buff := make([]byte, imageHeaderSize)
+	if _, err := r.ReadAt(buff, 0); err != nil {
+		return nil, err
+	}
+	var order binary.ByteOrder = binary.LittleEndian
+	if binary.BigEndian.Uint32(buff) == imageMagic {
+		order = binary.BigEndian
+	}
+	h := imageHeader{
+		magic:          order.Uint32(buff[0:]),
+		version:        order.Uint32(buff[4:]),
+		flags:          order.Uint32(buff[8:]),
+		resource_count: order.Uint32(buff[12:]),
+		table_length:   order.Uint32(buff[16:]),
+		locations_size: order.Uint32(buff[20:]),
+		strings_size:   order.Uint32(buff[24:]),
+	}
+	if h.version>>16 != 1 {
+		return nil, fmt.Errorf("unsupported jimage version %d.%d", h.version>>16, h.version&0xFFFF)
+	}
+	indexSize := int64(imageHeaderSize) + 8*int64(h.table_length) + int64(h.locations_size) + int64(h.strings_size)
+	if indexSize > size {
+		return nil, fmt.Errorf("jimage index of %d bytes exceeds file size %d", indexSize, size)
+	}
+	index := make([]byte, indexSize-imageHeaderSize)
+	if _, err := r.ReadAt(index, imageHeaderSize); err != nil {
+		return nil, err
+	}
+	offsets := index[4*h.table_length : 8*h.table_length]
+	locations := index[8*h.table_length : 8*h.table_length+h.locations_size]
+	strs := index[8*h.table_length+h.locations_size:]
+	str := func(offset uint64) string {
+		if offset >= uint64(len(strs)) {
+			return ""
+		}
+		s := strs[offset:]
+		if end := bytes.IndexByte(s, 0); end >= 0 {
+			s = s[:end]
+		}
+		return string(s)
+	}
+	files := make([]entry, 0, h.table_length)
+	for i := uint32(0); i < h.table_length; i++ {
+		var attributes [imageAttributeUncompressed + 1]uint64
+		for pos := int(order.Uint32(offsets[4*i:])); pos < len(locations); {
+			kind := locations[pos] >> 3
+			if kind == imageAttributeEnd {
+				break
+			}
+			n := int(locations[pos]&7) + 1
+			if kind > imageAttributeUncompressed || pos+1+n > len(locations) {
+				return nil, fmt.Errorf("jimage location %d is malformed", i)
+			}
+			for _, b := range locations[pos+1 : pos+1+n] {
+				attributes[kind] = attributes[kind]<<8 | uint64(b)
+			}
+			pos += 1 + n
+		}
+		module := str(attributes[imageAttributeModule])
+		name := str(attributes[imageAttributeBase])
+		if parent := str(attributes[imageAttributeParent]); parent != "" {
+			name = parent + "/" + name
+		}
+		if ext := str(attributes[imageAttributeExtension]); ext != "" {
+			name += "." + ext
+		}
+		if module == "" || module == "modules" || module == "packages" {
+			// directories of the jrt file system, not resources
+			continue
+		}
+		offset := indexSize + int64(attributes[imageAttributeOffset])
+		compressed := int64(attributes[imageAttributeCompressed])
+		uncompressed := int64(attributes[imageAttributeUncompressed])
+		if compressed == 0 && offset+uncompressed > size || offset+compressed > size {
+			return nil, fmt.Errorf("jimage resource /%s/%s exceeds file size", module, name)
+		}
+		files = append(files, entry{
+			name: module + "/" + name,
+			open: func() (io.ReadCloser, error) {
+				if compressed == 0 {
+					return io.NopCloser(io.NewSectionReader(r, offset, uncompressed)), nil
+				}
+				data := make([]byte, compressed)
+				if _, err := r.ReadAt(data, offset); err != nil {
+					return nil, err
+				}
+				data, err := decompressImageResource(data, order, strs, size)
+				if err != nil {
+					return nil, fmt.Errorf("/%s/%s: %w", module, name, err)
+				}
+				return io.NopCloser(bytes.NewReader(data)), nil
+			},
+		})
+	}
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].name < files[j].name
+	})
+	return files, nil
+}
+
+// decompressImageResource undoes the compression of jlink --compress. Each
+// layer has a header naming its decompressor; only "zip" is supported, the
+// string sharing of "compact-cp" is not. Layers that claim to decompress
+// to more than the image size or maxImageResource are rejected.
+func decompressImageResource(data []byte, order binary.ByteOrder, strs []byte, size int64) ([]byte, error) {
+	for len(data) >= compressedHdrSize && order.Uint32(data) == compressedMagic {
+		uncompressed := order.Uint64(data[12:])
+		nameOffset := order.Uint32(data[20:])
+		name := ""
+		if int(nameOffset) < len(strs) {
+			name = string(strs[nameOffset:])
+			if end := strings.IndexByte(name, 0); end >= 0 {
+				name = name[:end]
+			}
+		}
+		if name != "zip" {
+			return nil, fmt.Errorf("unsupported jimage compression %q", name)
+		}
+		if uncompressed > uint64(size) || uncompressed > maxImageResource {
+			return nil, fmt.Errorf("jimage resource claims %d uncompressed bytes", uncompressed)
+		}
+		zr, err := zlib.NewReader(bytes.NewReader(data[compressedHdrSize:]))
+		if err != nil {
+			return nil, err
+		}
+		out := make([]byte, uncompressed)
+		if _, err := io.ReadFull(zr, out); err != nil {
+			return nil, err
+		}
+		data = out
+	}
+	return data, nil
+}
+
+// JavaModules returns the modules of a jimage, those with a module-info,
+// or nil for other archives.
+func (this *Archive) JavaModules() []string {
+	if !this.image {
+		return nil
+	}
+	var modules []string
+	for _, f := range this.files {
+		if module, rest, ok := strings.Cut(f.name, "/"); ok && rest == "module-info.class" {
+			modules = append(modules, module)
+		}
+	}
+	return modules
+}
+
+// JavaModule returns the resources of one module of a jimage as an archive
+// of its own, so that its classes are written like those of a jar.
+func (this *Archive) JavaModule(module string) *Archive {
+	a := &Archive{
+		path: this.path + "!/" + module,
+	}
+	for _, f := range this.files {
+		if strings.HasPrefix(f.name, module+"/") {
+			a.files = append(a.files, entry{f.name[len(module)+1:], f.open})
+		}
+	}
+	return a
+}
@@ -0,0 +1,134 @@
+package decompiler
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// LineNumberMode selects how LineNumberTable information shows up in the
+// output.
+type LineNumberMode int
+
+const (
+	LineNumbersOff LineNumberMode = iota
+	// LineNumbersComment prefixes the first instruction of every source
+	// line with /* line */.
+	LineNumbersComment
+	// LineNumbersAlign also pads the output with blank lines so that
+	// statements land on their original line, as far as the order of the
+	// members allows. Stack traces then point straight into the file.
+	LineNumbersAlign
+)
+
+func ParseLineNumberMode(s string) (LineNumberMode, bool) {
+	switch s {
+	case "", "off":
+		return LineNumbersOff, true
+	case "comment":
+		return LineNumbersComment, true
+	case "align":
+		return LineNumbersAlign, true
+	}
+	return LineNumbersOff, false
+}
+
+type LineNumberEntry struct {
+	start_pc    uint16
+	line_number uint16
+}
+
+type LineNumberTableAttribute struct {
+	attribute_name_index     uint16
+	attribute_length         uint32
+	line_number_table_length uint16
+	line_number_table        []LineNumberEntry
+}
+
+func (this *AttributeInfo) ToLineNumberTableAttribute() (LineNumberTableAttribute, error) {
+	r := infoReader{info: this.info}
+	la := LineNumberTableAttribute{
+		attribute_name_index:     this.attribute_name_index,
+		attribute_length:         this.attribute_length,
+		line_number_table_length: r.u2(),
+	}
+	for i := uint16(0); i < la.line_number_table_length && r.err == nil; i++ {
+		la.line_number_table = append(la.line_number_table, LineNumberEntry{
+			start_pc:    r.u2(),
+			line_number: r.u2(),
+		})
+	}
+	return la, r.err
+}
+
+// lineNumbers maps code offsets to source lines.
+type lineNumbers struct {
+	align bool
+	pcs   map[int]int
+}
+
+// lineNumbersOf merges all LineNumberTable attributes of a Code attribute.
+func lineNumbersOf(ca *CodeAttribute, cp []CpInfo, align bool) *lineNumbers {
+	lines := &lineNumbers{
+		align: align,
+		pcs:   make(map[int]int),
+	}
+	for i := range ca.attributes {
+		if name, _ := utf8At(cp, ca.attributes[i].attribute_name_index); name != "LineNumberTable" {
+			continue
+		}
+		la, err := ca.attributes[i].ToLineNumberTableAttribute()
+		if err != nil {
+			continue
+		}
+		for _, e := range la.line_number_table {
+			lines.pcs[int(e.start_pc)] = int(e.line_number)
+		}
+	}
+	return lines
+}
+
+func (this *lineNumbers) at(pc int) (int, bool) {
+	if this == nil {
+		return 0, false
+	}
+	line, ok := this.pcs[pc]
+	return line, ok
+}
+
+var lineMarker = regexp.MustCompile(`^\s*/\* (\d+) \*/`)
+
+// alignLines pads text with blank lines so that every line starting with a
+// /* N */ marker ends up as line N. Lines without a marker that precede a
+// marked line (method headers, annotations) move down with it; closing
+// braces stay where they are. Markers that are already behind are left
+// alone.
+func alignLines(text string) string {
+	var out []string
+	var pending []string
+	for _, line := range strings.Split(text, "\n") {
+		m := lineMarker.FindStringSubmatch(line)
+		if m == nil {
+			pending = append(pending, line)
+			continue
+		}
+		want, _ := strconv.Atoi(m[1])
+		closers := 0
+		for closers < len(pending) {
+			t := strings.TrimSpace(pending[closers])
+			if t != "" && t != "}" {
+				break
+			}
+			closers++
+		}
+		out = append(out, pending[:closers]...)
+		for pad := want - (len(out) + len(pending) - closers + 1); pad > 0; pad-- {
+			out = append(out, "")
+		}
+		out = append(out, pending[closers:]...)
+		out = append(out, line)
+		pending = pending[:0]
+	}
+	out = append(out, pending...)
+	return strings.Join(out, "\n")
+}
@@ -0,0 +1,227 @@
+package decompiler
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf16"
+)
+
+// decodeModifiedUtf8 turns the modified UTF-8 of CONSTANT_Utf8 entries
+// (two byte NUL, surrogate pairs encoded separately) into UTF-16 units.
+// Malformed bytes are kept as single units.
+func decodeModifiedUtf8(s string) []uint16 {
+	units := make([]uint16, 0, len(s))
+	for i := 0; i < len(s); {
+		c := s[i]
+		switch {
+		case c < 0x80:
+			units = append(units, uint16(c))
+			i++
+		case c&0xe0 == 0xc0 && i+1 < len(s):
+			units = append(units, uint16(c&0x1f)<<6|uint16(s[i+1]&0x3f))
+			i += 2
+		case c&0xf0 == 0xe0 && i+2 < len(s):
+			units = append(units, uint16(c&0x0f)<<12|uint16(s[i+1]&0x3f)<<6|uint16(s[i+2]&0x3f))
+			i += 3
+		default:
+			units = append(units, uint16(c))
+			i++
+		}
+	}
+	return units
+}
+
+// escapeUnit escapes one UTF-16 unit for use inside a Java literal quoted
+// with quote.
+func escapeUnit(u uint16, quote byte) string {
+	switch u {
+	case '\b':
+		return `\b`
+	case '\t':
+		return `\t`
+	case '\n':
+		return `\n`
+	case '\f':
+		return `\f`
+	case '\r':
+		return `\r`
+	case '\\':
+		return `\\`
+	case uint16(quote):
+		return `\` + string(rune(quote))
+	}
+	r := rune(u)
+	if utf16.IsSurrogate(r) || !unicode.IsPrint(r) {
+		return fmt.Sprintf(`\u%04x`, u)
+	}
+	return string(r)
+}
+
+// javaString renders a CONSTANT_Utf8 value as a Java string literal.
+func javaString(s string) string {
+	var text strings.Builder
+	text.WriteByte('"')
+	for _, u := range decodeModifiedUtf8(s) {
+		text.WriteString(escapeUnit(u, '"'))
+	}
+	text.WriteByte('"')
+	return text.String()
+}
+
+func javaChar(c uint16) string {
+	return "'" + escapeUnit(c, '\'') + "'"
+}
+
+func javaInt(v int32) string {
+	return strconv.FormatInt(int64(v), 10)
+}
+
+func javaLong(v int64) string {
+	return strconv.FormatInt(v, 10) + "L"
+}
+
+func javaBoolean(v int32) string {
+	if v != 0 {
+		return "true"
+	}
+	return "false"
+}
+
+// javaFloat and javaDouble render the shortest decimal that reads back as
+// the same value. NaN and the infinities have no literal and are written
+// as the constant expressions Float.NaN and friends are defined with.
+func javaFloat(v float32) string {
+	switch {
+	case math.IsNaN(float64(v)):
+		return "0.0F / 0.0F"
+	case math.IsInf(float64(v), 1):
+		return "1.0F / 0.0F"
+	case math.IsInf(float64(v), -1):
+		return "-1.0F / 0.0F"
+	}
+	return floatText(float64(v), 32) + "F"
+}
+
+func javaDouble(v float64) string {
+	switch {
+	case math.IsNaN(v):
+		return "0.0 / 0.0"
+	case math.IsInf(v, 1):
+		return "1.0 / 0.0"
+	case math.IsInf(v, -1):
+		return "-1.0 / 0.0"
+	}
+	return floatText(v, 64)
+}
+
+func floatText(v float64, bits int) string {
+	s := strconv.FormatFloat(v, 'g', -1, bits)
+	for i := 0; i < len(s); i++ {
+		if s[i] == '.' || s[i] == 'e' {
+			return s
+		}
+	}
+	return s + ".0"
+}
+
+// constantValueCode renders " = literal" from the ConstantValue attribute
+// of a field with the given descriptor. int, short, byte, char and boolean
+// constants all live in CONSTANT_Integer entries. Multi-line strings become
+// text blocks when the release allows them.
+func constantValueCode(cp []CpInfo, attrs []AttributeInfo, descriptor string, release Release) string {
+	attr := findAttribute(cp, attrs, "ConstantValue")
+	if attr == nil || len(attr.info) < 2 {
+		return ""
+	}
+	index := binary.BigEndian.Uint16(attr.info)
+	if index == 0 || int(index) > len(cp) {
+		return " /* ConstantValue index out of range */"
+	}
+	c := cp[index-1]
+	switch {
+	case c.tag == CONSTANT_Integer && len(descriptor) == 1:
+		i := CONSTANT_Integer_info{c}
+		v := int32(i.Values())
+		switch descriptor {
+		case "C":
+			return " = " + javaChar(uint16(v))
+		case "Z":
+			return " = " + javaBoolean(v)
+		case "B", "S", "I":
+			return " = " + javaInt(v)
+		}
+	case c.tag == CONSTANT_String && descriptor == "Ljava/lang/String;" && release.Allows(FeatureTextBlocks):
+		str := CONSTANT_String_info{c}
+		v, err := utf8At(cp, str.StringIndex())
+		if err == nil && isMultiline(v) {
+			return " = " + javaTextBlock(v, "\t\t")
+		}
+		return " = " + constantToString(cp, index)
+	case c.tag == CONSTANT_Long && descriptor == "J",
+		c.tag == CONSTANT_Float && descriptor == "F",
+		c.tag == CONSTANT_Double && descriptor == "D",
+		c.tag == CONSTANT_String && descriptor == "Ljava/lang/String;":
+		return " = " + constantToString(cp, index)
+	}
+	return fmt.Sprintf(" /* ConstantValue %v does not match %s */", c.tag, descriptor)
+}
+
+// javaTextBlock renders a multi-line string as a text block indented with
+// prefix. Every third quote of a run, a quote right before the closing
+// delimiter, trailing spaces and line breaks other than \n are escaped so
+// the block reads back as exactly s.
+func javaTextBlock(s string, prefix string) string {
+	units := decodeModifiedUtf8(s)
+	var lines []string
+	line := ""
+	quotes := 0
+	for _, u := range units {
+		if u == '\n' {
+			lines = append(lines, line)
+			line = ""
+			quotes = 0
+			continue
+		}
+		if u == '"' {
+			quotes++
+			if quotes == 3 {
+				line += `\"`
+				quotes = 0
+			} else {
+				line += `"`
+			}
+			continue
+		}
+		quotes = 0
+		line += escapeUnit(u, 0)
+	}
+	if quotes > 0 {
+		// it would run into the closing """
+		line = line[:len(line)-1] + `\"`
+	}
+	lines = append(lines, line)
+	var text strings.Builder
+	text.WriteString(`"""`)
+	for i, l := range lines {
+		last := i == len(lines)-1
+		if strings.HasSuffix(l, " ") {
+			l = l[:len(l)-1] + `\s`
+		}
+		text.WriteString("\n")
+		if l != "" || last {
+			text.WriteString(prefix)
+		}
+		text.WriteString(l)
+	}
+	text.WriteString(`"""`)
+	return text.String()
+}
+
+// isMultiline reports strings worth writing as a text block.
+func isMultiline(s string) bool {
+	return strings.Count(strings.TrimSuffix(s, "\n"), "\n") > 0
+}
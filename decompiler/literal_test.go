@@ -0,0 +1,39 @@
+package decompiler
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestTextBlock(t *testing.T) {
+	for _, test := range []struct {
+		s, want string
+	}{
+		{"a\n\"\"\"\"b", "\"\"\"\n\ta\n\t\"\"\\\"\"b\"\"\""},
+		{"a\n\"\"\"\"\"\"\"b", "\"\"\"\n\ta\n\t\"\"\\\"\"\"\\\"\"b\"\"\""},
+		{"a\nb\"", "\"\"\"\n\ta\n\tb\\\"\"\"\""},
+		{"a\nb\"\"", "\"\"\"\n\ta\n\tb\"\\\"\"\"\""},
+		{"a\nb\"\"\"", "\"\"\"\n\ta\n\tb\"\"\\\"\"\"\""},
+		{"a\\\nb\\\"", "\"\"\"\n\ta\\\\\n\tb\\\\\\\"\"\"\""},
+		{"a \nb", "\"\"\"\n\ta\\s\n\tb\"\"\""},
+	} {
+		got := javaTextBlock(test.s, "\t")
+		if got != test.want {
+			t.Errorf("%q: got %s, want %s", test.s, got, test.want)
+		}
+		// the first unescaped """ after the opening one must close it
+		rest := got[3:]
+		for i := 0; i < len(rest); i++ {
+			if rest[i] == '\\' {
+				i++
+				continue
+			}
+			if strings.HasPrefix(rest[i:], `"""`) {
+				if i != len(rest)-3 {
+					t.Errorf("%q: %s closes early", test.s, got)
+				}
+				break
+			}
+		}
+	}
+}
@@ -0,0 +1,310 @@
+package decompiler
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	ACC_OPEN         AccessFlags = 0x0020
+	ACC_TRANSITIVE   AccessFlags = 0x0020
+	ACC_STATIC_PHASE AccessFlags = 0x0040
+	ACC_MANDATED     AccessFlags = 0x8000
+)
+
+type ModuleRequires struct {
+	requires_index         uint16
+	requires_flags         AccessFlags
+	requires_version_index uint16
+}
+
+// ModuleExports is used for both exports and opens directives.
+type ModuleExports struct {
+	exports_index    uint16
+	exports_flags    AccessFlags
+	exports_to_count uint16
+	exports_to_index []uint16
+}
+
+type ModuleProvides struct {
+	provides_index      uint16
+	provides_with_count uint16
+	provides_with_index []uint16
+}
+
+type ModuleAttribute struct {
+	attribute_name_index uint16
+	attribute_length     uint32
+	module_name_index    uint16
+	module_flags         AccessFlags
+	module_version_index uint16
+	requires_count       uint16
+	requires             []ModuleRequires
+	exports_count        uint16
+	exports              []ModuleExports
+	opens_count          uint16
+	opens                []ModuleExports
+	uses_count           uint16
+	uses_index           []uint16
+	provides_count       uint16
+	provides             []ModuleProvides
+}
+
+func (this *infoReader) u2s(n uint16) []uint16 {
+	var v []uint16
+	for i := uint16(0); i < n && this.err == nil; i++ {
+		v = append(v, this.u2())
+	}
+	return v
+}
+
+func (this *infoReader) moduleExports(n uint16) []ModuleExports {
+	var v []ModuleExports
+	for i := uint16(0); i < n && this.err == nil; i++ {
+		e := ModuleExports{
+			exports_index:    this.u2(),
+			exports_flags:    AccessFlags(this.u2()),
+			exports_to_count: this.u2(),
+		}
+		e.exports_to_index = this.u2s(e.exports_to_count)
+		v = append(v, e)
+	}
+	return v
+}
+
+func (this *AttributeInfo) ToModuleAttribute() (ModuleAttribute, error) {
+	r := infoReader{info: this.info}
+	ma := ModuleAttribute{
+		attribute_name_index: this.attribute_name_index,
+		attribute_length:     this.attribute_length,
+		module_name_index:    r.u2(),
+		module_flags:         AccessFlags(r.u2()),
+		module_version_index: r.u2(),
+	}
+	ma.requires_count = r.u2()
+	for i := uint16(0); i < ma.requires_count && r.err == nil; i++ {
+		ma.requires = append(ma.requires, ModuleRequires{
+			requires_index:         r.u2(),
+			requires_flags:         AccessFlags(r.u2()),
+			requires_version_index: r.u2(),
+		})
+	}
+	ma.exports_count = r.u2()
+	ma.exports = r.moduleExports(ma.exports_count)
+	ma.opens_count = r.u2()
+	ma.opens = r.moduleExports(ma.opens_count)
+	ma.uses_count = r.u2()
+	ma.uses_index = r.u2s(ma.uses_count)
+	ma.provides_count = r.u2()
+	for i := uint16(0); i < ma.provides_count && r.err == nil; i++ {
+		p := ModuleProvides{
+			provides_index:      r.u2(),
+			provides_with_count: r.u2(),
+		}
+		p.provides_with_index = r.u2s(p.provides_with_count)
+		ma.provides = append(ma.provides, p)
+	}
+	return ma, r.err
+}
+
+type ModulePackagesAttribute struct {
+	attribute_name_index uint16
+	attribute_length     uint32
+	package_count        uint16
+	package_index        []uint16
+}
+
+func (this *AttributeInfo) ToModulePackagesAttribute() (ModulePackagesAttribute, error) {
+	r := infoReader{info: this.info}
+	pa := ModulePackagesAttribute{
+		attribute_name_index: this.attribute_name_index,
+		attribute_length:     this.attribute_length,
+		package_count:        r.u2(),
+	}
+	pa.package_index = r.u2s(pa.package_count)
+	return pa, r.err
+}
+
+type ModuleMainClassAttribute struct {
+	attribute_name_index uint16
+	attribute_length     uint32
+	main_class_index     uint16
+}
+
+func (this *AttributeInfo) ToModuleMainClassAttribute() (ModuleMainClassAttribute, error) {
+	r := infoReader{info: this.info}
+	ma := ModuleMainClassAttribute{
+		attribute_name_index: this.attribute_name_index,
+		attribute_length:     this.attribute_length,
+		main_class_index:     r.u2(),
+	}
+	return ma, r.err
+}
+
+func moduleNameAt(cp []CpInfo, index uint16) (string, error) {
+	if index == 0 || int(index) > len(cp) || cp[index-1].tag != CONSTANT_Module {
+		return "", fmt.Errorf("constant pool index %d is not a module", index)
+	}
+	m := CONSTANT_Module_info{
+		cp[index-1],
+	}
+	return utf8At(cp, m.NameIndex())
+}
+
+// packageNameAt returns the package in source form, e.g. java.lang.
+func packageNameAt(cp []CpInfo, index uint16) (string, error) {
+	if index == 0 || int(index) > len(cp) || cp[index-1].tag != CONSTANT_Package {
+		return "", fmt.Errorf("constant pool index %d is not a package", index)
+	}
+	p := CONSTANT_Package_info{
+		cp[index-1],
+	}
+	name, err := utf8At(cp, p.NameIndex())
+	return strings.ReplaceAll(name, "/", "."), err
+}
+
+func sourceClassNameAt(cp []CpInfo, index uint16) (string, error) {
+	name, err := classNameAt(cp, index)
+	return strings.ReplaceAll(strings.ReplaceAll(name, "/", "."), "$", "."), err
+}
+
+// moduleCode renders module-info.java from the Module, ModulePackages and
+// ModuleMainClass attributes. The last two and the module version have no
+// source form; they are kept as comments.
+func (this *decompiler) moduleCode() (string, string, error) {
+	cp := this.class.constant_pool
+	attr := findAttribute(cp, this.class.attributes, "Module")
+	if attr == nil {
+		return "", "", fmt.Errorf("module-info without Module attribute")
+	}
+	ma, err := attr.ToModuleAttribute()
+	if err != nil {
+		return "", "", err
+	}
+	text := ""
+	if ma.module_version_index != 0 {
+		version, err := utf8At(cp, ma.module_version_index)
+		if err != nil {
+			return "", "", err
+		}
+		text += "// version: " + version + "\n"
+	}
+	if attr := findAttribute(cp, this.class.attributes, "ModuleMainClass"); attr != nil {
+		mc, err := attr.ToModuleMainClassAttribute()
+		if err != nil {
+			return "", "", err
+		}
+		main, err := sourceClassNameAt(cp, mc.main_class_index)
+		if err != nil {
+			return "", "", err
+		}
+		text += "// main class: " + main + "\n"
+	}
+	if attr := findAttribute(cp, this.class.attributes, "ModulePackages"); attr != nil {
+		mp, err := attr.ToModulePackagesAttribute()
+		if err != nil {
+			return "", "", err
+		}
+		var packages []string
+		for _, p := range mp.package_index {
+			name, err := packageNameAt(cp, p)
+			if err != nil {
+				return "", "", err
+			}
+			packages = append(packages, name)
+		}
+		text += "// packages: " + strings.Join(packages, ", ") + "\n"
+	}
+	annotations, imports := annotationsCode(cp, this.class.attributes, "\n", this.class.Release())
+	text += annotations
+	if ma.module_flags&ACC_OPEN != 0 {
+		text += "open "
+	}
+	name, err := moduleNameAt(cp, ma.module_name_index)
+	if err != nil {
+		return "", "", err
+	}
+	text += "module " + name + " {\n"
+
+	for _, r := range ma.requires {
+		if r.requires_flags&ACC_MANDATED != 0 {
+			// requires java.base
+			continue
+		}
+		name, err := moduleNameAt(cp, r.requires_index)
+		if err != nil {
+			return "", "", err
+		}
+		text += "\trequires "
+		if r.requires_flags&ACC_TRANSITIVE != 0 {
+			text += "transitive "
+		}
+		if r.requires_flags&ACC_STATIC_PHASE != 0 {
+			text += "static "
+		}
+		text += name + ";"
+		if r.requires_version_index != 0 {
+			if version, err := utf8At(cp, r.requires_version_index); err == nil {
+				text += " // " + version
+			}
+		}
+		text += "\n"
+	}
+	for _, directive := range []struct {
+		keyword string
+		list    []ModuleExports
+	}{{"exports", ma.exports}, {"opens", ma.opens}} {
+		for _, e := range directive.list {
+			if e.exports_flags&ACC_MANDATED != 0 {
+				continue
+			}
+			pkg, err := packageNameAt(cp, e.exports_index)
+			if err != nil {
+				return "", "", err
+			}
+			text += "\t" + directive.keyword + " " + pkg
+			for i, to := range e.exports_to_index {
+				module, err := moduleNameAt(cp, to)
+				if err != nil {
+					return "", "", err
+				}
+				if i == 0 {
+					text += " to "
+				} else {
+					text += ", "
+				}
+				text += module
+			}
+			text += ";\n"
+		}
+	}
+	for _, u := range ma.uses_index {
+		service, err := sourceClassNameAt(cp, u)
+		if err != nil {
+			return "", "", err
+		}
+		text += "\tuses " + service + ";\n"
+	}
+	for _, p := range ma.provides {
+		service, err := sourceClassNameAt(cp, p.provides_index)
+		if err != nil {
+			return "", "", err
+		}
+		text += "\tprovides " + service
+		for i, w := range p.provides_with_index {
+			impl, err := sourceClassNameAt(cp, w)
+			if err != nil {
+				return "", "", err
+			}
+			if i == 0 {
+				text += " with "
+			} else {
+				text += ", "
+			}
+			text += impl
+		}
+		text += ";\n"
+	}
+	text += "}\n"
+	return text, imports, nil
+}
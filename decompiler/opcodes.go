@@ -0,0 +1,192 @@
+package decompiler
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// Instruction is a single decoded bytecode instruction. For wide the
+// modified opcode is kept as the first operand byte.
+type Instruction struct {
+	pc       int
+	opcode   byte
+	operands []byte
+}
+
+func (this *Instruction) Pc() int {
+	return this.pc
+}
+
+func (this *Instruction) Opcode() byte {
+	return this.opcode
+}
+
+func (this *Instruction) Name() string {
+	if name := opcodeNames[this.opcode]; name != "" {
+		return name
+	}
+	return fmt.Sprintf("op_%#x", this.opcode)
+}
+
+// Index returns the unsigned 16 bit operand used by constant pool and
+// local variable referencing instructions.
+func (this *Instruction) Index() uint16 {
+	if len(this.operands) < 2 {
+		if len(this.operands) == 1 {
+			return uint16(this.operands[0])
+		}
+		return 0
+	}
+	return binary.BigEndian.Uint16(this.operands[:2])
+}
+
+func (this *Instruction) Len() int {
+	return 1 + len(this.operands)
+}
+
+const (
+	opVariable = -1
+	opInvalid  = -2
+)
+
+var opcodeNames = [256]string{
+	0x00: "nop", 0x01: "aconst_null", 0x02: "iconst_m1", 0x03: "iconst_0",
+	0x04: "iconst_1", 0x05: "iconst_2", 0x06: "iconst_3", 0x07: "iconst_4",
+	0x08: "iconst_5", 0x09: "lconst_0", 0x0a: "lconst_1", 0x0b: "fconst_0",
+	0x0c: "fconst_1", 0x0d: "fconst_2", 0x0e: "dconst_0", 0x0f: "dconst_1",
+	0x10: "bipush", 0x11: "sipush", 0x12: "ldc", 0x13: "ldc_w", 0x14: "ldc2_w",
+	0x15: "iload", 0x16: "lload", 0x17: "fload", 0x18: "dload", 0x19: "aload",
+	0x1a: "iload_0", 0x1b: "iload_1", 0x1c: "iload_2", 0x1d: "iload_3",
+	0x1e: "lload_0", 0x1f: "lload_1", 0x20: "lload_2", 0x21: "lload_3",
+	0x22: "fload_0", 0x23: "fload_1", 0x24: "fload_2", 0x25: "fload_3",
+	0x26: "dload_0", 0x27: "dload_1", 0x28: "dload_2", 0x29: "dload_3",
+	0x2a: "aload_0", 0x2b: "aload_1", 0x2c: "aload_2", 0x2d: "aload_3",
+	0x2e: "iaload", 0x2f: "laload", 0x30: "faload", 0x31: "daload",
+	0x32: "aaload", 0x33: "baload", 0x34: "caload", 0x35: "saload",
+	0x36: "istore", 0x37: "lstore", 0x38: "fstore", 0x39: "dstore", 0x3a: "astore",
+	0x3b: "istore_0", 0x3c: "istore_1", 0x3d: "istore_2", 0x3e: "istore_3",
+	0x3f: "lstore_0", 0x40: "lstore_1", 0x41: "lstore_2", 0x42: "lstore_3",
+	0x43: "fstore_0", 0x44: "fstore_1", 0x45: "fstore_2", 0x46: "fstore_3",
+	0x47: "dstore_0", 0x48: "dstore_1", 0x49: "dstore_2", 0x4a: "dstore_3",
+	0x4b: "astore_0", 0x4c: "astore_1", 0x4d: "astore_2", 0x4e: "astore_3",
+	0x4f: "iastore", 0x50: "lastore", 0x51: "fastore", 0x52: "dastore",
+	0x53: "aastore", 0x54: "bastore", 0x55: "castore", 0x56: "sastore",
+	0x57: "pop", 0x58: "pop2", 0x59: "dup", 0x5a: "dup_x1", 0x5b: "dup_x2",
+	0x5c: "dup2", 0x5d: "dup2_x1", 0x5e: "dup2_x2", 0x5f: "swap",
+	0x60: "iadd", 0x61: "ladd", 0x62: "fadd", 0x63: "dadd",
+	0x64: "isub", 0x65: "lsub", 0x66: "fsub", 0x67: "dsub",
+	0x68: "imul", 0x69: "lmul", 0x6a: "fmul", 0x6b: "dmul",
+	0x6c: "idiv", 0x6d: "ldiv", 0x6e: "fdiv", 0x6f: "ddiv",
+	0x70: "irem", 0x71: "lrem", 0x72: "frem", 0x73: "drem",
+	0x74: "ineg", 0x75: "lneg", 0x76: "fneg", 0x77: "dneg",
+	0x78: "ishl", 0x79: "lshl", 0x7a: "ishr", 0x7b: "lshr", 0x7c: "iushr", 0x7d: "lushr",
+	0x7e: "iand", 0x7f: "land", 0x80: "ior", 0x81: "lor", 0x82: "ixor", 0x83: "lxor",
+	0x84: "iinc",
+	0x85: "i2l", 0x86: "i2f", 0x87: "i2d", 0x88: "l2i", 0x89: "l2f", 0x8a: "l2d",
+	0x8b: "f2i", 0x8c: "f2l", 0x8d: "f2d", 0x8e: "d2i", 0x8f: "d2l", 0x90: "d2f",
+	0x91: "i2b", 0x92: "i2c", 0x93: "i2s",
+	0x94: "lcmp", 0x95: "fcmpl", 0x96: "fcmpg", 0x97: "dcmpl", 0x98: "dcmpg",
+	0x99: "ifeq", 0x9a: "ifne", 0x9b: "iflt", 0x9c: "ifge", 0x9d: "ifgt", 0x9e: "ifle",
+	0x9f: "if_icmpeq", 0xa0: "if_icmpne", 0xa1: "if_icmplt", 0xa2: "if_icmpge",
+	0xa3: "if_icmpgt", 0xa4: "if_icmple", 0xa5: "if_acmpeq", 0xa6: "if_acmpne",
+	0xa7: "goto", 0xa8: "jsr", 0xa9: "ret", 0xaa: "tableswitch", 0xab: "lookupswitch",
+	0xac: "ireturn", 0xad: "lreturn", 0xae: "freturn", 0xaf: "dreturn",
+	0xb0: "areturn", 0xb1: "return",
+	0xb2: "getstatic", 0xb3: "putstatic", 0xb4: "getfield", 0xb5: "putfield",
+	0xb6: "invokevirtual", 0xb7: "invokespecial", 0xb8: "invokestatic",
+	0xb9: "invokeinterface", 0xba: "invokedynamic",
+	0xbb: "new", 0xbc: "newarray", 0xbd: "anewarray", 0xbe: "arraylength",
+	0xbf: "athrow", 0xc0: "checkcast", 0xc1: "instanceof",
+	0xc2: "monitorenter", 0xc3: "monitorexit", 0xc4: "wide",
+	0xc5: "multianewarray", 0xc6: "ifnull", 0xc7: "ifnonnull",
+	0xc8: "goto_w", 0xc9: "jsr_w",
+}
+
+// opcodeOperands holds the number of operand bytes following each opcode.
+var opcodeOperands = func() [256]int {
+	var n [256]int
+	for i := range n {
+		if opcodeNames[i] == "" {
+			n[i] = opInvalid
+		}
+	}
+	for _, op := range []byte{0x10, 0x12, 0x15, 0x16, 0x17, 0x18, 0x19,
+		0x36, 0x37, 0x38, 0x39, 0x3a, 0xa9, 0xbc} {
+		n[op] = 1
+	}
+	for _, op := range []byte{0x11, 0x13, 0x14, 0x84, 0xa7, 0xa8,
+		0xb2, 0xb3, 0xb4, 0xb5, 0xb6, 0xb7, 0xb8, 0xbb, 0xbd, 0xc0, 0xc1, 0xc6, 0xc7} {
+		n[op] = 2
+	}
+	for op := 0x99; op <= 0xa6; op++ {
+		n[op] = 2
+	}
+	n[0xc5] = 3
+	n[0xb9], n[0xba], n[0xc8], n[0xc9] = 4, 4, 4, 4
+	n[0xaa], n[0xab], n[0xc4] = opVariable, opVariable, opVariable
+	return n
+}()
+
+// decodeInstructions splits the code array of a Code attribute into
+// instructions. Truncated or unknown instructions are reported as errors.
+func decodeInstructions(code []byte) ([]Instruction, error) {
+	ins := make([]Instruction, 0, len(code)/2)
+	for pc := 0; pc < len(code); {
+		op := code[pc]
+		n := opcodeOperands[op]
+		switch n {
+		case opInvalid:
+			return ins, fmt.Errorf("unknown opcode %#x at %d", op, pc)
+		case opVariable:
+			var err error
+			n, err = variableOperands(code, pc)
+			if err != nil {
+				return ins, err
+			}
+		}
+		if pc+1+n > len(code) {
+			return ins, fmt.Errorf("truncated %s at %d", opcodeNames[op], pc)
+		}
+		ins = append(ins, Instruction{
+			pc:       pc,
+			opcode:   op,
+			operands: code[pc+1 : pc+1+n],
+		})
+		pc += 1 + n
+	}
+	return ins, nil
+}
+
+func variableOperands(code []byte, pc int) (int, error) {
+	op := code[pc]
+	if op == 0xc4 {
+		if pc+1 >= len(code) {
+			return 0, fmt.Errorf("truncated wide at %d", pc)
+		}
+		if code[pc+1] == 0x84 {
+			return 5, nil
+		}
+		return 3, nil
+	}
+	pad := 3 - pc%4
+	start := pc + 1 + pad
+	if start+8 > len(code) {
+		return 0, fmt.Errorf("truncated %s at %d", opcodeNames[op], pc)
+	}
+	if op == 0xaa {
+		if start+12 > len(code) {
+			return 0, fmt.Errorf("truncated tableswitch at %d", pc)
+		}
+		low := int32(binary.BigEndian.Uint32(code[start+4:]))
+		high := int32(binary.BigEndian.Uint32(code[start+8:]))
+		if high < low || int64(high)-int64(low) >= int64(len(code)) {
+			return 0, fmt.Errorf("bad tableswitch range at %d", pc)
+		}
+		return pad + 12 + int(high-low+1)*4, nil
+	}
+	npairs := int32(binary.BigEndian.Uint32(code[start+4:]))
+	if npairs < 0 || int64(npairs)*8 > int64(len(code)-start-8) {
+		return 0, fmt.Errorf("bad lookupswitch size at %d", pc)
+	}
+	return pad + 8 + int(npairs)*8, nil
+}
@@ -0,0 +1,144 @@
+package decompiler
+
+import (
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+)
+
+// source is one class file of an input. Workers open it, so archive
+// entries are only inflated by the goroutine that parses them.
+type source struct {
+	path  string
+	class string // file name below the directory or class prefix, e.g. java/lang/String.class
+	open  func() (io.ReadCloser, error)
+}
+
+// ClassError is the failure of a single class file or class.
+type ClassError struct {
+	Path string
+	Err  error
+}
+
+func (this ClassError) Error() string {
+	return this.Path + ": " + this.Err.Error()
+}
+
+func (this ClassError) Unwrap() error {
+	return this.Err
+}
+
+// Errors collects the per-class failures of a run in input order. The other
+// classes of the run are still written.
+type Errors []ClassError
+
+func (this Errors) Error() string {
+	lines := make([]string, len(this))
+	for i, e := range this {
+		lines[i] = e.Error()
+	}
+	return strings.Join(lines, "\n")
+}
+
+func (this Errors) orNil() error {
+	if len(this) == 0 {
+		return nil
+	}
+	return this
+}
+
+// parallel calls work for 0..n-1 from at most jobs goroutines. A jobs
+// value below 1 means one goroutine per CPU.
+func parallel(n, jobs int, work func(i int)) {
+	if jobs < 1 {
+		jobs = runtime.NumCPU()
+	}
+	if jobs > n {
+		jobs = n
+	}
+	next := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < jobs; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				work(i)
+			}
+		}()
+	}
+	for i := 0; i < n; i++ {
+		next <- i
+	}
+	close(next)
+	wg.Wait()
+}
+
+// dirSources lists the .class files below dir in lexical order.
+func dirSources(dir string) ([]source, error) {
+	var sources []source
+	err := filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() || !strings.EqualFold(filepath.Ext(path), ".class") {
+			return nil
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		sources = append(sources, source{
+			path:  path,
+			class: filepath.ToSlash(rel),
+			open: func() (io.ReadCloser, error) {
+				return os.Open(path)
+			},
+		})
+		return nil
+	})
+	return sources, err
+}
+
+// addSources parses sources on the worker pool and adds them to the set in
+// input order, so that names and output do not depend on scheduling.
+// Sources the filter leaves out are kept for lookups.
+func (this *ClassSet) addSources(all []source) error {
+	var sources []source
+	for _, s := range all {
+		name := strings.TrimSuffix(s.class, path.Ext(s.class))
+		if s.class == "" || this.filter.Match(name) {
+			sources = append(sources, s)
+		} else {
+			this.lazy[name] = s
+		}
+	}
+	parsed := make([]*decompiler, len(sources))
+	errs := make([]error, len(sources))
+	parallel(len(sources), this.jobs, func(i int) {
+		rc, err := sources[i].open()
+		if err != nil {
+			errs[i] = err
+			return
+		}
+		defer rc.Close()
+		d := New(sources[i].path)
+		errs[i] = d.Parse(rc)
+		parsed[i] = d
+	})
+	var failed Errors
+	for i, d := range parsed {
+		if errs[i] == nil {
+			errs[i] = this.Add(d)
+		}
+		if errs[i] != nil {
+			failed = append(failed, ClassError{sources[i].path, errs[i]})
+		}
+	}
+	return failed.orNil()
+}
@@ -0,0 +1,220 @@
+package decompiler
+
+import (
+	"strings"
+)
+
+// recordInfo describes a class compiled from a record declaration. The
+// components come from the Record attribute; javac generates the fields,
+// accessors, canonical constructor and the ObjectMethods based
+// equals/hashCode/toString from them.
+type recordInfo struct {
+	names       []string
+	descriptors []string
+}
+
+func (this *decompiler) recordInfo() *recordInfo {
+	cp := this.class.constant_pool
+	if this.class.super_class == 0 || !this.class.Release().Allows(FeatureRecords) {
+		return nil
+	}
+	super, err := classNameAt(cp, this.class.super_class)
+	if err != nil || super != "java/lang/Record" {
+		return nil
+	}
+	attr := findAttribute(cp, this.class.attributes, "Record")
+	if attr == nil {
+		return nil
+	}
+	ra, err := attr.ToRecordAttribute()
+	if err != nil {
+		return nil
+	}
+	r := &recordInfo{}
+	for _, rc := range ra.components {
+		name, err := utf8At(cp, rc.name_index)
+		if err != nil {
+			return nil
+		}
+		desc, err := utf8At(cp, rc.descriptor_index)
+		if err != nil || !validFieldDescriptor(desc) {
+			return nil
+		}
+		r.names = append(r.names, name)
+		r.descriptors = append(r.descriptors, desc)
+	}
+	return r
+}
+
+// header renders the component list, e.g. "(int x, int y)".
+func (this *recordInfo) header() (string, string) {
+	text := "("
+	imports := ""
+	for i, name := range this.names {
+		// recordInfo keeps valid descriptors only
+		t, imp, _ := typeFromSignature(this.descriptors[i])
+		imports += imp
+		if i > 0 {
+			text += ", "
+		}
+		text += t + name
+	}
+	return text + ")", imports
+}
+
+func (this *recordInfo) component(name, descriptor string) bool {
+	for i := range this.names {
+		if this.names[i] == name && this.descriptors[i] == descriptor {
+			return true
+		}
+	}
+	return false
+}
+
+func (this *recordInfo) canonicalDescriptor() string {
+	return "(" + strings.Join(this.descriptors, "") + ")V"
+}
+
+// isImplicitRecordField reports the private final instance field backing a
+// component.
+func (this *decompiler) isImplicitRecordField(field FieldInfo) bool {
+	if field.access_flags&ACC_STATIC != 0 {
+		return false
+	}
+	cp := this.class.constant_pool
+	return this.record.component(field.Name(cp), field.Descriptor(cp))
+}
+
+// isImplicitRecordMethod reports members javac generates for a record:
+// trivial accessors and equals/hashCode/toString that go through
+// java/lang/runtime/ObjectMethods. The canonical constructor is handled by
+// compactConstructor.
+func (this *decompiler) isImplicitRecordMethod(method MethodInfo) bool {
+	cp := this.class.constant_pool
+	name := method.Name(cp)
+	desc := method.Descriptor(cp)
+	ca, _ := method.Code(cp)
+	if ca == nil {
+		return false
+	}
+	ins, err := decodeInstructions(ca.code)
+	if err != nil {
+		return false
+	}
+	switch {
+	case name == "toString" && desc == "()Ljava/lang/String;",
+		name == "hashCode" && desc == "()I",
+		name == "equals" && desc == "(Ljava/lang/Object;)Z":
+		for _, in := range ins {
+			if in.opcode != 0xba {
+				continue
+			}
+			owner, bsm, err := this.class.bootstrapMethodOf(in.Index())
+			if err == nil && owner == "java/lang/runtime/ObjectMethods" && bsm == "bootstrap" {
+				return true
+			}
+		}
+		return false
+	case strings.HasPrefix(desc, "()") && this.record.component(name, desc[2:]):
+		// aload_0; getfield <this.name>; xreturn
+		if len(ins) != 3 || ins[0].opcode != 0x2a || ins[1].opcode != 0xb4 ||
+			ins[2].opcode < 0xac || ins[2].opcode > 0xb0 {
+			return false
+		}
+		owner, fname, fdesc, err := memberRefAt(cp, ins[1].Index())
+		if err != nil {
+			return false
+		}
+		thisName, _ := classNameAt(cp, this.class.this_class)
+		return owner == thisName && fname == name && fdesc == desc[2:]
+	}
+	return false
+}
+
+// compactConstructor recognises the canonical constructor. body is the
+// bytecode between the super call and the trailing field assignments: empty
+// for an implicit constructor, otherwise the body of a compact constructor.
+func (this *decompiler) compactConstructor(method MethodInfo) (canonical bool, body []byte) {
+	cp := this.class.constant_pool
+	if method.Name(cp) != "<init>" || method.Descriptor(cp) != this.record.canonicalDescriptor() {
+		return false, nil
+	}
+	ca, _ := method.Code(cp)
+	if ca == nil {
+		return false, nil
+	}
+	ins, err := decodeInstructions(ca.code)
+	if err != nil || len(ins) < 3 {
+		return false, nil
+	}
+	// aload_0; invokespecial java/lang/Record.<init>
+	if ins[0].opcode != 0x2a || ins[1].opcode != 0xb7 {
+		return false, nil
+	}
+	if owner, name, _, err := memberRefAt(cp, ins[1].Index()); err != nil ||
+		owner != "java/lang/Record" || name != "<init>" {
+		return false, nil
+	}
+	if ins[len(ins)-1].opcode != 0xb1 {
+		return false, nil
+	}
+	// Walk back over "aload_0; <load>; putfield" triples, one per component,
+	// each storing its own parameter into its own field. Anything else
+	// assigns fields a compact constructor could not and stays explicit.
+	thisName, _ := classNameAt(cp, this.class.this_class)
+	slot := 1 + slots(this.record.descriptors)
+	end := len(ins) - 1
+	for n := len(this.record.names) - 1; n >= 0; n-- {
+		desc := this.record.descriptors[n]
+		slot -= slots([]string{desc})
+		if end-3 < 2 || ins[end-1].opcode != 0xb5 || ins[end-3].opcode != 0x2a {
+			return false, nil
+		}
+		if load, kind, ok := loadOf(ins[end-2]); !ok || load != slot || kind != loadKindOf(desc) {
+			return false, nil
+		}
+		owner, name, fdesc, err := memberRefAt(cp, ins[end-1].Index())
+		if err != nil || owner != thisName || name != this.record.names[n] || fdesc != desc {
+			return false, nil
+		}
+		end -= 3
+	}
+	start := ins[2].pc
+	stop := ins[end].pc
+	return true, ca.code[start:stop]
+}
+
+// loadOf returns the local variable an xload instruction reads and its
+// kind, 0 for iload through 4 for aload.
+func loadOf(in Instruction) (slot, kind int, ok bool) {
+	switch op := in.opcode; {
+	case op >= 0x15 && op <= 0x19:
+		return int(in.operands[0]), int(op - 0x15), true
+	case op >= 0x1a && op <= 0x2d:
+		return int(op-0x1a) % 4, int(op-0x1a) / 4, true
+	}
+	return 0, 0, false
+}
+
+// loadKindOf returns the kind of xload that reads a value of type desc.
+func loadKindOf(desc string) int {
+	if desc == "" {
+		return -1
+	}
+	switch desc[0] {
+	case 'J':
+		return 1
+	case 'F':
+		return 2
+	case 'D':
+		return 3
+	case 'L', '[':
+		return 4
+	}
+	return 0
+}
+
+func (this *decompiler) compactConstructorCode(method MethodInfo, body []byte) string {
+	text, _ := method.accessToString()
+	return text + "<init> {\n" + opcodeTostring(body, this.class.constant_pool, nil, nil) + "}\n"
+}
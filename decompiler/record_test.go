@@ -0,0 +1,53 @@
+package decompiler
+
+import (
+	"strings"
+	"testing"
+)
+
+// point is a record whose canonical constructor is written as
+// ctor; it implements java/io/Serializable.
+func point(ctor string) string {
+	return `
+.version 61 0
+.class public final super r/Point
+.super java/lang/Record
+.implements java/io/Serializable
+.attribute Record { 0x0002 Utf8 "x" Utf8 "I" 0x0000 Utf8 "y" Utf8 "I" 0x0000 }
+.field private final x I
+.field private final y I
+.method public <init> (II)V
+	.code stack 2 locals 3
+		aload_0
+		invokespecial Method java/lang/Record <init> ()V
+` + ctor + `
+		return
+	.end code
+.end method
+.end class
+`
+}
+
+func TestRecordConstructor(t *testing.T) {
+	for _, test := range []struct {
+		ctor     string
+		explicit bool
+	}{
+		{"aload_0\niload_1\nputfield Field r/Point x I\naload_0\niload_2\nputfield Field r/Point y I", false},
+		// swapped parameters are no compact constructor
+		{"aload_0\niload_2\nputfield Field r/Point x I\naload_0\niload_1\nputfield Field r/Point y I", true},
+		{"aload_0\niload_1\nputfield Field r/Point y I\naload_0\niload_2\nputfield Field r/Point x I", true},
+	} {
+		source, err := parseClass(t, assemble(t, point(test.ctor))).Source()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(source, "import java.io.Serializable;") ||
+			!strings.Contains(source, "record Point(int x, int y) implements Serializable {") {
+			t.Errorf("%q: wrong header:\n%s", test.ctor, source)
+		}
+		if explicit := strings.Contains(source, "Point(int param0, int param1)"); explicit != test.explicit {
+			t.Errorf("%q: explicit constructor %v, want %v:\n%s", test.ctor, explicit, test.explicit, source)
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package decompiler
+
+import (
+	"fmt"
+	"strings"
+)
+
+// recoverError turns a panic of the calling function into an error, so
+// that one malformed class or method does not stop a whole run. It must be
+// deferred directly.
+func recoverError(err *error) {
+	if r := recover(); r != nil {
+		*err = panicError{r}
+	}
+}
+
+// panicError is a recovered panic. Malformed input is expected to produce
+// ordinary errors; the fuzz tests treat a panicError as a bug.
+type panicError struct {
+	value interface{}
+}
+
+func (this panicError) Error() string {
+	return fmt.Sprintf("panic: %v", this.value)
+}
+
+func (this *FieldInfo) safeCode(cp []CpInfo) (code string, err error) {
+	defer recoverError(&err)
+	return this.GetCode(cp)
+}
+
+func (this *MethodInfo) safeCode(cps []CpInfo) (code string, err error) {
+	defer recoverError(&err)
+	return this.GetCode(cps)
+}
+
+// stubCode renders a method that could not be decompiled: a comment with
+// the failure and the raw disassembly, then the declaration with a body
+// that throws. Only the name and descriptor are kept when even the
+// declaration cannot be rendered.
+func (this *MethodInfo) stubCode(cps []CpInfo, failure error) string {
+	text := "/* " + commentSafe(failure.Error()) + "\n"
+	if ca, _ := this.Code(cps); ca != nil {
+		text += rawDisassembly(ca.code)
+	}
+	text += " */\n"
+	m := *this
+	m.inline = nil
+	m.prelude = ""
+	m.stub = "\tthrow new UnsupportedOperationException(\"decompilation failed\");\n"
+	code, err := m.safeCode(cps)
+	if err != nil {
+		return text + "// " + this.Name(cps) + this.Descriptor(cps) + "\n"
+	}
+	return text + code
+}
+
+// rawDisassembly lists the instructions of code one per line as comment
+// lines, without resolving the constant pool.
+func rawDisassembly(code []byte) string {
+	ins, err := decodeInstructions(code)
+	var text strings.Builder
+	end := 0
+	for _, in := range ins {
+		fmt.Fprintf(&text, " * %5d: %s", in.pc, in.Name())
+		for _, b := range in.operands {
+			fmt.Fprintf(&text, " %#x", b)
+		}
+		text.WriteString("\n")
+		end = in.pc + in.Len()
+	}
+	if err != nil {
+		text.WriteString(" * " + commentSafe(err.Error()) + ":")
+		for _, b := range code[end:] {
+			fmt.Fprintf(&text, " %02x", b)
+		}
+		text.WriteString("\n")
+	}
+	return text.String()
+}
+
+func commentSafe(s string) string {
+	return strings.ReplaceAll(s, "*/", "* /")
+}
@@ -0,0 +1,96 @@
+package decompiler
+
+import "strings"
+
+// permittedSubclasses returns the internal names listed in the
+// PermittedSubclasses attribute, or nil when the class is not sealed.
+func (this *decompiler) permittedSubclasses() ([]string, error) {
+	cp := this.class.constant_pool
+	attr := findAttribute(cp, this.class.attributes, "PermittedSubclasses")
+	if attr == nil {
+		return nil, nil
+	}
+	pa, err := attr.ToPermittedSubclassesAttribute()
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(pa.classes))
+	for _, c := range pa.classes {
+		name, err := classNameAt(cp, c)
+		if err != nil {
+			return nil, err
+		}
+		names = append(names, name)
+	}
+	return names, nil
+}
+
+// sealingModifier returns "sealed " for classes with PermittedSubclasses
+// and "non-sealed " for non final classes permitted by a sealed super type
+// of the same ClassSet. Final subclasses already print "final ".
+func (this *decompiler) sealingModifier() string {
+	if this.class.access_flags&ACC_ENUM != 0 || !this.class.Release().Allows(FeatureSealed) {
+		return ""
+	}
+	permits, err := this.permittedSubclasses()
+	if err == nil && permits != nil {
+		return "sealed "
+	}
+	if this.class.access_flags&ACC_FINAL != 0 || this.record != nil {
+		return ""
+	}
+	name, err := this.Name()
+	if err != nil {
+		return ""
+	}
+	cp := this.class.constant_pool
+	supers := append([]uint16{this.class.super_class}, this.class.interfaces...)
+	for _, index := range supers {
+		if index == 0 {
+			continue
+		}
+		super, err := classNameAt(cp, index)
+		if err != nil {
+			continue
+		}
+		parent := this.set.Lookup(super)
+		if parent == nil {
+			continue
+		}
+		permitted, err := parent.permittedSubclasses()
+		if err != nil {
+			continue
+		}
+		for _, p := range permitted {
+			if p == name {
+				return "non-sealed "
+			}
+		}
+	}
+	return ""
+}
+
+// permitsClause renders " permits A, B" and the imports it needs.
+func (this *decompiler) permitsClause() (string, string, error) {
+	if this.class.access_flags&ACC_ENUM != 0 {
+		return "", "", nil
+	}
+	permits, err := this.permittedSubclasses()
+	if err != nil || len(permits) == 0 {
+		return "", "", err
+	}
+	text := " permits "
+	imports := ""
+	if !this.class.Release().Allows(FeatureSealed) {
+		// PermittedSubclasses in an older class file is only informative
+		return " /* permits " + strings.Join(permits, ", ") + " */", "", nil
+	}
+	for i, p := range permits {
+		if i > 0 {
+			text += ", "
+		}
+		text += simpleName(p)
+		imports += this.addImport(p)
+	}
+	return text, imports, nil
+}
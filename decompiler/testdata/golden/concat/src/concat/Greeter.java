@@ -0,0 +1,13 @@
+package concat;
+
+public class Greeter {
+    private final String name;
+
+    public Greeter(String name) {
+        this.name = name;
+    }
+
+    public String greet(int times) {
+        return "Hello, " + name + " x" + times;
+    }
+}
@@ -0,0 +1,13 @@
+package constants;
+
+public class Consts {
+    public static final int MIN = Integer.MIN_VALUE;
+    public static final long BIG = 1L << 40;
+    public static final float HALF = 1.5f;
+    public static final double NAN = Double.NaN;
+    public static final float NINF = Float.NEGATIVE_INFINITY;
+    public static final double HUGE = 1e300;
+    public static final char NL = '\n';
+    public static final boolean YES = true;
+    public static final String TEXT = "tab\there \"q\" \\ end";
+}
@@ -0,0 +1,23 @@
+package inner;
+
+public class Outer {
+    private int secret;
+
+    public Runnable run() {
+        return new Runnable() {
+            public void run() {}
+        };
+    }
+
+    public void m() {
+        class Local {
+            void hello() {}
+        }
+    }
+
+    private class Inner {
+        int get() {
+            return secret;
+        }
+    }
+}
@@ -0,0 +1,16 @@
+package locals;
+
+public class Flags {
+    static void use(boolean b, char c) {}
+
+    public boolean ready() {
+        boolean done = true;
+        return done;
+    }
+
+    public void call() {
+        boolean on = false;
+        char c = 'x';
+        use(on, c);
+    }
+}
@@ -0,0 +1,20 @@
+package nestmates;
+
+public class Counter {
+    private int count;
+
+    private void bump() {
+        count++;
+    }
+
+    public String label(String prefix) {
+        return prefix + ": " + count;
+    }
+
+    public class Step {
+        public int next() {
+            bump();
+            return count;
+        }
+    }
+}
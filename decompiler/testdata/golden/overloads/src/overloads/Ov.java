@@ -0,0 +1,27 @@
+package overloads;
+
+public class Ov {
+    public void test(int n) {
+        p((String) null);
+        p((Object) "x");
+        q(1L);
+        c('a');
+        b((byte) 1);
+        z(true);
+        Object o = "s";
+        p(o);
+        p((String) o);
+        new StringBuilder().append("a");
+        int m = n + 1;
+    }
+
+    static void p(Object o) {}
+    static void p(String s) {}
+    static void p(Integer i) {}
+    static void q(long l) {}
+    static void q(int i) {}
+    static void c(char c) {}
+    static void c(int i) {}
+    static void b(byte b) {}
+    static void z(boolean z) {}
+}
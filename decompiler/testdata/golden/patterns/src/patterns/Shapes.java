@@ -0,0 +1,16 @@
+package patterns;
+
+public class Shapes {
+    public sealed interface Shape permits Circle, Square {}
+
+    public record Circle(double radius) implements Shape {}
+
+    public record Square(double side) implements Shape {}
+
+    public static double area(Shape shape) {
+        return switch (shape) {
+            case Circle c -> Math.PI * c.radius() * c.radius();
+            case Square(double side) -> side * side;
+        };
+    }
+}
@@ -0,0 +1,7 @@
+package records;
+
+public record Point(int x, int y) {
+    public int sum() {
+        return x + y;
+    }
+}
@@ -0,0 +1,4 @@
+package shapes;
+
+public final class Circle extends Shape {
+}
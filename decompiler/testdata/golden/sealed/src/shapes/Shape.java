@@ -0,0 +1,4 @@
+package shapes;
+
+public abstract sealed class Shape permits Circle, Square {
+}
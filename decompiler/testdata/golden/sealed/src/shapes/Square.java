@@ -0,0 +1,4 @@
+package shapes;
+
+public non-sealed class Square extends Shape {
+}
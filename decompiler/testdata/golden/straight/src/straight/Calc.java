@@ -0,0 +1,16 @@
+package straight;
+
+public class Calc {
+    public int f(int a) {
+        int b = a + 1;
+        return b * 2;
+    }
+
+    public String g() {
+        return "*/x";
+    }
+
+    public static long mix(long x, float y) {
+        return x + (long) y;
+    }
+}
@@ -0,0 +1,925 @@
+package decompiler
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Verification types are strings: I, F, J and D for the primitives, the
+// descriptor for class and array types, T for top, N for null, U for
+// uninitializedThis, u<pc> for the object a new at pc created and R for a
+// reference of unknown class, which type inference makes of two different
+// classes. Long and double take two slots, the second of them T, on the
+// stack as in the locals.
+
+// typeFrame is the state of the locals and the operand stack before an
+// instruction.
+type typeFrame struct {
+	locals []string
+	stack  []string
+}
+
+func (this *typeFrame) copy() *typeFrame {
+	return &typeFrame{
+		locals: append([]string{}, this.locals...),
+		stack:  append([]string{}, this.stack...),
+	}
+}
+
+type typeChecker struct {
+	*verifier
+	ca     *CodeAttribute
+	ins    []Instruction
+	index  map[int]int
+	name   string
+	static bool
+	params []string
+	ret    string
+}
+
+func typeName(t string) string {
+	switch t {
+	case "T":
+		return "top"
+	case "I":
+		return "int"
+	case "F":
+		return "float"
+	case "J":
+		return "long"
+	case "D":
+		return "double"
+	case "N":
+		return "null"
+	case "U":
+		return "uninitializedThis"
+	case "R":
+		return "reference"
+	}
+	if strings.HasPrefix(t, "u") {
+		return "uninitialized(" + t[1:] + ")"
+	}
+	return t
+}
+
+// verificationType is the type a value of the field descriptor has on the
+// stack.
+func verificationType(desc string) string {
+	switch desc {
+	case "B", "C", "S", "Z":
+		return "I"
+	}
+	return desc
+}
+
+// refType is the verification type of a class or array class name.
+func refType(name string) string {
+	if strings.HasPrefix(name, "[") {
+		return name
+	}
+	return "L" + name + ";"
+}
+
+func isCategory2(t string) bool {
+	return t == "J" || t == "D"
+}
+
+// isObject reports a class, array, null or unknown reference type.
+func isObject(t string) bool {
+	return t != "" && (t[0] == 'L' || t[0] == '[' || t == "N" || t == "R")
+}
+
+// isReference also admits the uninitialized types.
+func isReference(t string) bool {
+	return isObject(t) || t == "U" || strings.HasPrefix(t, "u")
+}
+
+// assignable reports whether a value of type from may be used as type to.
+// Where the class hierarchy is missing it answers yes.
+func (this *typeChecker) assignable(from, to string) bool {
+	if from == to || to == "T" {
+		return true
+	}
+	if !isObject(from) || !isObject(to) || to == "N" {
+		return false
+	}
+	if from == "N" || from == "R" || to == "R" {
+		return true
+	}
+	return this.refAssignable(from, to)
+}
+
+func (this *typeChecker) refAssignable(from, to string) bool {
+	if from == to || to == "Ljava/lang/Object;" {
+		return true
+	}
+	if to[0] == 'L' {
+		name := to[1 : len(to)-1]
+		if from[0] == '[' {
+			return name == "java/lang/Cloneable" || name == "java/io/Serializable"
+		}
+		if this.isInterface(name) {
+			return true
+		}
+		is, known := this.set.IsSubtype(from[1:len(from)-1], name)
+		return is || !known
+	}
+	if from[0] != '[' {
+		return false
+	}
+	from, to = from[1:], to[1:]
+	if len(from) == 1 || len(to) == 1 {
+		return from == to
+	}
+	return this.refAssignable(from, to)
+}
+
+// isInterface reports whether a class is an interface, or unknown.
+func (this *typeChecker) isInterface(name string) bool {
+	if name == this.verifier.name {
+		return this.class.access_flags&ACC_INTERFACE != 0
+	}
+	d := this.set.Lookup(name)
+	return d == nil || d.class.access_flags&ACC_INTERFACE != 0
+}
+
+// merge joins two types where control flow meets; T means the slot is
+// unusable.
+func (this *typeChecker) merge(a, b string) string {
+	switch {
+	case a == b:
+		return a
+	case !isObject(a) || !isObject(b):
+		return "T"
+	case a == "N":
+		return b
+	case b == "N":
+		return a
+	}
+	return "R"
+}
+
+func (this *typeChecker) check() {
+	this.index = make(map[int]int, len(this.ins))
+	for i, in := range this.ins {
+		this.index[in.pc] = i
+	}
+	var initial []string
+	if !this.static {
+		if this.name == "<init>" && this.verifier.name != "java/lang/Object" {
+			initial = append(initial, "U")
+		} else {
+			initial = append(initial, refType(this.verifier.name))
+		}
+	}
+	for _, p := range this.params {
+		initial = append(initial, verificationType(p))
+	}
+	locals, err := this.expand(initial, int(this.ca.max_locals), true)
+	if err != nil {
+		return
+	}
+	start := &typeFrame{locals: locals}
+	table := findAttribute(this.cp, this.ca.attributes, "StackMapTable")
+	if this.major >= 51 || this.major == 50 && table != nil {
+		var frames map[int]*typeFrame
+		if table != nil {
+			if frames = this.stackMap(table, initial); frames == nil {
+				return
+			}
+		}
+		this.typecheck(start, frames)
+		return
+	}
+	this.infer(start)
+}
+
+// expand gives the slots of a list of verification types, the locals
+// padded with top to max_locals.
+func (this *typeChecker) expand(types []string, max int, locals bool) ([]string, error) {
+	var slots []string
+	for _, t := range types {
+		slots = append(slots, t)
+		if isCategory2(t) {
+			slots = append(slots, "T")
+		}
+	}
+	if len(slots) > max {
+		if locals {
+			return nil, fmt.Errorf("%d local slots, max_locals is %d", len(slots), max)
+		}
+		return nil, fmt.Errorf("%d stack slots, max_stack is %d", len(slots), max)
+	}
+	for locals && len(slots) < max {
+		slots = append(slots, "T")
+	}
+	return slots, nil
+}
+
+// stackMap decodes the frames of a StackMapTable by offset, or reports
+// what is wrong with it and returns nil.
+func (this *typeChecker) stackMap(a *AttributeInfo, initial []string) map[int]*typeFrame {
+	r := &infoReader{info: a.info}
+	frames := make(map[int]*typeFrame)
+	locals := initial
+	offset := -1
+	n := r.u2()
+	for i := uint16(0); i < n && r.err == nil; i++ {
+		var stack []string
+		frame := r.u1()
+		delta := int(frame)
+		switch {
+		case frame < 64:
+		case frame < 128:
+			delta -= 64
+			stack = this.types(r, 1)
+		case frame < 247:
+			r.err = fmt.Errorf("reserved frame type %d", frame)
+		case frame == 247:
+			delta = int(r.u2())
+			stack = this.types(r, 1)
+		case frame < 251:
+			delta = int(r.u2())
+			k := 251 - int(frame)
+			if k > len(locals) {
+				r.err = fmt.Errorf("frame chops %d of %d locals", k, len(locals))
+				break
+			}
+			locals = locals[: len(locals)-k : len(locals)-k]
+		case frame == 251:
+			delta = int(r.u2())
+		case frame < 255:
+			delta = int(r.u2())
+			locals = append(locals[:len(locals):len(locals)], this.types(r, int(frame)-251)...)
+		default:
+			delta = int(r.u2())
+			locals = this.types(r, int(r.u2()))
+			stack = this.types(r, int(r.u2()))
+		}
+		if r.err != nil {
+			break
+		}
+		offset += delta + 1
+		if i == 0 {
+			offset = delta
+		}
+		if _, ok := this.index[offset]; !ok {
+			this.report(offset, "stack map frame is not at an instruction")
+			return nil
+		}
+		f := &typeFrame{}
+		var err error
+		if f.locals, err = this.expand(locals, int(this.ca.max_locals), true); err == nil {
+			f.stack, err = this.expand(stack, int(this.ca.max_stack), false)
+		}
+		if err != nil {
+			this.report(offset, "stack map frame has %v", err)
+			return nil
+		}
+		frames[offset] = f
+	}
+	if r.err == nil && r.pos != len(r.info) {
+		r.err = fmt.Errorf("%d bytes after the frames", len(r.info)-r.pos)
+	}
+	if r.err != nil {
+		this.report(-1, "StackMapTable: %v", r.err)
+		return nil
+	}
+	return frames
+}
+
+// types reads n verification_type_info items.
+func (this *typeChecker) types(r *infoReader, n int) []string {
+	var types []string
+	for i := 0; i < n && r.err == nil; i++ {
+		switch tag := r.u1(); tag {
+		case 0, 1, 2, 3, 4, 5, 6:
+			types = append(types, [...]string{"T", "I", "F", "D", "J", "N", "U"}[tag])
+		case 7:
+			index := r.u2()
+			if !this.entry(index, CONSTANT_Class) {
+				r.err = fmt.Errorf("object type is %s", this.describe(index))
+				break
+			}
+			types = append(types, refType(this.className(index)))
+		case 8:
+			pc := int(r.u2())
+			if i, ok := this.index[pc]; !ok || this.ins[i].opcode != 0xbb {
+				r.err = fmt.Errorf("uninitialized type at %d, which is not a new", pc)
+				break
+			}
+			types = append(types, "u"+strconv.Itoa(pc))
+		default:
+			r.err = fmt.Errorf("unknown verification type %d", tag)
+		}
+	}
+	return types
+}
+
+// frameError says why frame from may not flow into frame to.
+func (this *typeChecker) frameError(from, to *typeFrame) error {
+	if len(from.stack) != len(to.stack) {
+		return fmt.Errorf("stack has %d slots, the frame %d", len(from.stack), len(to.stack))
+	}
+	for i, t := range from.stack {
+		if !this.assignable(t, to.stack[i]) {
+			return fmt.Errorf("stack slot %d is %s, the frame has %s", i, typeName(t), typeName(to.stack[i]))
+		}
+	}
+	for i, t := range from.locals {
+		if !this.assignable(t, to.locals[i]) {
+			return fmt.Errorf("local %d is %s, the frame has %s", i, typeName(t), typeName(to.locals[i]))
+		}
+	}
+	return nil
+}
+
+// catchType is the type of the exception a handler receives.
+func (this *typeChecker) catchType(e ExceptionTable) string {
+	if e.catch_type == 0 {
+		return "Ljava/lang/Throwable;"
+	}
+	return refType(this.className(e.catch_type))
+}
+
+// typecheck checks the code against the frames of the StackMapTable
+// (JVMS §4.10.1): a frame must follow every unconditional branch and be
+// at every branch target and exception handler, and the types must flow
+// into it.
+func (this *typeChecker) typecheck(current *typeFrame, frames map[int]*typeFrame) {
+	live := true
+	for i := range this.ins {
+		in := &this.ins[i]
+		if f := frames[in.pc]; f != nil {
+			if live {
+				if err := this.frameError(current, f); err != nil {
+					this.report(in.pc, "%v", err)
+					return
+				}
+			}
+			current, live = f.copy(), true
+		} else if !live {
+			this.report(in.pc, "no stack map frame after an unconditional branch")
+			return
+		}
+		for _, e := range this.ca.exception_table {
+			if in.pc < int(e.start_pc) || in.pc >= int(e.end_pc) {
+				continue
+			}
+			f := frames[int(e.handler_pc)]
+			if f == nil {
+				this.report(int(e.handler_pc), "no stack map frame at exception handler")
+				return
+			}
+			caught := &typeFrame{locals: current.locals, stack: []string{this.catchType(e)}}
+			if err := this.frameError(caught, f); err != nil {
+				this.report(in.pc, "exception handler %d: %v", e.handler_pc, err)
+				return
+			}
+		}
+		targets, unconditional, err := this.execute(current, in)
+		if err != nil {
+			this.report(in.pc, "%v", err)
+			return
+		}
+		for _, t := range targets {
+			f := frames[t]
+			if f == nil {
+				this.report(in.pc, "no stack map frame at branch target %d", t)
+				return
+			}
+			if err := this.frameError(current, f); err != nil {
+				this.report(in.pc, "branch to %d: %v", t, err)
+				return
+			}
+		}
+		live = !unconditional
+	}
+	if live {
+		this.report(this.ins[len(this.ins)-1].pc, "execution falls off the end of the code")
+	}
+}
+
+// infer runs the type inference of classes without a StackMapTable,
+// merging the frames where control flow meets.
+func (this *typeChecker) infer(start *typeFrame) {
+	states := make([]*typeFrame, len(this.ins))
+	queued := make([]bool, len(this.ins))
+	var work []int
+	flow := func(i int, f *typeFrame) bool {
+		old := states[i]
+		if old == nil {
+			states[i] = f.copy()
+		} else {
+			if len(old.stack) != len(f.stack) {
+				this.report(this.ins[i].pc, "stack has %d slots on one path and %d on another", len(old.stack), len(f.stack))
+				return false
+			}
+			changed := false
+			for j, t := range f.stack {
+				m := this.merge(old.stack[j], t)
+				if m == "T" && t != "T" {
+					this.report(this.ins[i].pc, "stack slot %d is %s on one path and %s on another", j, typeName(old.stack[j]), typeName(t))
+					return false
+				}
+				changed = changed || m != old.stack[j]
+				old.stack[j] = m
+			}
+			for j, t := range f.locals {
+				m := this.merge(old.locals[j], t)
+				changed = changed || m != old.locals[j]
+				old.locals[j] = m
+			}
+			if !changed {
+				return true
+			}
+		}
+		if !queued[i] {
+			queued[i] = true
+			work = append(work, i)
+		}
+		return true
+	}
+	flow(0, start)
+	for budget := 64 * len(this.ins); len(work) > 0 && budget > 0; budget-- {
+		i := work[len(work)-1]
+		work = work[:len(work)-1]
+		queued[i] = false
+		in := &this.ins[i]
+		f := states[i].copy()
+		for _, e := range this.ca.exception_table {
+			if in.pc >= int(e.start_pc) && in.pc < int(e.end_pc) {
+				caught := &typeFrame{locals: f.locals, stack: []string{this.catchType(e)}}
+				if !flow(this.index[int(e.handler_pc)], caught) {
+					return
+				}
+			}
+		}
+		targets, unconditional, err := this.execute(f, in)
+		if err != nil {
+			this.report(in.pc, "%v", err)
+			return
+		}
+		if !unconditional {
+			if i+1 == len(this.ins) {
+				this.report(in.pc, "execution falls off the end of the code")
+				return
+			}
+			if !flow(i+1, f) {
+				return
+			}
+		}
+		for _, t := range targets {
+			if !flow(this.index[t], f) {
+				return
+			}
+		}
+	}
+}
+
+// typeEffects gives the operand types that simple instructions pop and
+// push, in stack order, as "pops>pushes"; A is any reference.
+var typeEffects = func() map[byte]string {
+	e := map[byte]string{
+		0x00: ">", 0x01: ">N", 0x09: ">J", 0x0a: ">J", 0x0e: ">D", 0x0f: ">D",
+		0x10: ">I", 0x11: ">I",
+		0x78: "II>I", 0x79: "JI>J", 0x7a: "II>I", 0x7b: "JI>J", 0x7c: "II>I", 0x7d: "JI>J",
+		0x7e: "II>I", 0x7f: "JJ>J", 0x80: "II>I", 0x81: "JJ>J", 0x82: "II>I", 0x83: "JJ>J",
+		0x85: "I>J", 0x86: "I>F", 0x87: "I>D", 0x88: "J>I", 0x89: "J>F", 0x8a: "J>D",
+		0x8b: "F>I", 0x8c: "F>J", 0x8d: "F>D", 0x8e: "D>I", 0x8f: "D>J", 0x90: "D>F",
+		0x91: "I>I", 0x92: "I>I", 0x93: "I>I",
+		0x94: "JJ>I", 0x95: "FF>I", 0x96: "FF>I", 0x97: "DD>I", 0x98: "DD>I",
+		0xa5: "AA>", 0xa6: "AA>", 0xa7: ">", 0xaa: "I>", 0xab: "I>",
+		0xc2: "A>", 0xc3: "A>", 0xc6: "A>", 0xc7: "A>", 0xc8: ">",
+	}
+	for op := byte(0x02); op <= 0x08; op++ {
+		e[op] = ">I"
+	}
+	for op := byte(0x0b); op <= 0x0d; op++ {
+		e[op] = ">F"
+	}
+	for k, t := range []string{"I", "J", "F", "D"} {
+		for _, base := range []byte{0x60, 0x64, 0x68, 0x6c, 0x70} {
+			e[base+byte(k)] = t + t + ">" + t
+		}
+		e[0x74+byte(k)] = t + ">" + t
+	}
+	for op := byte(0x99); op <= 0x9e; op++ {
+		e[op] = "I>"
+	}
+	for op := byte(0x9f); op <= 0xa4; op++ {
+		e[op] = "II>"
+	}
+	return e
+}()
+
+// typeMachine runs one instruction on a frame, keeping the first error.
+type typeMachine struct {
+	*typeChecker
+	f   *typeFrame
+	err error
+}
+
+func (this *typeMachine) fail(format string, args ...interface{}) {
+	if this.err == nil {
+		this.err = fmt.Errorf(format, args...)
+	}
+}
+
+// pop takes a value of type want: I, F, J, D, A for any reference, or a
+// class or array type.
+func (this *typeMachine) pop(want string) string {
+	if this.err != nil {
+		return want
+	}
+	s := this.f.stack
+	size := 1
+	if isCategory2(want) {
+		size = 2
+	}
+	if len(s) < size {
+		this.fail("expected %s, the stack is empty", typeName(want))
+		return want
+	}
+	got := s[len(s)-size]
+	var ok bool
+	switch want {
+	case "I", "F":
+		ok = got == want
+	case "J", "D":
+		ok = got == want && s[len(s)-1] == "T"
+	case "A":
+		ok = isReference(got)
+	default:
+		ok = this.assignable(got, want)
+	}
+	if !ok {
+		this.fail("expected %s, found %s", typeName(want), typeName(got))
+		return want
+	}
+	this.f.stack = s[:len(s)-size]
+	return got
+}
+
+func (this *typeMachine) push(t string) {
+	if this.err != nil {
+		return
+	}
+	this.f.stack = append(this.f.stack, t)
+	if isCategory2(t) {
+		this.f.stack = append(this.f.stack, "T")
+	}
+	if len(this.f.stack) > int(this.ca.max_stack) {
+		this.fail("stack exceeds max_stack %d", this.ca.max_stack)
+	}
+}
+
+func (this *typeMachine) load(index int, want string) {
+	l := this.f.locals
+	if index >= len(l) || isCategory2(want) && index+1 >= len(l) {
+		this.fail("local %d is out of range", index)
+		return
+	}
+	got := l[index]
+	ok := got == want
+	switch {
+	case want == "A":
+		ok = isReference(got)
+	case isCategory2(want):
+		ok = ok && l[index+1] == "T"
+	}
+	if !ok {
+		this.fail("local %d is %s, expected %s", index, typeName(got), typeName(want))
+		return
+	}
+	this.push(got)
+}
+
+func (this *typeMachine) store(index int, t string) {
+	l := this.f.locals
+	if index >= len(l) || isCategory2(t) && index+1 >= len(l) {
+		this.fail("local %d is out of range", index)
+		return
+	}
+	if index > 0 && isCategory2(l[index-1]) {
+		l[index-1] = "T"
+	}
+	l[index] = t
+	if isCategory2(t) {
+		l[index+1] = "T"
+	}
+}
+
+// cut checks that taking the top n slots off the stack, for each n,
+// splits no long or double.
+func (this *typeMachine) cut(ns ...int) bool {
+	s := this.f.stack
+	for _, n := range ns {
+		if len(s) < n {
+			this.fail("stack has %d slots, needs %d", len(s), n)
+			return false
+		}
+		if s[len(s)-n] == "T" {
+			this.fail("splits a long or double")
+			return false
+		}
+	}
+	return this.err == nil
+}
+
+// shuffle copies the top m slots below the top n.
+func (this *typeMachine) shuffle(m, n int) {
+	if !this.cut(m, n) {
+		return
+	}
+	s := this.f.stack
+	top := append([]string{}, s[len(s)-m:]...)
+	rest := append([]string{}, s[len(s)-n:]...)
+	this.f.stack = append(append(s[:len(s)-n], top...), rest...)
+	if len(this.f.stack) > int(this.ca.max_stack) {
+		this.fail("stack exceeds max_stack %d", this.ca.max_stack)
+	}
+}
+
+// replace initializes an object: every copy of t becomes type with.
+func (this *typeMachine) replace(t, with string) {
+	for _, slots := range [][]string{this.f.locals, this.f.stack} {
+		for i := range slots {
+			if slots[i] == t {
+				slots[i] = with
+			}
+		}
+	}
+}
+
+// array pops an index and an array whose component type is one of
+// components, returning the component type, N for a null array.
+func (this *typeMachine) array(components ...string) string {
+	this.pop("I")
+	a := this.pop("A")
+	if this.err != nil || a == "N" {
+		return "N"
+	}
+	if !strings.HasPrefix(a, "[") {
+		this.fail("expected an array, found %s", typeName(a))
+		return "N"
+	}
+	for _, c := range components {
+		if a[1:] == c || c == "A" && isObject(a[1:]) {
+			return a[1:]
+		}
+	}
+	this.fail("expected an array of %s, found %s", strings.Join(components, " or "), typeName(a))
+	return "N"
+}
+
+func (this *typeMachine) returns(name, want string) {
+	ret := verificationType(this.ret)
+	switch {
+	case want == "":
+		if ret != "V" {
+			this.fail("%s in a method returning %s", name, this.ret)
+		}
+		for _, t := range this.f.locals {
+			if t == "U" {
+				this.fail("constructor returns before calling super or this")
+				break
+			}
+		}
+	case want == "A":
+		if !isObject(ret) {
+			this.fail("%s in a method returning %s", name, this.ret)
+			return
+		}
+		this.pop(ret)
+	case ret != want:
+		this.fail("%s in a method returning %s", name, this.ret)
+	default:
+		this.pop(want)
+	}
+}
+
+// invoke pops the arguments and the receiver and pushes the result.
+func (this *typeMachine) invoke(in *Instruction) {
+	op := in.opcode
+	var owner, name, desc string
+	if op == 0xba {
+		d := CONSTANT_InvokeDynamic_info{this.cp[in.Index()-1]}
+		name, desc, _ = nameAndTypeAt(this.cp, d.NameAndTypeIndex())
+	} else {
+		owner, name, desc, _ = memberRefAt(this.cp, in.Index())
+	}
+	params, ret, _ := parseMethodDescriptor(desc)
+	for i := len(params) - 1; i >= 0; i-- {
+		this.pop(verificationType(params[i]))
+	}
+	switch {
+	case op == 0xb8 || op == 0xba:
+	case op == 0xb7 && name == "<init>":
+		recv := this.pop("A")
+		if this.err != nil {
+			return
+		}
+		var with string
+		switch {
+		case recv == "U":
+			super := this.className(this.class.super_class)
+			if owner != this.verifier.name && owner != super {
+				this.fail("constructor calls %s.<init>, not one of its own class or super class", owner)
+				return
+			}
+			with = refType(this.verifier.name)
+		case recv[0] == 'u':
+			pc, _ := strconv.Atoi(recv[1:])
+			class := this.className(this.ins[this.index[pc]].Index())
+			if class != owner {
+				this.fail("%s.<init> on a new %s", owner, class)
+				return
+			}
+			with = refType(class)
+		default:
+			this.fail("<init> on initialized %s", typeName(recv))
+			return
+		}
+		this.replace(recv, with)
+	case op == 0xb9:
+		this.pop("A")
+	default:
+		this.pop(refType(owner))
+	}
+	if ret != "V" {
+		this.push(verificationType(ret))
+	}
+}
+
+// constantType is the type ldc pushes for a constant.
+func (this *typeChecker) constantType(i uint16) string {
+	switch c := this.cp[i-1]; c.tag {
+	case CONSTANT_Integer:
+		return "I"
+	case CONSTANT_Float:
+		return "F"
+	case CONSTANT_Long:
+		return "J"
+	case CONSTANT_Double:
+		return "D"
+	case CONSTANT_String:
+		return "Ljava/lang/String;"
+	case CONSTANT_Class:
+		return "Ljava/lang/Class;"
+	case CONSTANT_MethodType:
+		return "Ljava/lang/invoke/MethodType;"
+	case CONSTANT_MethodHandle:
+		return "Ljava/lang/invoke/MethodHandle;"
+	default:
+		d := CONSTANT_Dynamic_info{c}
+		_, desc, _ := nameAndTypeAt(this.cp, d.NameAndTypeIndex())
+		return verificationType(desc)
+	}
+}
+
+// execute runs an instruction on frame f and returns the targets it may
+// branch to and whether it never continues with the next instruction.
+func (this *typeChecker) execute(f *typeFrame, in *Instruction) (targets []int, unconditional bool, err error) {
+	m := &typeMachine{typeChecker: this, f: f}
+	op, pc := in.opcode, in.pc
+	u4 := func(i int) int {
+		return pc + int(int32(binary.BigEndian.Uint32(in.operands[i:])))
+	}
+	kinds := []string{"I", "J", "F", "D", "A"}
+	if effect, ok := typeEffects[op]; ok {
+		i := strings.IndexByte(effect, '>')
+		for j := i - 1; j >= 0; j-- {
+			m.pop(effect[j : j+1])
+		}
+		for j := i + 1; j < len(effect); j++ {
+			m.push(effect[j : j+1])
+		}
+	}
+	switch {
+	case op >= 0x99 && op <= 0xa8 || op == 0xc6 || op == 0xc7:
+		targets = []int{pc + int(int16(in.Index()))}
+		unconditional = op == 0xa7
+	case op == 0xc8:
+		targets, unconditional = []int{u4(0)}, true
+	case op == 0xaa || op == 0xab:
+		pad := 3 - pc%4
+		targets, unconditional = []int{u4(pad)}, true
+		if op == 0xaa {
+			for i := pad + 12; i < len(in.operands); i += 4 {
+				targets = append(targets, u4(i))
+			}
+		} else {
+			for i := pad + 12; i < len(in.operands); i += 8 {
+				targets = append(targets, u4(i))
+			}
+		}
+	case op == 0x12 || op == 0x13 || op == 0x14:
+		index := in.Index()
+		if op == 0x12 {
+			index = uint16(in.operands[0])
+		}
+		m.push(this.constantType(index))
+	case op >= 0x15 && op <= 0x19:
+		m.load(int(in.operands[0]), kinds[op-0x15])
+	case op >= 0x1a && op <= 0x2d:
+		m.load(int(op-0x1a)%4, kinds[(op-0x1a)/4])
+	case op >= 0x36 && op <= 0x3a:
+		m.store(int(in.operands[0]), m.pop(kinds[op-0x36]))
+	case op >= 0x3b && op <= 0x4e:
+		m.store(int(op-0x3b)%4, m.pop(kinds[(op-0x3b)/4]))
+	case op == 0x84:
+		m.load(int(in.operands[0]), "I")
+		m.pop("I")
+	case op == 0xc4:
+		index := int(binary.BigEndian.Uint16(in.operands[1:]))
+		switch sub := in.operands[0]; {
+		case sub == 0x84:
+			m.load(index, "I")
+			m.pop("I")
+		case sub >= 0x15 && sub <= 0x19:
+			m.load(index, kinds[sub-0x15])
+		default:
+			m.store(index, m.pop(kinds[sub-0x36]))
+		}
+	case op >= 0x2e && op <= 0x35:
+		components := [][]string{{"I"}, {"J"}, {"F"}, {"D"}, {"A"}, {"B", "Z"}, {"C"}, {"S"}}[op-0x2e]
+		c := m.array(components...)
+		if op == 0x32 {
+			m.push(c)
+		} else {
+			m.push(verificationType(components[0]))
+		}
+	case op >= 0x4f && op <= 0x56:
+		components := [][]string{{"I"}, {"J"}, {"F"}, {"D"}, {"A"}, {"B", "Z"}, {"C"}, {"S"}}[op-0x4f]
+		m.pop(verificationType(components[0]))
+		m.array(components...)
+	case op == 0x57 || op == 0x58:
+		if n := int(op) - 0x56; m.cut(n) {
+			f.stack = f.stack[:len(f.stack)-n]
+		}
+	case op >= 0x59 && op <= 0x5e:
+		m.shuffle([]int{1, 1, 1, 2, 2, 2}[op-0x59], []int{1, 2, 3, 2, 3, 4}[op-0x59])
+	case op == 0x5f:
+		if m.cut(1, 2) {
+			s := f.stack
+			s[len(s)-1], s[len(s)-2] = s[len(s)-2], s[len(s)-1]
+		}
+	case op >= 0xac && op <= 0xb1:
+		m.returns(in.Name(), []string{"I", "J", "F", "D", "A", ""}[op-0xac])
+		unconditional = true
+	case op >= 0xb2 && op <= 0xb5:
+		owner, _, desc, _ := memberRefAt(this.cp, in.Index())
+		t := verificationType(desc)
+		switch op {
+		case 0xb2:
+			m.push(t)
+		case 0xb3:
+			m.pop(t)
+		case 0xb4:
+			m.pop(refType(owner))
+			m.push(t)
+		case 0xb5:
+			m.pop(t)
+			if len(f.stack) > 0 && f.stack[len(f.stack)-1] == "U" && owner == this.verifier.name {
+				m.pop("A")
+				break
+			}
+			m.pop(refType(owner))
+		}
+	case op >= 0xb6 && op <= 0xba:
+		m.invoke(in)
+	case op == 0xbb:
+		m.push("u" + strconv.Itoa(pc))
+	case op == 0xbc:
+		m.pop("I")
+		m.push("[" + newarrayTypes[in.operands[0]])
+	case op == 0xbd:
+		m.pop("I")
+		m.push("[" + refType(this.className(in.Index())))
+	case op == 0xbe:
+		if a := m.pop("A"); m.err == nil && a != "N" && !strings.HasPrefix(a, "[") {
+			m.fail("arraylength of %s", typeName(a))
+		}
+		m.push("I")
+	case op == 0xbf:
+		m.pop("Ljava/lang/Throwable;")
+		unconditional = true
+	case op == 0xc0:
+		if a := m.pop("A"); m.err == nil && !isObject(a) {
+			m.fail("checkcast of %s", typeName(a))
+		}
+		m.push(refType(this.className(in.Index())))
+	case op == 0xc1:
+		m.pop("A")
+		m.push("I")
+	case op == 0xc5:
+		for i := 0; i < int(in.operands[2]); i++ {
+			m.pop("I")
+		}
+		m.push(refType(this.className(in.Index())))
+	}
+	return targets, unconditional, m.err
+}
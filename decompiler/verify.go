@@ -0,0 +1,766 @@
+package decompiler
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strings"
+)
+
+// Violation is a JVMS §4 constraint that a class file breaks. Member is
+// the field name or the method name and descriptor, empty for the class
+// itself; Pc is the code offset, or -1.
+type Violation struct {
+	Member  string
+	Pc      int
+	Message string
+}
+
+func (this Violation) Error() string {
+	where := this.Member
+	if this.Pc >= 0 {
+		where += fmt.Sprintf(" @%d", this.Pc)
+	}
+	if where == "" {
+		return this.Message
+	}
+	return where + ": " + this.Message
+}
+
+type verifier struct {
+	class      *ClassFile
+	cp         []CpInfo
+	set        *ClassSet
+	name       string
+	major      uint16
+	bootstraps int
+	// typed is set when the constant pool is sound enough to take types
+	// from
+	typed      bool
+	member     string
+	violations []Violation
+}
+
+// Verify checks the class against the structural constraints of JVMS §4:
+// constant pool tags and the entries they refer to, names, descriptors,
+// access flag combinations, and for each method the instructions, their
+// operands and branch targets, the exception table, max_stack and
+// max_locals, and the types of the StackMapTable, or for classes older
+// than version 50 the types inferred from the code. Classes of the set
+// answer subtype questions; where a class is missing the check assumes
+// the code is right. Methods using jsr and ret are not type checked, nor
+// any method when the constant pool is broken.
+func (this *decompiler) Verify() (violations []Violation, err error) {
+	defer recoverError(&err)
+	v := &verifier{
+		class:      &this.class,
+		cp:         this.class.constant_pool,
+		set:        this.set,
+		major:      this.class.major_version,
+		bootstraps: -1,
+	}
+	v.name, _ = this.Name()
+	v.verify()
+	return v.violations, nil
+}
+
+func (this *verifier) report(pc int, format string, args ...interface{}) {
+	this.violations = append(this.violations, Violation{this.member, pc, fmt.Sprintf(format, args...)})
+}
+
+func (this *verifier) verify() {
+	if a := findAttribute(this.cp, this.class.attributes, "BootstrapMethods"); a != nil {
+		if bm, err := a.ToBootstrapMethodsAttribute(); err == nil {
+			this.bootstraps = len(bm.bootstrap_methods)
+		}
+	}
+	this.constantPool()
+	this.typed = len(this.violations) == 0
+	this.classHeader()
+	seen := make(map[string]bool)
+	for i := range this.class.fields {
+		f := &this.class.fields[i]
+		this.member = f.Name(this.cp)
+		if key := f.Name(this.cp) + " " + f.Descriptor(this.cp); seen[key] {
+			this.report(-1, "duplicate field")
+		} else {
+			seen[key] = true
+		}
+		this.field(f)
+	}
+	seen = make(map[string]bool)
+	for i := range this.class.methods {
+		m := &this.class.methods[i]
+		this.member = m.Name(this.cp) + m.Descriptor(this.cp)
+		if seen[this.member] {
+			this.report(-1, "duplicate method")
+		}
+		seen[this.member] = true
+		this.method(m)
+	}
+	this.member = ""
+}
+
+// tagName names a constant pool tag for messages.
+func tagName(tag ConstantTag) string {
+	if name, ok := constantTagNames[tag]; ok {
+		return name
+	}
+	return fmt.Sprintf("tag %d", tag)
+}
+
+// entry reports whether index i is an entry with one of the tags.
+func (this *verifier) entry(i uint16, tags ...ConstantTag) bool {
+	if i == 0 || int(i) > len(this.cp) {
+		return false
+	}
+	for _, tag := range tags {
+		if this.cp[i-1].tag == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// refers checks that entry from refers to an entry with one of the tags.
+func (this *verifier) refers(from int, to uint16, tags ...ConstantTag) bool {
+	if this.entry(to, tags...) {
+		return true
+	}
+	var names []string
+	for _, tag := range tags {
+		names = append(names, tagName(tag))
+	}
+	this.report(-1, "constant pool entry %d refers to %s, expected %s", from, this.describe(to), strings.Join(names, " or "))
+	return false
+}
+
+// describe names the entry at index i for messages.
+func (this *verifier) describe(i uint16) string {
+	if i == 0 || int(i) > len(this.cp) {
+		return fmt.Sprintf("index %d out of range", i)
+	}
+	if this.cp[i-1].tag == 0 {
+		return fmt.Sprintf("%d, the second half of a Long or Double", i)
+	}
+	return fmt.Sprintf("%d, a %s", i, tagName(this.cp[i-1].tag))
+}
+
+func (this *verifier) utf8(i uint16) string {
+	s, _ := utf8At(this.cp, i)
+	return s
+}
+
+// minimum versions of the constant pool tags
+var constantVersions = map[ConstantTag]uint16{
+	CONSTANT_MethodHandle:  51,
+	CONSTANT_MethodType:    51,
+	CONSTANT_InvokeDynamic: 51,
+	CONSTANT_Module:        53,
+	CONSTANT_Package:       53,
+	CONSTANT_Dynamic:       55,
+}
+
+func (this *verifier) constantPool() {
+	for n, c := range this.cp {
+		i := n + 1
+		if c.tag == 0 {
+			continue
+		}
+		if v, ok := constantVersions[c.tag]; ok && this.major < v {
+			this.report(-1, "constant pool entry %d: %s needs class file version %d", i, tagName(c.tag), v)
+		}
+		u2 := func(at int) uint16 {
+			return binary.BigEndian.Uint16(c.info[at:])
+		}
+		switch c.tag {
+		case CONSTANT_Utf8:
+			if err := checkModifiedUtf8(c.info[2:]); err != "" {
+				this.report(-1, "constant pool entry %d: %s", i, err)
+			}
+		case CONSTANT_Class:
+			if this.refers(i, u2(0), CONSTANT_Utf8) {
+				if name := this.utf8(u2(0)); !validClassName(name) && !validArray(name) {
+					this.report(-1, "constant pool entry %d: invalid class name %q", i, name)
+				}
+			}
+		case CONSTANT_String:
+			this.refers(i, u2(0), CONSTANT_Utf8)
+		case CONSTANT_MethodType:
+			if this.refers(i, u2(0), CONSTANT_Utf8) && !validMethodDescriptor(this.utf8(u2(0))) {
+				this.report(-1, "constant pool entry %d: invalid method descriptor %q", i, this.utf8(u2(0)))
+			}
+		case CONSTANT_Module, CONSTANT_Package:
+			this.refers(i, u2(0), CONSTANT_Utf8)
+			if this.class.access_flags&ACC_MODULE == 0 {
+				this.report(-1, "constant pool entry %d: %s outside a module-info class", i, tagName(c.tag))
+			}
+		case CONSTANT_NameAndType:
+			this.refers(i, u2(0), CONSTANT_Utf8)
+			this.refers(i, u2(2), CONSTANT_Utf8)
+		case CONSTANT_Fieldref, CONSTANT_Methodref, CONSTANT_InterfaceMethodref:
+			this.refers(i, u2(0), CONSTANT_Class)
+			if this.refers(i, u2(2), CONSTANT_NameAndType) {
+				this.memberRef(i, c.tag, u2(2))
+			}
+		case CONSTANT_MethodHandle:
+			this.methodHandle(i, &CONSTANT_MethodHandle_info{c})
+		case CONSTANT_Dynamic, CONSTANT_InvokeDynamic:
+			if int(u2(0)) >= this.bootstraps {
+				this.report(-1, "constant pool entry %d: bootstrap method %d does not exist", i, u2(0))
+			}
+			if this.refers(i, u2(2), CONSTANT_NameAndType) {
+				name, desc, _ := nameAndTypeAt(this.cp, u2(2))
+				valid := validFieldDescriptor(desc)
+				if c.tag == CONSTANT_InvokeDynamic {
+					valid = validMethodDescriptor(desc)
+				}
+				if !valid || !validUnqualifiedName(name, false) {
+					this.report(-1, "constant pool entry %d: invalid name %q or descriptor %q", i, name, desc)
+				}
+			}
+		}
+	}
+}
+
+// memberRef checks the name and descriptor of a field or method reference.
+func (this *verifier) memberRef(i int, tag ConstantTag, nt uint16) {
+	name, desc, err := nameAndTypeAt(this.cp, nt)
+	if err != nil {
+		this.report(-1, "constant pool entry %d: %v", i, err)
+		return
+	}
+	if tag == CONSTANT_Fieldref {
+		if !validUnqualifiedName(name, false) || !validFieldDescriptor(desc) {
+			this.report(-1, "constant pool entry %d: invalid field %q %q", i, name, desc)
+		}
+		return
+	}
+	switch {
+	case !validUnqualifiedName(name, true) || !validMethodDescriptor(desc):
+		this.report(-1, "constant pool entry %d: invalid method %q %q", i, name, desc)
+	case name == "<clinit>":
+		this.report(-1, "constant pool entry %d: reference to <clinit>", i)
+	case name == "<init>" && !strings.HasSuffix(desc, ")V"):
+		this.report(-1, "constant pool entry %d: <init> must return void", i)
+	}
+}
+
+func (this *verifier) methodHandle(i int, mh *CONSTANT_MethodHandle_info) {
+	kind, ref := mh.ReferenceKind(), mh.ReferenceIndex()
+	var tags []ConstantTag
+	switch kind {
+	case REF_getField, REF_getStatic, REF_putField, REF_putStatic:
+		tags = []ConstantTag{CONSTANT_Fieldref}
+	case REF_invokeVirtual, REF_newInvokeSpecial:
+		tags = []ConstantTag{CONSTANT_Methodref}
+	case REF_invokeStatic, REF_invokeSpecial:
+		tags = []ConstantTag{CONSTANT_Methodref}
+		if this.major >= 52 {
+			tags = append(tags, CONSTANT_InterfaceMethodref)
+		}
+	case REF_invokeInterface:
+		tags = []ConstantTag{CONSTANT_InterfaceMethodref}
+	default:
+		this.report(-1, "constant pool entry %d: unknown reference kind %d", i, kind)
+		return
+	}
+	if !this.refers(i, ref, tags...) || kind <= REF_putStatic {
+		return
+	}
+	_, name, _, _ := memberRefAt(this.cp, ref)
+	if kind == REF_newInvokeSpecial && name != "<init>" || kind != REF_newInvokeSpecial && strings.HasPrefix(name, "<") {
+		this.report(-1, "constant pool entry %d: reference kind %d cannot refer to %s", i, kind, name)
+	}
+}
+
+// checkModifiedUtf8 returns what is wrong with the bytes of a Utf8 entry.
+func checkModifiedUtf8(b []byte) string {
+	for i := 0; i < len(b); i++ {
+		switch c := b[i]; {
+		case c == 0 || c >= 0xf0:
+			return fmt.Sprintf("byte %#x at %d is not modified UTF-8", c, i)
+		case c >= 0xe0:
+			if i+2 >= len(b) || b[i+1]&0xc0 != 0x80 || b[i+2]&0xc0 != 0x80 {
+				return fmt.Sprintf("truncated modified UTF-8 sequence at %d", i)
+			}
+			i += 2
+		case c >= 0xc0:
+			if i+1 >= len(b) || b[i+1]&0xc0 != 0x80 {
+				return fmt.Sprintf("truncated modified UTF-8 sequence at %d", i)
+			}
+			i++
+		case c >= 0x80:
+			return fmt.Sprintf("byte %#x at %d is not modified UTF-8", c, i)
+		}
+	}
+	return ""
+}
+
+// validUnqualifiedName checks a field or method name (JVMS §4.2.2).
+func validUnqualifiedName(name string, method bool) bool {
+	if method && (name == "<init>" || name == "<clinit>") {
+		return true
+	}
+	if name == "" || strings.ContainsAny(name, ".;[/") {
+		return false
+	}
+	return !method || !strings.ContainsAny(name, "<>")
+}
+
+// validClassName checks an internal class name such as java/lang/String.
+func validClassName(name string) bool {
+	for _, part := range strings.Split(name, "/") {
+		if part == "" || strings.ContainsAny(part, ".;[") {
+			return false
+		}
+	}
+	return true
+}
+
+func validArray(desc string) bool {
+	return strings.HasPrefix(desc, "[") && validFieldDescriptor(desc)
+}
+
+func validFieldDescriptor(desc string) bool {
+	n, err := fieldDescriptorLen(desc)
+	if err != nil || n != len(desc) {
+		return false
+	}
+	dims := len(desc) - len(strings.TrimLeft(desc, "["))
+	if dims > 255 {
+		return false
+	}
+	return desc[dims] != 'L' || validClassName(desc[dims+1:len(desc)-1])
+}
+
+func validMethodDescriptor(desc string) bool {
+	params, ret, err := parseMethodDescriptor(desc)
+	if err != nil {
+		return false
+	}
+	for _, p := range params {
+		if !validFieldDescriptor(p) {
+			return false
+		}
+	}
+	return ret == "V" || validFieldDescriptor(ret)
+}
+
+// slots returns the local variable slots the parameters take.
+func slots(params []string) int {
+	n := 0
+	for _, p := range params {
+		n++
+		if p == "J" || p == "D" {
+			n++
+		}
+	}
+	return n
+}
+
+func (this *verifier) classHeader() {
+	class := this.class
+	f := class.access_flags
+	if !this.entry(class.this_class, CONSTANT_Class) {
+		this.report(-1, "this_class is %s, expected a Class", this.describe(class.this_class))
+	}
+	switch {
+	case f&ACC_MODULE != 0:
+		if f != ACC_MODULE {
+			this.report(-1, "module-info has flags other than module: %s", flagsText(classFlagNames, f))
+		}
+	case f&ACC_INTERFACE != 0:
+		if f&ACC_ABSTRACT == 0 {
+			this.report(-1, "interface is not abstract")
+		}
+		if bad := f & (ACC_FINAL | ACC_SUPER | ACC_ENUM); bad != 0 {
+			this.report(-1, "interface has flags %s", flagsText(classFlagNames, bad))
+		}
+	default:
+		if f&ACC_ANNOTATION != 0 {
+			this.report(-1, "annotation type is not an interface")
+		}
+		if f&ACC_FINAL != 0 && f&ACC_ABSTRACT != 0 {
+			this.report(-1, "class is both final and abstract")
+		}
+	}
+	switch {
+	case class.super_class == 0:
+		if this.name != "java/lang/Object" && f&ACC_MODULE == 0 {
+			this.report(-1, "class has no super class")
+		}
+	case !this.entry(class.super_class, CONSTANT_Class):
+		this.report(-1, "super_class is %s, expected a Class", this.describe(class.super_class))
+	case f&ACC_INTERFACE != 0 && this.className(class.super_class) != "java/lang/Object":
+		this.report(-1, "interface extends %s, not java/lang/Object", this.className(class.super_class))
+	case f&ACC_MODULE != 0:
+		this.report(-1, "module-info has a super class")
+	}
+	for _, i := range class.interfaces {
+		if !this.entry(i, CONSTANT_Class) {
+			this.report(-1, "interface is %s, expected a Class", this.describe(i))
+		}
+	}
+}
+
+func (this *verifier) className(i uint16) string {
+	name, _ := classNameAt(this.cp, i)
+	return name
+}
+
+// visibility reports more than one of public, private and protected.
+func (this *verifier) visibility(f AccessFlags) {
+	n := 0
+	for _, flag := range []AccessFlags{ACC_PUBLIC, ACC_PRIVATE, ACC_PROTECTED} {
+		if f&flag != 0 {
+			n++
+		}
+	}
+	if n > 1 {
+		this.report(-1, "more than one of public, private and protected")
+	}
+}
+
+func (this *verifier) field(f *FieldInfo) {
+	if !this.entry(f.name_index, CONSTANT_Utf8) || !validUnqualifiedName(this.utf8(f.name_index), false) {
+		this.report(-1, "invalid field name %s", this.describe(f.name_index))
+	}
+	desc := this.utf8(f.descriptor_index)
+	if !this.entry(f.descriptor_index, CONSTANT_Utf8) || !validFieldDescriptor(desc) {
+		this.report(-1, "invalid field descriptor %q", desc)
+		desc = ""
+	}
+	flags := f.access_flags
+	this.visibility(flags)
+	if flags&ACC_FINAL != 0 && flags&ACC_VOLATILE != 0 {
+		this.report(-1, "field is both final and volatile")
+	}
+	if this.class.access_flags&ACC_INTERFACE != 0 {
+		if flags&(ACC_PUBLIC|ACC_STATIC|ACC_FINAL) != ACC_PUBLIC|ACC_STATIC|ACC_FINAL || flags&^(ACC_PUBLIC|ACC_STATIC|ACC_FINAL|ACC_SYNTHETIC) != 0 {
+			this.report(-1, "interface field must be public static final, is %s", flagsText(fieldFlagNames, flags))
+		}
+	}
+	a := findAttribute(this.cp, f.attributes, "ConstantValue")
+	if a == nil || flags&ACC_STATIC == 0 || desc == "" {
+		return
+	}
+	if len(a.info) != 2 {
+		this.report(-1, "ConstantValue attribute of %d bytes", len(a.info))
+		return
+	}
+	want := map[byte]ConstantTag{'J': CONSTANT_Long, 'F': CONSTANT_Float, 'D': CONSTANT_Double,
+		'I': CONSTANT_Integer, 'S': CONSTANT_Integer, 'C': CONSTANT_Integer, 'B': CONSTANT_Integer, 'Z': CONSTANT_Integer}[desc[0]]
+	if desc == "Ljava/lang/String;" {
+		want = CONSTANT_String
+	}
+	if i := binary.BigEndian.Uint16(a.info); want == 0 || !this.entry(i, want) {
+		this.report(-1, "ConstantValue is %s for a field of type %s", this.describe(i), desc)
+	}
+}
+
+func (this *verifier) method(m *MethodInfo) {
+	name := this.utf8(m.name_index)
+	if !this.entry(m.name_index, CONSTANT_Utf8) || !validUnqualifiedName(name, true) {
+		this.report(-1, "invalid method name %s", this.describe(m.name_index))
+	}
+	desc := this.utf8(m.descriptor_index)
+	valid := this.entry(m.descriptor_index, CONSTANT_Utf8) && validMethodDescriptor(desc)
+	if !valid {
+		this.report(-1, "invalid method descriptor %q", desc)
+	} else if (name == "<init>" || name == "<clinit>") && !strings.HasSuffix(desc, ")V") {
+		this.report(-1, "%s must return void", name)
+	}
+	flags := m.access_flags
+	this.visibility(flags)
+	iface := this.class.access_flags&ACC_INTERFACE != 0
+	strict := this.major >= 46 && this.major <= 60
+	switch {
+	case name == "<clinit>":
+		if this.major >= 51 && flags&ACC_STATIC == 0 {
+			this.report(-1, "<clinit> is not static")
+		}
+	case name == "<init>":
+		if iface {
+			this.report(-1, "interface declares <init>")
+		}
+		if bad := flags & (ACC_STATIC | ACC_FINAL | ACC_SYNCHRONIZED | ACC_BRIDGE | ACC_NATIVE | ACC_ABSTRACT); bad != 0 {
+			this.report(-1, "<init> has flags %s", flagsText(methodFlagNames, bad))
+		}
+	case iface && this.major < 52:
+		if flags&(ACC_PUBLIC|ACC_ABSTRACT) != ACC_PUBLIC|ACC_ABSTRACT {
+			this.report(-1, "interface method must be public abstract before version 52")
+		}
+	case iface:
+		if bad := flags & (ACC_PROTECTED | ACC_FINAL | ACC_SYNCHRONIZED | ACC_NATIVE); bad != 0 {
+			this.report(-1, "interface method has flags %s", flagsText(methodFlagNames, bad))
+		}
+		if flags&(ACC_PUBLIC|ACC_PRIVATE) == 0 {
+			this.report(-1, "interface method is neither public nor private")
+		}
+	}
+	if flags&ACC_ABSTRACT != 0 && name != "<clinit>" {
+		bad := flags & (ACC_PRIVATE | ACC_STATIC | ACC_FINAL | ACC_SYNCHRONIZED | ACC_NATIVE)
+		if strict {
+			bad |= flags & ACC_STRICT
+		}
+		if bad != 0 {
+			this.report(-1, "abstract method has flags %s", flagsText(methodFlagNames, bad))
+		}
+	}
+	var code []*AttributeInfo
+	for i := range m.attributes {
+		if this.utf8(m.attributes[i].attribute_name_index) == "Code" {
+			code = append(code, &m.attributes[i])
+		}
+	}
+	switch {
+	case flags&(ACC_ABSTRACT|ACC_NATIVE) != 0 && name != "<clinit>":
+		if len(code) > 0 {
+			this.report(-1, "abstract or native method has code")
+		}
+	case len(code) == 0:
+		this.report(-1, "method has no Code attribute")
+	case len(code) > 1:
+		this.report(-1, "method has %d Code attributes", len(code))
+	case valid:
+		params, ret, _ := parseMethodDescriptor(desc)
+		this.code(code[0], name, flags&ACC_STATIC != 0, params, ret)
+	}
+}
+
+// code checks the instructions and the exception table, then the types
+// unless that already failed.
+func (this *verifier) code(a *AttributeInfo, name string, static bool, params []string, ret string) {
+	ca, err := a.ToCodeAttribute()
+	if err != nil {
+		this.report(-1, "Code attribute: %v", err)
+		return
+	}
+	n := len(this.violations)
+	args := slots(params)
+	if !static {
+		args++
+	}
+	if args > 255 {
+		this.report(-1, "%d parameter slots, at most 255 are allowed", args)
+	}
+	if args > int(ca.max_locals) {
+		this.report(-1, "parameters take %d local slots, max_locals is %d", args, ca.max_locals)
+	}
+	if len(ca.code) == 0 {
+		this.report(-1, "code is empty")
+		return
+	}
+	ins, err := decodeInstructions(ca.code)
+	if err != nil {
+		pc := 0
+		if len(ins) > 0 {
+			last := ins[len(ins)-1]
+			pc = last.pc + last.Len()
+		}
+		this.report(pc, "%v", err)
+		return
+	}
+	boundary := map[int]bool{}
+	for _, in := range ins {
+		boundary[in.pc] = true
+	}
+	subroutines := false
+	for _, in := range ins {
+		this.instruction(in, &ca, boundary)
+		if op := in.opcode; op == 0xa8 || op == 0xa9 || op == 0xc9 || op == 0xc4 && in.operands[0] == 0xa9 {
+			subroutines = true
+		}
+	}
+	for _, e := range ca.exception_table {
+		switch {
+		case !boundary[int(e.start_pc)] || !boundary[int(e.end_pc)] && int(e.end_pc) != len(ca.code):
+			this.report(int(e.start_pc), "exception range %d to %d does not fall on instructions", e.start_pc, e.end_pc)
+		case e.start_pc >= e.end_pc:
+			this.report(int(e.start_pc), "exception range %d to %d is empty", e.start_pc, e.end_pc)
+		}
+		if !boundary[int(e.handler_pc)] {
+			this.report(int(e.handler_pc), "exception handler is not at an instruction")
+		}
+		if e.catch_type != 0 && !this.entry(e.catch_type, CONSTANT_Class) {
+			this.report(int(e.handler_pc), "catch type is %s, expected a Class", this.describe(e.catch_type))
+		}
+	}
+	if len(this.violations) > n || subroutines || !this.typed {
+		return
+	}
+	t := &typeChecker{
+		verifier: this,
+		ca:       &ca,
+		ins:      ins,
+		name:     name,
+		static:   static,
+		params:   params,
+		ret:      ret,
+	}
+	t.check()
+}
+
+// instruction checks the operands of an instruction.
+func (this *verifier) instruction(in Instruction, ca *CodeAttribute, boundary map[int]bool) {
+	op, pc := in.opcode, in.pc
+	target := func(t int) {
+		if !boundary[t] {
+			this.report(pc, "%s to %d, which is not an instruction", in.Name(), t)
+		}
+	}
+	local := func(index int, wide bool) {
+		if wide {
+			index++
+		}
+		if index >= int(ca.max_locals) {
+			this.report(pc, "%s uses local %d, max_locals is %d", in.Name(), index, ca.max_locals)
+		}
+	}
+	cp := func(index uint16, tags ...ConstantTag) bool {
+		if this.entry(index, tags...) {
+			return true
+		}
+		var names []string
+		for _, tag := range tags {
+			names = append(names, tagName(tag))
+		}
+		this.report(pc, "%s of constant %s, expected %s", in.Name(), this.describe(index), strings.Join(names, " or "))
+		return false
+	}
+	u4 := func(i int) int {
+		return int(int32(binary.BigEndian.Uint32(in.operands[i:])))
+	}
+	category2 := func(op byte) bool {
+		switch op {
+		case 0x16, 0x18, 0x37, 0x39, 0x1e, 0x1f, 0x20, 0x21, 0x26, 0x27, 0x28, 0x29,
+			0x3f, 0x40, 0x41, 0x42, 0x47, 0x48, 0x49, 0x4a:
+			return true
+		}
+		return false
+	}
+	switch {
+	case op == 0xa8 || op == 0xa9 || op == 0xc9:
+		if this.major >= 51 {
+			this.report(pc, "%s is not allowed from class file version 51", in.Name())
+		}
+	}
+	switch {
+	case isLocalInstruction(op):
+		local(int(in.operands[0]), category2(op))
+	case op >= 0x1a && op <= 0x2d:
+		local(int(op-0x1a)%4, category2(op))
+	case op >= 0x3b && op <= 0x4e:
+		local(int(op-0x3b)%4, category2(op))
+	case op == 0x84:
+		local(int(in.operands[0]), false)
+	case op == 0xc4:
+		sub := in.operands[0]
+		if sub != 0x84 && !isLocalInstruction(sub) {
+			this.report(pc, "wide %s", opcodeNames[sub])
+			return
+		}
+		local(int(binary.BigEndian.Uint16(in.operands[1:])), category2(sub))
+	case op == 0x12 || op == 0x13:
+		index := in.Index()
+		if op == 0x12 {
+			index = uint16(in.operands[0])
+		}
+		tags := []ConstantTag{CONSTANT_Integer, CONSTANT_Float, CONSTANT_String}
+		if this.major >= 49 {
+			tags = append(tags, CONSTANT_Class)
+		}
+		if this.major >= 51 {
+			tags = append(tags, CONSTANT_MethodType, CONSTANT_MethodHandle)
+		}
+		if this.major >= 55 {
+			tags = append(tags, CONSTANT_Dynamic)
+		}
+		if cp(index, tags...) && this.entry(index, CONSTANT_Dynamic) && this.dynamicSize(index) != 1 {
+			this.report(pc, "%s of a long or double dynamic constant", in.Name())
+		}
+	case op == 0x14:
+		if cp(in.Index(), CONSTANT_Long, CONSTANT_Double, CONSTANT_Dynamic) && this.entry(in.Index(), CONSTANT_Dynamic) && this.dynamicSize(in.Index()) != 2 {
+			this.report(pc, "ldc2_w of a dynamic constant that is not long or double")
+		}
+	case op >= 0xb2 && op <= 0xb5:
+		cp(in.Index(), CONSTANT_Fieldref)
+	case op == 0xb6 || op == 0xb7 || op == 0xb8:
+		tags := []ConstantTag{CONSTANT_Methodref}
+		if op != 0xb6 && this.major >= 52 {
+			tags = append(tags, CONSTANT_InterfaceMethodref)
+		}
+		if cp(in.Index(), tags...) {
+			_, name, _, _ := memberRefAt(this.cp, in.Index())
+			if name == "<init>" && op != 0xb7 || name == "<clinit>" {
+				this.report(pc, "%s of %s", in.Name(), name)
+			}
+		}
+	case op == 0xb9:
+		if cp(in.Index(), CONSTANT_InterfaceMethodref) {
+			_, name, desc, _ := memberRefAt(this.cp, in.Index())
+			params, _, _ := parseMethodDescriptor(desc)
+			if strings.HasPrefix(name, "<") {
+				this.report(pc, "invokeinterface of %s", name)
+			}
+			if count := int(in.operands[2]); count != slots(params)+1 {
+				this.report(pc, "invokeinterface count %d, the arguments take %d", count, slots(params)+1)
+			}
+		}
+		if in.operands[3] != 0 {
+			this.report(pc, "invokeinterface with a non-zero fourth operand byte")
+		}
+	case op == 0xba:
+		cp(in.Index(), CONSTANT_InvokeDynamic)
+		if in.operands[2] != 0 || in.operands[3] != 0 {
+			this.report(pc, "invokedynamic with non-zero operand bytes")
+		}
+	case op == 0xbb:
+		if cp(in.Index(), CONSTANT_Class) && strings.HasPrefix(this.className(in.Index()), "[") {
+			this.report(pc, "new of array class %s", this.className(in.Index()))
+		}
+	case op == 0xbd || op == 0xc0 || op == 0xc1:
+		cp(in.Index(), CONSTANT_Class)
+	case op == 0xc5:
+		if cp(in.Index(), CONSTANT_Class) {
+			name := this.className(in.Index())
+			dims := int(in.operands[2])
+			if dims == 0 || dims > len(name)-len(strings.TrimLeft(name, "[")) {
+				this.report(pc, "multianewarray of %d dimensions of %s", dims, name)
+			}
+		}
+	case op == 0xbc:
+		if _, ok := newarrayTypes[in.operands[0]]; !ok {
+			this.report(pc, "newarray of unknown type %d", in.operands[0])
+		}
+	case op >= 0x99 && op <= 0xa8 || op == 0xc6 || op == 0xc7:
+		target(pc + int(int16(in.Index())))
+	case op == 0xc8 || op == 0xc9:
+		target(pc + u4(0))
+	case op == 0xaa || op == 0xab:
+		pad := 3 - pc%4
+		target(pc + u4(pad))
+		if op == 0xaa {
+			for i := pad + 12; i < len(in.operands); i += 4 {
+				target(pc + u4(i))
+			}
+			return
+		}
+		for i := pad + 8; i < len(in.operands); i += 8 {
+			if i > pad+8 && u4(i) <= u4(i-8) {
+				this.report(pc, "lookupswitch keys are not sorted")
+			}
+			target(pc + u4(i+4))
+		}
+	}
+}
+
+// dynamicSize returns the stack slots a Dynamic constant takes.
+func (this *verifier) dynamicSize(i uint16) int {
+	d := CONSTANT_Dynamic_info{this.cp[i-1]}
+	_, desc, err := nameAndTypeAt(this.cp, d.NameAndTypeIndex())
+	if err == nil && (desc == "J" || desc == "D") {
+		return 2
+	}
+	return 1
+}
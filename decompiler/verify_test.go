@@ -0,0 +1,140 @@
+package decompiler
+
+import (
+	"strings"
+	"testing"
+)
+
+func verify(t *testing.T, text string) []Violation {
+	d := parseClass(t, assemble(t, text))
+	violations, err := d.Verify()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return violations
+}
+
+func TestVerifyGolden(t *testing.T) {
+	for _, b := range goldenClasses(t) {
+		d := parseClass(t, b)
+		violations, err := d.Verify()
+		if err != nil || len(violations) > 0 {
+			name, _ := d.Name()
+			t.Errorf("%s: %v %v", name, err, violations)
+		}
+	}
+}
+
+// verifiable has branches, a loop, longs, an initialized new and an
+// exception handler, with the StackMapTable javac would write.
+const verifiable = `
+.version 52 0
+.class public super v/Ok
+.super java/lang/Object
+
+.method public <init> ()V
+	.code stack 1 locals 1
+		aload_0
+		invokespecial Method java/lang/Object <init> ()V
+		return
+	.end code
+.end method
+
+.method public static sum ([I)I
+	.code stack 3 locals 3
+		iconst_0
+		istore_1
+		iconst_0
+		istore_2
+	loop:	iload_2
+		aload_0
+		arraylength
+		if_icmpge done
+		iload_1
+		aload_0
+		iload_2
+		iaload
+		iadd
+		istore_1
+		iinc 2 1
+		goto loop
+	done:	iload_1
+		ireturn
+		.attribute StackMapTable { 0x0002 0xfd0004 0x0101 0x11 }
+	.end code
+.end method
+
+.method public static max (JJ)J
+	.code stack 4 locals 4
+		lload_0
+		lload_2
+		lcmp
+		ifle second
+		lload_0
+		goto end
+	second:	lload_2
+	end:	lreturn
+		.attribute StackMapTable { 0x0002 0x0a 0x4004 }
+	.end code
+.end method
+
+.method public static make ()Ljava/lang/Object;
+	.code stack 2 locals 1
+	start:	new Class java/lang/StringBuilder
+		dup
+		invokespecial Method java/lang/StringBuilder <init> ()V
+	end:	areturn
+	handler:	astore_0
+		aconst_null
+		areturn
+		.catch Class java/lang/Throwable from start to end using handler
+		.attribute StackMapTable { 0x0001 0x4807 Class java/lang/Throwable }
+	.end code
+.end method
+.end class
+`
+
+func TestVerify(t *testing.T) {
+	if violations := verify(t, verifiable); len(violations) > 0 {
+		t.Errorf("violations in a valid class: %v", violations)
+	}
+	// version 49 has no StackMapTable: the types are inferred
+	old := strings.NewReplacer(".version 52 0", ".version 49 0", "\t\t.attribute StackMapTable", "\t\t; ").Replace(verifiable)
+	if violations := verify(t, old); len(violations) > 0 {
+		t.Errorf("violations in a valid class without StackMapTable: %v", violations)
+	}
+}
+
+func TestVerifyViolations(t *testing.T) {
+	method := func(flags, desc, stack, body string) string {
+		return ".version 52 0\n.class public super v/Bad\n.super java/lang/Object\n" +
+			".method " + flags + " m " + desc + "\n.code stack " + stack + " locals 1\n" + body + "\n.end code\n.end method\n.end class\n"
+	}
+	for _, test := range []struct {
+		text, want string
+	}{
+		{".class public final abstract A\n.super java/lang/Object\n.end class\n", "class is both final and abstract"},
+		{".class interface abstract I\n.super java/lang/Object\n.field public x I\n.end class\n", "x: interface field must be public static final, is public"},
+		{".class A\n.super java/lang/Object\n.method public private m ()V\n.code stack 0 locals 1\nreturn\n.end code\n.end method\n.end class\n",
+			"m()V: more than one of public, private and protected"},
+		{".class A\n.super java/lang/Object\n.method abstract m ()V\n.code stack 0 locals 1\nreturn\n.end code\n.end method\n.end class\n",
+			"m()V: abstract or native method has code"},
+		{method("static", "()V", "1", "iconst_0\niconst_0\nreturn"), "m()V @1: stack exceeds max_stack 1"},
+		{method("static", "()V", "1", "iload 5\nreturn"), "m()V @0: iload uses local 5, max_locals is 1"},
+		{method("static", "()V", "1", "getstatic Class java/lang/Object\nreturn"), "getstatic of constant"},
+		{method("static", "()Ljava/lang/Object;", "1", "iconst_0\nareturn"), "m()Ljava/lang/Object; @1: expected Ljava/lang/Object;, found int"},
+		{method("static", "(I)V", "1", "iload_0\nifeq L\nL: return"), "m(I)V @1: no stack map frame at branch target 4"},
+		{method("static", "()V", "1", "iconst_0\npop"), "m()V @1: execution falls off the end of the code"},
+		{method("static", "()V", "2", "lconst_0\npop\nreturn"), "m()V @1: splits a long or double"},
+		{".version 52 0\n.class A\n.super java/lang/Object\n.method <init> ()V\n.code stack 0 locals 1\nreturn\n.end code\n.end method\n.end class\n",
+			"<init>()V @0: constructor returns before calling super or this"},
+	} {
+		var got []string
+		for _, v := range verify(t, test.text) {
+			got = append(got, v.Error())
+		}
+		if len(got) != 1 || !strings.Contains(got[0], test.want) {
+			t.Errorf("%q: got violations %q, want %s", test.text, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,98 @@
+package decompiler
+
+import "fmt"
+
+// Release is the Java SE release a class file was compiled for, derived
+// from its major and minor version. Classes compiled with --enable-preview
+// have minor version 0xFFFF.
+type Release struct {
+	major uint16
+	minor uint16
+}
+
+func (this *ClassFile) Release() Release {
+	return Release{
+		major: this.major_version,
+		minor: this.minor_version,
+	}
+}
+
+// Number returns the release number: 52 is Java 8, 65 is Java 21. Class
+// files older than 1.2 (major 45) are reported as 1.
+func (this Release) Number() int {
+	if this.major <= 45 {
+		return 1
+	}
+	return int(this.major) - 44
+}
+
+func (this Release) Preview() bool {
+	return this.major >= 56 && this.minor == 0xFFFF
+}
+
+func (this Release) String() string {
+	n := this.Number()
+	name := fmt.Sprintf("Java %d", n)
+	if n < 5 {
+		name = fmt.Sprintf("Java 1.%d", n)
+	}
+	if this.Preview() {
+		name += " (preview)"
+	}
+	return fmt.Sprintf("%s, class file version %d.%d", name, this.major, this.minor)
+}
+
+// Header is the comment written at the top of decompiled sources. It names
+// the javac options needed to compile the output at the original level.
+func (this Release) Header() string {
+	options := fmt.Sprintf("--release %d", this.Number())
+	if n := this.Number(); n < 5 {
+		options = fmt.Sprintf("-source 1.%d -target 1.%d", n, n)
+	} else if n < 9 {
+		options = fmt.Sprintf("-source %d -target %d", n, n)
+	}
+	if this.Preview() {
+		options += " --enable-preview"
+	}
+	return "/* " + this.String() + " (javac " + options + ") */\n"
+}
+
+// Feature is a language construct the emitter may only use when the
+// class file's release supports it.
+type Feature int
+
+const (
+	FeatureAnnotations Feature = iota
+	FeatureEnums
+	FeatureModules
+	FeatureTextBlocks
+	FeatureRecords
+	FeatureSealed
+)
+
+// features holds the release a feature became standard in and the first
+// release it was available as a preview, or 0 when it never was.
+var features = map[Feature]struct {
+	release int
+	preview int
+}{
+	FeatureAnnotations: {5, 0},
+	FeatureEnums:       {5, 0},
+	FeatureModules:     {9, 0},
+	FeatureTextBlocks:  {15, 13},
+	FeatureRecords:     {16, 14},
+	FeatureSealed:      {17, 15},
+}
+
+// Allows reports whether source using f compiles at this release.
+func (this Release) Allows(f Feature) bool {
+	n := this.Number()
+	feature, ok := features[f]
+	if !ok {
+		return false
+	}
+	if n >= feature.release {
+		return true
+	}
+	return this.Preview() && feature.preview != 0 && n >= feature.preview
+}
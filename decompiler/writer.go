@@ -0,0 +1,126 @@
+package decompiler
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+)
+
+// Class returns the parsed class file, e.g. to patch it and write it back.
+func (this *decompiler) Class() *ClassFile {
+	return &this.class
+}
+
+// Name returns the internal name of the class, e.g. java/lang/String.
+func (this *ClassFile) Name() (string, error) {
+	return classNameAt(this.constant_pool, this.this_class)
+}
+
+// classWriter writes the big endian items of a class file and keeps the
+// first error.
+type classWriter struct {
+	w   *bufio.Writer
+	n   int64
+	err error
+}
+
+func (this *classWriter) write(b []byte) {
+	if this.err != nil {
+		return
+	}
+	n, err := this.w.Write(b)
+	this.n += int64(n)
+	this.err = err
+}
+
+func (this *classWriter) u1(v byte) {
+	this.write([]byte{v})
+}
+
+func (this *classWriter) u2(v uint16) {
+	var b [2]byte
+	binary.BigEndian.PutUint16(b[:], v)
+	this.write(b[:])
+}
+
+func (this *classWriter) u4(v uint32) {
+	var b [4]byte
+	binary.BigEndian.PutUint32(b[:], v)
+	this.write(b[:])
+}
+
+// count writes the length of a table that the format limits to u2.
+func (this *classWriter) count(n int, what string) {
+	if n > math.MaxUint16 && this.err == nil {
+		this.err = fmt.Errorf("%d %s, at most %d fit in a class file", n, what, math.MaxUint16)
+	}
+	this.u2(uint16(n))
+}
+
+func (this *classWriter) attributes(attrs []AttributeInfo) {
+	this.count(len(attrs), "attributes")
+	for _, a := range attrs {
+		if int64(len(a.info)) > math.MaxUint32 && this.err == nil {
+			this.err = fmt.Errorf("attribute of %d bytes", len(a.info))
+		}
+		this.u2(a.attribute_name_index)
+		this.u4(uint32(len(a.info)))
+		this.write(a.info)
+	}
+}
+
+// WriteTo serializes the class in class file format. The counts are taken
+// from the tables, not from the *_count fields, so edited tables are
+// written consistently; an unmodified parsed class comes out byte for byte
+// as it was read.
+func (this *ClassFile) WriteTo(w io.Writer) (int64, error) {
+	cw := &classWriter{w: bufio.NewWriter(w)}
+	cw.u4(MAGIC)
+	cw.u2(this.minor_version)
+	cw.u2(this.major_version)
+	cw.count(len(this.constant_pool)+1, "constant pool entries")
+	for i := 0; i < len(this.constant_pool); i++ {
+		c := this.constant_pool[i]
+		cw.u1(byte(c.tag))
+		cw.write(c.info)
+		if c.tag == CONSTANT_Long || c.tag == CONSTANT_Double {
+			i++
+		}
+	}
+	cw.u2(uint16(this.access_flags))
+	cw.u2(this.this_class)
+	cw.u2(this.super_class)
+	cw.count(len(this.interfaces), "interfaces")
+	for _, i := range this.interfaces {
+		cw.u2(i)
+	}
+	cw.count(len(this.fields), "fields")
+	for _, f := range this.fields {
+		cw.u2(uint16(f.access_flags))
+		cw.u2(f.name_index)
+		cw.u2(f.descriptor_index)
+		cw.attributes(f.attributes)
+	}
+	cw.count(len(this.methods), "methods")
+	for _, m := range this.methods {
+		cw.u2(uint16(m.access_flags))
+		cw.u2(m.name_index)
+		cw.u2(m.descriptor_index)
+		cw.attributes(m.attributes)
+	}
+	cw.attributes(this.attributes)
+	if cw.err == nil {
+		cw.err = cw.w.Flush()
+	}
+	return cw.n, cw.err
+}
+
+// Bytes returns the serialized class.
+func (this *ClassFile) Bytes() ([]byte, error) {
+	var b bytes.Buffer
+	_, err := this.WriteTo(&b)
+	return b.Bytes(), err
+}
@@ -0,0 +1,60 @@
+package decompiler
+
+import (
+	"bytes"
+	"testing"
+)
+
+func parseClass(t *testing.T, b []byte) *decompiler {
+	d := New("test.class")
+	if err := d.Parse(bytes.NewReader(b)); err != nil {
+		t.Fatal(err)
+	}
+	return d
+}
+
+func TestWriteRoundTrip(t *testing.T) {
+	for _, b := range goldenClasses(t) {
+		out, err := parseClass(t, b).Class().Bytes()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(out, b) {
+			t.Errorf("%d bytes written for %d read", len(out), len(b))
+		}
+	}
+}
+
+func TestReindex(t *testing.T) {
+	for _, b := range goldenClasses(t) {
+		d := parseClass(t, b)
+		want, err := d.Source()
+		if err != nil {
+			t.Fatal(err)
+		}
+		class := d.Class()
+		class.AddUtf8("unused")
+		class.AddLong(42)
+		class.AddMemberRef(CONSTANT_Methodref, "java/lang/Object", "unused", "()V")
+		// a second entry equal to this_class that the class now points to
+		name, _ := d.Name()
+		class.constant_pool = append(class.constant_pool, CpInfo{CONSTANT_Class, append([]byte{}, class.constant_pool[class.this_class-1].info...)})
+		class.this_class = uint16(len(class.constant_pool))
+		if n, _ := d.Name(); n != name {
+			t.Fatalf("this_class %s, want %s", n, name)
+		}
+		if err := class.Reindex(); err != nil {
+			t.Fatal(err)
+		}
+		out, err := class.Bytes()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(out, b) {
+			t.Errorf("%s: %d bytes after reindexing, %d before", name, len(out), len(b))
+		}
+		if got, err := parseClass(t, out).Source(); err != nil || got != want {
+			t.Errorf("%s: source differs after reindexing: %v", name, err)
+		}
+	}
+}
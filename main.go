@@ -1,51 +1,655 @@
 package main
 
 import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"localhost/javadecompiler/decompiler"
-	"log"
 	"os"
 	"path"
+	"path/filepath"
+	"regexp"
+	"strconv"
 	"strings"
 )
 
-var (
-	output    *string
-	outputDir *string
-	fName     string
+// Exit codes. A run with both parse errors and failed classes exits with
+// exitParse.
+const (
+	exitFailures = 1 // some classes or members could not be decompiled, or do not verify
+	exitUsage    = 2
+	exitParse    = 3 // an input could not be read or a class file not parsed
 )
 
-func init() {
-	if len(os.Args) > 0 {
-		fName = os.Args[len(os.Args)-1]
-		output = new(string)
-		outputDir = new(string)
-		defOutput := fName[0:len(fName)-len(path.Ext(fName))] + ".java"
-		flag.StringVar(output, "output", defOutput, "output file")
-		flag.StringVar(outputDir, "outputdir", "./", "path to outputdir, only war or jar file")
-		flag.Parse()
-	} else {
-		fmt.Printf("please use help\n\tRequired argument not specified FILENAME")
+type command struct {
+	name    string
+	args    string
+	summary string
+	run     func(c *config, args []string) int
+}
+
+func commands() []command {
+	return []command{
+		{"decompile", "[flags] input...", "write Java source for the classes of the inputs", decompile},
+		{"disasm", "[flags] input...", "print classes with their bytecode in the text format of assemble", disasm},
+		{"assemble", "[flags] file...", "write class files from the text format of disasm", assemble},
+		{"verify", "[flags] input...", "check classes against the structural constraints of the JVM specification", verify},
+		{"list", "[flags] input...", "print the names of the classes and library jars of the inputs", list},
+		{"info", "[flags] input...", "print version, flags, super types and attributes of each class", info},
+		{"search", "[flags] pattern input...", "print class names, members and string constants matching a regular expression", search},
+	}
+}
+
+func usage(w io.Writer) {
+	name := path.Base(os.Args[0])
+	fmt.Fprintf(w, "usage: %s command [flags] input...\n\ncommands:\n", name)
+	for _, c := range commands() {
+		fmt.Fprintf(w, "\t%-10s %s\n", c.name, c.summary)
 	}
+	fmt.Fprintf(w, "\ninputs are .class files, directories of classes, jar, war, ear and jmod files,\n"+
+		"the lib/modules image of a JDK, or - for a class file or archive on standard input.\n"+
+		"Run %s command -h for the flags of a command.\n", name)
 }
 
 func main() {
-	if fName == "" {
-		fmt.Print("input file not specified")
-		flag.CommandLine.Usage()
-		os.Exit(1)
-	}
-	switch strings.ToLower(path.Ext(fName))[1:] {
-	case "war":
-		fmt.Print("TODO: war file")
-	case "jar":
-		fmt.Print("TODO: jar file")
-	case "class":
-		d := decompiler.New(fName)
-		if err := d.ParseFile(); err != nil {
-			log.Panic(err)
-		}
-		d.WriteFile(*output)
+	os.Exit(run(os.Args[1:]))
+}
+
+func run(args []string) int {
+	if len(args) == 0 {
+		usage(os.Stderr)
+		return exitUsage
+	}
+	switch args[0] {
+	case "-h", "-help", "--help", "help":
+		usage(os.Stdout)
+		return 0
+	}
+	for _, cmd := range commands() {
+		if cmd.name != args[0] {
+			continue
+		}
+		c := &config{}
+		fs := c.flags(cmd)
+		inputs, err := parse(fs, args[1:])
+		if err != nil {
+			if errors.Is(err, flag.ErrHelp) {
+				return 0
+			}
+			return exitUsage
+		}
+		mode, ok := decompiler.ParseLineNumberMode(c.lineNumbers)
+		if !ok {
+			fmt.Fprintf(os.Stderr, "unknown -linenumbers %q\n", c.lineNumbers)
+			return exitUsage
+		}
+		c.options.LineNumbers = mode
+		if c.filter, err = decompiler.NewFilter(c.include, c.exclude); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return exitUsage
+		}
+		if c.classPath, err = decompiler.NewClassPath(filepath.SplitList(c.classPathList)); err != nil {
+			fmt.Fprintln(os.Stderr, "-classpath:", err)
+			return exitUsage
+		}
+		defer c.classPath.Close()
+		fs.Visit(func(f *flag.Flag) {
+			c.set = append(c.set, f.Name)
+		})
+		return cmd.run(c, inputs)
+	}
+	fmt.Fprintf(os.Stderr, "unknown command %q\n\n", args[0])
+	usage(os.Stderr)
+	return exitUsage
+}
+
+// parse allows flags after the inputs too, as in "decompile app.jar -j 4".
+// Arguments after "--" are all inputs.
+func parse(fs *flag.FlagSet, args []string) ([]string, error) {
+	var inputs []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			return nil, err
+		}
+		rest := fs.Args()
+		if len(rest) == 0 {
+			return inputs, nil
+		}
+		if len(args) > len(rest) && args[len(args)-len(rest)-1] == "--" {
+			return append(inputs, rest...), nil
+		}
+		inputs = append(inputs, rest[0])
+		args = rest[1:]
+	}
+}
+
+// config holds the flags of a command.
+type config struct {
+	output      string
+	outputDir   string
+	lineNumbers string
+	class       string
+	format      string
+	code        bool
+	jobs        int
+	release     int
+	libs        bool
+	include     patterns
+	exclude     patterns
+	filter      *decompiler.Filter
+	// dependencies and the JDK, to resolve types of other classes
+	classPathList string
+	classPath     *decompiler.ClassPath
+	options       decompiler.Options
+	set           []string
+}
+
+// patterns collects a flag given several times.
+type patterns []string
+
+func (this *patterns) String() string {
+	return strings.Join(*this, ",")
+}
+
+func (this *patterns) Set(value string) error {
+	*this = append(*this, value)
+	return nil
+}
+
+func (this *config) flags(cmd command) *flag.FlagSet {
+	fs := flag.NewFlagSet(cmd.name, flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: %s %s %s\n\n%s\n\n", path.Base(os.Args[0]), cmd.name, cmd.args, cmd.summary)
+		fs.PrintDefaults()
+	}
+	fs.IntVar(&this.jobs, "j", 0, "number of classes processed in parallel, 0 for one per CPU")
+	fs.IntVar(&this.release, "release", 0, "for multi release jars, use the classes a JVM of this release loads instead of each META-INF/versions/N separately")
+	fs.StringVar(&this.class, "class", "", "only this class of an archive, e.g. java/lang/String or java.base/java/lang/String in lib/modules")
+	fs.BoolVar(&this.libs, "libs", false, "also read the jars of BOOT-INF/lib and WEB-INF/lib; decompile writes each to OUTPUTDIR/lib/NAME")
+	fs.StringVar(&this.classPathList, "classpath", "", "directories, jar and jmod files and JDK homes the inputs depend on, separated by "+string(filepath.ListSeparator))
+	fs.Var(&this.include, "include", "only classes matching this glob (com/acme/**) or regular expression (re:com/acme/.*Impl); may be repeated")
+	fs.Var(&this.exclude, "exclude", "skip classes matching this glob (**/*Test) or regular expression; may be repeated")
+	this.lineNumbers = "off"
+	if cmd.name == "decompile" {
+		fs.StringVar(&this.output, "output", "", "write all sources to this file, - for standard output (the default for input -)")
+		fs.StringVar(&this.outputDir, "outputdir", ".", "write each top level class to OUTPUTDIR/package/Name.java")
+		fs.StringVar(&this.lineNumbers, "linenumbers", "off", "source line numbers: off, comment (/* 42 */ before statements) or align (keep statements on their original line)")
+	} else if cmd.name == "assemble" {
+		fs.StringVar(&this.output, "output", "", "write the class to this file instead, - for standard output")
+		fs.StringVar(&this.outputDir, "outputdir", ".", "write each class to OUTPUTDIR/package/Name.class")
+	} else {
+		fs.StringVar(&this.output, "output", "-", "output file, - for standard output")
+	}
+	if cmd.name == "info" {
+		fs.StringVar(&this.format, "format", "text", "text, or json for one object per class and line in the schema of decompiler.ClassDump")
+		fs.BoolVar(&this.code, "code", false, "with -format=json, also dump the instructions of the methods")
+	}
+	return fs
+}
+
+func (this *config) isSet(name string) bool {
+	for _, n := range this.set {
+		if n == name {
+			return true
+		}
 	}
+	return false
+}
+
+// create opens the -output file; "-" is standard output.
+func (this *config) create() (io.Writer, func() error, error) {
+	if this.output == "-" {
+		return os.Stdout, func() error { return nil }, nil
+	}
+	f, err := os.Create(this.output)
+	if err != nil {
+		return nil, nil, err
+	}
+	return f, f.Close, nil
+}
+
+// unit is a group of classes written to one output directory: a class
+// file, a directory, an archive, a version of a multi release jar, a
+// library jar, an ear module or a module of a jimage.
+type unit struct {
+	dir         string
+	load        func(set *decompiler.ClassSet) error
+	library     string              // "name\tgroup:artifact:version" of a library jar
+	descriptors *decompiler.Archive // deployment descriptors to copy
+}
+
+// units splits an input into units. The returned function releases open
+// archives once the units are done.
+func (this *config) units(input string) ([]unit, func(), error) {
+	done := func() {}
+	if input == "-" {
+		data, err := io.ReadAll(os.Stdin)
+		if err != nil {
+			return nil, done, err
+		}
+		if len(data) >= 4 && binary.BigEndian.Uint32(data) == decompiler.MAGIC {
+			return []unit{{load: func(set *decompiler.ClassSet) error {
+				return set.AddReader("<stdin>", bytes.NewReader(data))
+			}}}, done, nil
+		}
+		a, err := decompiler.NewArchive("<stdin>", bytes.NewReader(data), int64(len(data)))
+		if err != nil {
+			return nil, done, err
+		}
+		units, err := this.archiveUnits(a, "")
+		return units, done, err
+	}
+	if info, err := os.Stat(input); err != nil {
+		return nil, done, err
+	} else if info.IsDir() {
+		return []unit{{load: func(set *decompiler.ClassSet) error {
+			return set.AddDir(input)
+		}}}, done, nil
+	}
+	if strings.EqualFold(path.Ext(input), ".class") {
+		return []unit{{load: func(set *decompiler.ClassSet) error {
+			return set.AddFile(input)
+		}}}, done, nil
+	}
+	a, err := decompiler.OpenArchive(input)
+	if err != nil {
+		return nil, done, err
+	}
+	done = func() {
+		a.Close()
+	}
+	if strings.EqualFold(path.Ext(input), ".ear") && this.class == "" {
+		units, err := this.earUnits(a)
+		return units, done, err
+	}
+	units, err := this.archiveUnits(a, "")
+	return units, done, err
+}
+
+// archiveUnits lists the application classes of a jar, war or jmod, or
+// with -class just one of them, and its nested library jars. With -release
+// the variant of each class for that release is used; otherwise the
+// classes of each META-INF/versions/N of a multi release jar are a unit of
+// their own in dir/META-INF/versions/N. The modules of a jimage go to
+// dir/MODULE.
+func (this *config) archiveUnits(a *decompiler.Archive, dir string) ([]unit, error) {
+	if this.class != "" {
+		return []unit{{dir: dir, load: func(set *decompiler.ClassSet) error {
+			return set.AddClass(a, this.class, this.release)
+		}}}, nil
+	}
+	if modules := a.JavaModules(); modules != nil {
+		var units []unit
+		for _, m := range modules {
+			module := a.JavaModule(m)
+			units = append(units, unit{dir: path.Join(dir, m), load: func(set *decompiler.ClassSet) error {
+				return set.AddClasses(module, this.release)
+			}})
+		}
+		return units, nil
+	}
+	units := []unit{{dir: dir, load: func(set *decompiler.ClassSet) error {
+		return set.AddClasses(a, this.release)
+	}}}
+	if this.release == 0 {
+		for _, v := range a.Versions() {
+			v := v
+			units = append(units, unit{dir: path.Join(dir, "META-INF", "versions", strconv.Itoa(v)), load: func(set *decompiler.ClassSet) error {
+				return set.AddVersion(a, v)
+			}})
+		}
+	}
+	nested, err := a.Nested()
+	if err != nil {
+		return units, err
+	}
+	for _, lib := range nested {
+		name := path.Base(lib.Path())
+		library := name + "\t?"
+		if coordinates, ok, err := lib.Coordinates(); err != nil {
+			return units, err
+		} else if ok {
+			library = name + "\t" + coordinates.String()
+		}
+		units = append(units, unit{library: library})
+		if this.libs {
+			libUnits, err := this.archiveUnits(lib, path.Join(dir, "lib", strings.TrimSuffix(name, path.Ext(name))))
+			if err != nil {
+				return units, err
+			}
+			units = append(units, libUnits...)
+		}
+	}
+	return units, nil
+}
+
+// earUnits lists the modules of an ear file as units in the directory
+// named by their URI, e.g. shop.war, with the deployment descriptors of
+// the ear and of its modules.
+func (this *config) earUnits(ear *decompiler.Archive) ([]unit, error) {
+	units := []unit{{descriptors: ear}}
+	modules, err := ear.Modules()
+	if err != nil {
+		return units, err
+	}
+	for _, m := range modules {
+		a, err := ear.OpenModule(m)
+		if err != nil {
+			return units, err
+		}
+		dir := path.Clean(m.URI)
+		moduleUnits, err := this.archiveUnits(a, dir)
+		if err != nil {
+			return units, err
+		}
+		units = append(units, unit{dir: dir, descriptors: a})
+		units = append(units, moduleUnits...)
+	}
+	return units, nil
+}
+
+// each loads the units of every input and calls visit with their classes.
+// Library units that are only listed have no classes.
+func (this *config) each(inputs []string, r *report, visit func(u unit, set *decompiler.ClassSet)) {
+	for _, input := range inputs {
+		units, done, err := this.units(input)
+		if err != nil {
+			r.parseError(fmt.Errorf("%s: %w", input, err))
+		}
+		for _, u := range units {
+			set := decompiler.NewClassSet()
+			set.SetJobs(this.jobs)
+			set.SetFilter(this.filter)
+			set.SetClassPath(this.classPath)
+			if u.load != nil {
+				r.parseError(u.load(set))
+				r.classes += len(set.Names())
+			}
+			visit(u, set)
+		}
+		done()
+	}
+}
+
+// report collects the outcome of a run.
+type report struct {
+	classes  int
+	parse    []error
+	failures []error
+}
+
+func flatten(err error) []error {
+	var list decompiler.Errors
+	if errors.As(err, &list) {
+		errs := make([]error, len(list))
+		for i, e := range list {
+			errs[i] = e
+		}
+		return errs
+	}
+	if err != nil {
+		return []error{err}
+	}
+	return nil
+}
+
+func (this *report) parseError(err error) {
+	this.parse = append(this.parse, flatten(err)...)
+}
+
+func (this *report) failure(err error) {
+	this.failures = append(this.failures, flatten(err)...)
+}
+
+// exit prints the errors to stderr, one class or member per line, and
+// returns the exit code.
+func (this *report) exit() int {
+	if len(this.parse)+len(this.failures) == 0 {
+		return 0
+	}
+	fmt.Fprintf(os.Stderr, "%d classes read, %d parse errors, %d failures:\n", this.classes, len(this.parse), len(this.failures))
+	for _, err := range append(this.parse, this.failures...) {
+		fmt.Fprintln(os.Stderr, "\t"+err.Error())
+	}
+	if len(this.parse) > 0 {
+		return exitParse
+	}
+	return exitFailures
+}
+
+func decompile(c *config, inputs []string) int {
+	if len(inputs) == 0 {
+		fmt.Fprintln(os.Stderr, "no input specified")
+		return exitUsage
+	}
+	for _, input := range inputs {
+		if input == "-" && !c.isSet("output") && !c.isSet("outputdir") {
+			c.output = "-"
+		}
+	}
+	var out io.Writer
+	if c.output != "" {
+		w, close, err := c.create()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return exitUsage
+		}
+		defer close()
+		out = w
+	}
+	// libraries are listed on standard output unless the sources go there
+	notes := io.Writer(os.Stdout)
+	if out == os.Stdout {
+		notes = os.Stderr
+	}
+	r := &report{}
+	c.each(inputs, r, func(u unit, set *decompiler.ClassSet) {
+		if u.library != "" {
+			fmt.Fprintln(notes, u.library)
+		}
+		dir := filepath.Join(c.outputDir, filepath.FromSlash(u.dir))
+		if u.descriptors != nil && out == nil {
+			r.failure(u.descriptors.WriteDescriptors(dir))
+		}
+		if u.load == nil {
+			return
+		}
+		if out != nil {
+			r.failure(set.Write(out, c.options))
+		} else {
+			r.failure(set.WriteDir(dir, c.options))
+		}
+	})
+	return r.exit()
+}
+
+// eachClass writes text for every class of the inputs to -output, with
+// blank lines between classes when separate is set. The library units
+// are passed with an empty name.
+func eachClass(c *config, inputs []string, separate bool, text func(u unit, name string, set *decompiler.ClassSet) (string, error)) int {
+	if len(inputs) == 0 {
+		fmt.Fprintln(os.Stderr, "no input specified")
+		return exitUsage
+	}
+	out, close, err := c.create()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitUsage
+	}
+	defer close()
+	r := &report{}
+	first := true
+	c.each(inputs, r, func(u unit, set *decompiler.ClassSet) {
+		names := set.Names()
+		if u.library != "" {
+			names = []string{""}
+		}
+		for _, name := range names {
+			s, err := text(u, name, set)
+			if err != nil {
+				r.failure(decompiler.ClassError{Path: name, Err: err})
+				continue
+			}
+			if s == "" {
+				continue
+			}
+			if separate && !first {
+				fmt.Fprintln(out)
+			}
+			first = false
+			fmt.Fprint(out, s)
+		}
+	})
+	return r.exit()
+}
+
+func disasm(c *config, inputs []string) int {
+	return eachClass(c, inputs, true, func(u unit, name string, set *decompiler.ClassSet) (string, error) {
+		if name == "" {
+			return "", nil
+		}
+		return set.Lookup(name).Disassemble()
+	})
+}
+
+// verify prints the violations of each class, one per line, and exits
+// with exitFailures when there are any.
+func verify(c *config, inputs []string) int {
+	violations := 0
+	code := eachClass(c, inputs, false, func(u unit, name string, set *decompiler.ClassSet) (string, error) {
+		if name == "" {
+			return "", nil
+		}
+		list, err := set.Lookup(name).Verify()
+		var b strings.Builder
+		for _, v := range list {
+			if v.Member == "" {
+				fmt.Fprintf(&b, "%s: %s\n", name, v.Message)
+			} else {
+				fmt.Fprintf(&b, "%s.%s\n", name, v.Error())
+			}
+		}
+		violations += len(list)
+		return b.String(), err
+	})
+	if code == 0 && violations > 0 {
+		return exitFailures
+	}
+	return code
+}
+
+// assemble writes the class of each file to -outputdir, or with -output
+// the class of a single file to that file. "-" reads standard input.
+func assemble(c *config, inputs []string) int {
+	if len(inputs) == 0 {
+		fmt.Fprintln(os.Stderr, "no input specified")
+		return exitUsage
+	}
+	if c.output != "" && len(inputs) > 1 {
+		fmt.Fprintln(os.Stderr, "-output takes a single input")
+		return exitUsage
+	}
+	r := &report{}
+	for _, input := range inputs {
+		var class *decompiler.ClassFile
+		var err error
+		if input == "-" {
+			class, err = decompiler.Assemble("<stdin>", os.Stdin)
+		} else if f, openErr := os.Open(input); openErr != nil {
+			err = openErr
+		} else {
+			class, err = decompiler.Assemble(input, f)
+			f.Close()
+		}
+		if err != nil {
+			r.parseError(err)
+			continue
+		}
+		r.classes++
+		r.failure(c.writeClass(input, class))
+	}
+	return r.exit()
+}
+
+func (this *config) writeClass(input string, class *decompiler.ClassFile) error {
+	b, err := class.Bytes()
+	if err != nil {
+		return fmt.Errorf("%s: %w", input, err)
+	}
+	if this.output != "" {
+		w, close, err := this.create()
+		if err != nil {
+			return err
+		}
+		if _, err := w.Write(b); err != nil {
+			close()
+			return err
+		}
+		return close()
+	}
+	name, err := class.Name()
+	if err != nil {
+		return fmt.Errorf("%s: %w", input, err)
+	}
+	file := filepath.Join(this.outputDir, filepath.FromSlash(name)+".class")
+	if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(file, b, 0644)
+}
+
+func info(c *config, inputs []string) int {
+	if c.format != "text" && c.format != "json" {
+		fmt.Fprintf(os.Stderr, "unknown -format %q\n", c.format)
+		return exitUsage
+	}
+	return eachClass(c, inputs, c.format == "text", func(u unit, name string, set *decompiler.ClassSet) (string, error) {
+		if name == "" {
+			return "", nil
+		}
+		if c.format == "text" {
+			return set.Lookup(name).Info()
+		}
+		dump, err := set.Lookup(name).Dump(c.code)
+		if err != nil {
+			return "", err
+		}
+		b, err := json.Marshal(dump)
+		return string(b) + "\n", err
+	})
+}
+
+func list(c *config, inputs []string) int {
+	return eachClass(c, inputs, false, func(u unit, name string, set *decompiler.ClassSet) (string, error) {
+		if name == "" {
+			return "library " + u.library + "\n", nil
+		}
+		return path.Join(u.dir, name) + "\n", nil
+	})
+}
+
+func search(c *config, args []string) int {
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, "no pattern specified")
+		return exitUsage
+	}
+	re, err := regexp.Compile(args[0])
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitUsage
+	}
+	return eachClass(c, args[1:], false, func(u unit, name string, set *decompiler.ClassSet) (string, error) {
+		if name == "" {
+			return "", nil
+		}
+		text := ""
+		for _, match := range set.Lookup(name).Search(re) {
+			text += path.Join(u.dir, name) + "\t" + match + "\n"
+		}
+		return text, nil
+	})
 }